@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"sync"
+	"strconv"
+	"time"
 )
 
 // вы можете использовать ApiError в коде, который получается в результате генерации
@@ -26,31 +31,86 @@ const (
 	statusAdmin     = 20
 )
 
+// apigen:struct {"cors": {"origins": ["http://localhost:3000"], "credentials": true, "max_age": 600}, "roles": {"user": 0, "moderator": 10, "admin": 20}}
 type MyApi struct {
 	statuses map[string]int
-	users    map[string]*User
-	nextID   uint64
-	mu       *sync.RWMutex
+	users    UserStore
+
+	// Logger - куда сгенерированные обработчики пишут лог запросов, по умолчанию slog.Default()
+	Logger *slog.Logger
+	// SpanExporter - куда отдавать спаны запросов, nil - никуда
+	SpanExporter SpanExporter
+	// RateLimiter - где хранить token bucket'ы для ratelimit, nil - общий на процесс
+	RateLimiter RateLimiterStore
+	// PanicHook - кому сообщать о панике в обработчике, кроме лога
+	PanicHook PanicHook
+	// ResponseCache - кэш ответов эндпоинтов с "cache": {"ttl": ...}, nil - общий на процесс
+	ResponseCache ResponseCache
+	// IdempotencyStore - ответы эндпоинтов с "idempotent": true по Idempotency-Key, nil - общее на процесс
+	IdempotencyStore IdempotencyStore
+	// Authenticator - проверка X-Auth, у MyApi это сам MyApi.Authenticate
+	Authenticator Authenticator
+	// AuditSink - журнал успешных изменений, nil - не пишется. Эндпоинт /audit читает его, если это AuditLog.
+	// По умолчанию - InMemoryAuditLog с последними записями, main с -audit пишет в файл
+	AuditSink AuditSink
+
+	// Sessions выдаёт и проверяет токены из Login
+	Sessions *SessionTokens
+	// ServiceToken - статический токен для сервисных вызовов с правами админа, пустой - выключен
+	ServiceToken string
+}
+
+// Сервисный токен из исходного задания, с ним ходят тесты. main без флага его выключает
+const defaultServiceToken = "100500"
+
+// defaultUsers - с ними стартует пустое хранилище. Пароля у rvasily нет, пока его не задаст
+// setInitialPassword (в main - флаг -admin-password), до тех пор войти под ним через Login нельзя
+func defaultUsers() []*User {
+	return []*User{
+		&User{
+			ID:       42,
+			Login:    "rvasily",
+			FullName: "Vasily Romanov",
+			Status:   statusAdmin,
+		},
+	}
+}
+
+// setInitialPassword задаёт пароль пользователю, у которого его ещё нет. Уже заданный пароль не трогает
+func setInitialPassword(users UserStore, login string, password string) error {
+	user, err := users.GetByLogin(login)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash != "" {
+		return nil
+	}
+	user.PasswordHash = hashPassword(password)
+	return users.Update(user)
 }
 
 func NewMyApi() *MyApi {
-	return &MyApi{
+	return NewMyApiWithStore(NewInMemoryUserStore(defaultUsers()...))
+}
+
+// NewMyApiWithStore - MyApi поверх заданного хранилища пользователей
+func NewMyApiWithStore(users UserStore) *MyApi {
+	srv := &MyApi{
 		statuses: map[string]int{
 			"user":      0,
 			"moderator": 10,
 			"admin":     20,
 		},
-		users: map[string]*User{
-			"rvasily": &User{
-				ID:       42,
-				Login:    "rvasily",
-				FullName: "Vasily Romanov",
-				Status:   statusAdmin,
-			},
-		},
-		nextID: 43,
-		mu:     &sync.RWMutex{},
+		users:            users,
+		RateLimiter:      &InMemoryRateLimiterStore{},
+		ResponseCache:    &InMemoryResponseCache{},
+		IdempotencyStore: &InMemoryIdempotencyStore{},
+		AuditSink:        &InMemoryAuditLog{},
+		Sessions:         NewSessionTokens(randomSecret(), sessionTTL),
+		ServiceToken:     defaultServiceToken,
 	}
+	srv.Authenticator = srv
+	return srv
 }
 
 type ProfileParams struct {
@@ -62,6 +122,46 @@ type CreateParams struct {
 	Name   string `apivalidator:"paramname=full_name"`
 	Status string `apivalidator:"enum=user|moderator|admin,default=user"`
 	Age    int    `apivalidator:"min=0,max=128"`
+	// Без пароля пользователь есть, но войти через Login не может
+	Password string `apivalidator:"min=8,max=128,classes=lower|upper|digit,sensitive,omitempty"`
+}
+
+type UpdateParams struct {
+	ID       int    `apivalidator:"required,min=1"`
+	FullName string `apivalidator:"paramname=full_name,max=128"`
+	Status   string `apivalidator:"enum=user|moderator|admin"`
+}
+
+type DeleteParams struct {
+	ID int `apivalidator:"required,min=1"`
+}
+
+type ListParams struct {
+	Cursor      string `apivalidator:"max=64"`
+	Limit       int    `apivalidator:"min=1,max=100,default=20"`
+	Status      string `apivalidator:"enum=user|moderator|admin"`
+	LoginPrefix string `apivalidator:"paramname=login_prefix,max=64"`
+}
+
+type LoginParams struct {
+	Login    string `apivalidator:"required"`
+	Password string `apivalidator:"required,sensitive"`
+}
+
+type LogoutParams struct{}
+
+type RefreshParams struct{}
+
+type AuditParams struct {
+	User  string `apivalidator:"max=128"`
+	From  int    `apivalidator:"min=0,default=0"` // unix-время, 0 - с начала журнала
+	To    int    `apivalidator:"min=0,default=0"` // unix-время не включительно, 0 - до конца журнала
+	Limit int    `apivalidator:"min=1,max=1000,default=100"`
+}
+
+type ChangePasswordParams struct {
+	OldPassword string `apivalidator:"paramname=old_password,required,sensitive"`
+	NewPassword string `apivalidator:"paramname=new_password,required,min=8,max=128,classes=lower|upper|digit,sensitive"`
 }
 
 type User struct {
@@ -69,54 +169,385 @@ type User struct {
 	Login    string `json:"login"`
 	FullName string `json:"full_name"`
 	Status   int    `json:"status"`
+	// Хэш пароля наружу не отдаётся, его хранит только UserStore
+	PasswordHash string `json:"-"`
+	// Когда менялся пароль, unix-время в наносекундах: сессии, выданные раньше, недействительны
+	PasswordChangedAt int64 `json:"-"`
 }
 
 type NewUser struct {
 	ID uint64 `json:"id"`
 }
 
-// apigen:api {"url": "/user/profile", "auth": false}
+type UserList struct {
+	Users []*User `json:"users"`
+	// Курсор следующей страницы, пустой - страница последняя
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type SessionToken struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"` // unix-время
+}
+
+type LogoutResult struct {
+	LoggedOut bool `json:"logged_out"`
+}
+
+type ChangePasswordResult struct {
+	Changed bool `json:"changed"`
+}
+
+// AuditRecord - запись журнала аудита в ответе /audit
+type AuditRecord struct {
+	Time      int64             `json:"time"` // unix-время
+	RequestID string            `json:"request_id"`
+	User      string            `json:"user"`
+	Endpoint  string            `json:"endpoint"`
+	Method    string            `json:"method"`
+	Params    map[string]string `json:"params"`
+	ResultID  string            `json:"result_id,omitempty"`
+}
+
+type AuditTrail struct {
+	Records []*AuditRecord `json:"records"`
+}
+
+// serviceUser - кто стоит за ServiceToken: сервисный доступ с правами админа
+var serviceUser = &User{Login: "service", Status: statusAdmin}
+
+type userKey struct{}
+
+type sessionKey struct{}
+
+// ContextWithUser кладёт в контекст пользователя, от имени которого идёт вызов
+func ContextWithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userKey{}).(*User)
+	return user, ok
+}
+
+// caller - кто вызывает метод с "auth": true, его кладёт в контекст Authenticate
+func (srv *MyApi) caller(ctx context.Context) (*User, error) {
+	if user, ok := UserFromContext(ctx); ok {
+		return user, nil
+	}
+	return nil, ApiError{http.StatusForbidden, fmt.Errorf("unauthorized")}
+}
+
+// Authenticate проверяет X-Auth: токен сессии из Login или ServiceToken.
+// Пользователь сессии перечитывается из хранилища, так что удалённый теряет доступ сразу
+func (srv *MyApi) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	if srv.ServiceToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(srv.ServiceToken)) == 1 {
+		return srv.withCaller(ctx, serviceUser), nil
+	}
+
+	session, err := srv.Sessions.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := srv.users.GetByID(session.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.IssuedAt < user.PasswordChangedAt {
+		return nil, ErrTokenRevoked
+	}
+	return context.WithValue(srv.withCaller(ctx, user), sessionKey{}, session), nil
+}
+
+// withCaller кладёт в контекст пользователя и его роль - по ней проверяются "role" и "roles" эндпоинтов
+func (srv *MyApi) withCaller(ctx context.Context, user *User) context.Context {
+	for role, status := range srv.statuses {
+		if status == user.Status {
+			ctx = ContextWithRole(ctx, role)
+			break
+		}
+	}
+	ctx = ContextWithPrincipal(ctx, user.Login)
+	return ContextWithUser(ctx, user)
+}
+
+// canManage - может ли caller менять данные target: себя - всегда,
+// модератор - всех, кроме админов, админ - всех
+func canManage(caller *User, target *User) bool {
+	return caller.ID == target.ID && caller.ID != 0 ||
+		caller.Status >= statusAdmin ||
+		caller.Status >= statusModerator && target.Status < statusAdmin
+}
+
+// apigen:api {"url": "/user/profile", "auth": false, "cors": {"origins": ["*"], "credentials": false}, "cache": {"max_age": 10, "ttl": 30}}
 func (srv *MyApi) Profile(ctx context.Context, in ProfileParams) (*User, error) {
 
 	if in.Login == "bad_user" {
 		return nil, fmt.Errorf("bad user")
 	}
 
-	srv.mu.RLock()
-	user, exist := srv.users[in.Login]
-	fmt.Printf("%+v\n", srv.users)
-	srv.mu.RUnlock()
-	if !exist {
-		return nil, ApiError{http.StatusNotFound, fmt.Errorf("user not exist")}
+	user, err := srv.users.GetByLogin(in.Login)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ApiError{http.StatusNotFound, err}
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return user, nil
 }
 
-// apigen:api {"url": "/user/create", "auth": true, "method": "POST"}
+// apigen:api {"url": "/user/create", "auth": true, "method": "POST", "ratelimit": {"rps": 5, "burst": 20, "key": "ip"}, "invalidates": ["/user/profile"], "idempotent": true, "role": "moderator"}
 func (srv *MyApi) Create(ctx context.Context, in CreateParams) (*NewUser, error) {
 	if in.Login == "bad_username" {
 		return nil, fmt.Errorf("bad user")
 	}
 
-	srv.mu.Lock()
-	defer srv.mu.Unlock()
-
-	_, exist := srv.users[in.Login]
-	if exist {
-		return nil, ApiError{http.StatusConflict, fmt.Errorf("user %s exist", in.Login)}
+	caller, err := srv.caller(ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	id := srv.nextID
-	srv.nextID++
-	srv.users[in.Login] = &User{
-		ID:       id,
+	user := &User{
 		Login:    in.Login,
 		FullName: in.Name,
 		Status:   srv.statuses[in.Status],
 	}
+	// как и в Update: выше своего статуса никого не заводим
+	if user.Status > caller.Status {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("not enough rights to set status %s", in.Status)}
+	}
+	if in.Password != "" {
+		user.PasswordHash = hashPassword(in.Password)
+	}
+	err = srv.users.Create(user)
+	if errors.Is(err, ErrUserExists) {
+		return nil, ApiError{http.StatusConflict, fmt.Errorf("user %s exist", in.Login)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &NewUser{user.ID}, nil
+}
+
+// apigen:api {"url": "/user/update", "auth": true, "method": "POST", "invalidates": ["/user/profile"]}
+func (srv *MyApi) Update(ctx context.Context, in UpdateParams) (*User, error) {
+	if in.FullName == "" && in.Status == "" {
+		return nil, ApiError{http.StatusBadRequest, fmt.Errorf("nothing to update")}
+	}
+
+	caller, err := srv.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := srv.users.GetByID(uint64(in.ID))
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ApiError{http.StatusNotFound, err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !canManage(caller, user) {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("forbidden")}
+	}
+
+	if in.FullName != "" {
+		user.FullName = in.FullName
+	}
+	if in.Status != "" {
+		status := srv.statuses[in.Status]
+		// статус меняют модераторы, а назначать и снимать админов - только админы
+		if caller.Status < statusModerator ||
+			caller.Status < statusAdmin && (status >= statusAdmin || user.Status >= statusAdmin) {
+			return nil, ApiError{http.StatusForbidden, fmt.Errorf("not enough rights to set status %s", in.Status)}
+		}
+		user.Status = status
+	}
+
+	err = srv.users.Update(user)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ApiError{http.StatusNotFound, err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// apigen:api {"url": "/user/delete", "auth": true, "method": "DELETE", "invalidates": ["/user/profile"], "role": "admin"}
+func (srv *MyApi) Delete(ctx context.Context, in DeleteParams) (*User, error) {
+	caller, err := srv.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller.ID == uint64(in.ID) {
+		return nil, ApiError{http.StatusBadRequest, fmt.Errorf("cannot delete yourself")}
+	}
+
+	user, err := srv.users.GetByID(uint64(in.ID))
+	if err == nil {
+		err = srv.users.Delete(user.ID)
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ApiError{http.StatusNotFound, err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// apigen:api {"url": "/user/list", "auth": true, "method": "GET", "role": "moderator"}
+func (srv *MyApi) List(ctx context.Context, in ListParams) (*UserList, error) {
+	filter := UserFilter{
+		// берём на одного больше, чтобы понять, есть ли следующая страница
+		Limit:       in.Limit + 1,
+		LoginPrefix: in.LoginPrefix,
+	}
+	if in.Cursor != "" {
+		afterID, err := decodeCursor(in.Cursor)
+		if err != nil {
+			return nil, ApiError{http.StatusBadRequest, fmt.Errorf("bad cursor")}
+		}
+		filter.AfterID = afterID
+	}
+	if in.Status != "" {
+		status := srv.statuses[in.Status]
+		filter.Status = &status
+	}
+
+	users, err := srv.users.List(filter)
+	if err != nil {
+		return nil, err
+	}
+	list := &UserList{Users: users}
+	if len(users) > in.Limit {
+		list.Users = users[:in.Limit]
+		list.NextCursor = encodeCursor(list.Users[in.Limit-1].ID)
+	}
+	return list, nil
+}
 
-	return &NewUser{id}, nil
+// Курсор непрозрачен для клиента, внутри - ID последнего отданного пользователя
+func encodeCursor(id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(id, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(raw), 10, 64)
+}
+
+// apigen:api {"url": "/user/login", "method": "POST", "ratelimit": {"rps": 1, "burst": 10, "key": "ip"}}
+func (srv *MyApi) Login(ctx context.Context, in LoginParams) (*SessionToken, error) {
+	user, err := srv.users.GetByLogin(in.Login)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	hash := ""
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	// пароль проверяется и для несуществующего логина, чтобы время ответа было тем же
+	if !checkPassword(hash, in.Password) {
+		return nil, ApiError{http.StatusUnauthorized, fmt.Errorf("bad login or password")}
+	}
+
+	token, session := srv.Sessions.Issue(user.ID)
+	return &SessionToken{token, session.ExpiresAt}, nil
+}
+
+// apigen:api {"url": "/user/logout", "auth": true, "method": "POST"}
+func (srv *MyApi) Logout(ctx context.Context, in LogoutParams) (*LogoutResult, error) {
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	if !ok {
+		return nil, ApiError{http.StatusBadRequest, fmt.Errorf("not a session token")}
+	}
+	srv.Sessions.Revoke(session)
+	return &LogoutResult{true}, nil
+}
+
+// apigen:api {"url": "/user/refresh", "auth": true, "method": "POST"}
+func (srv *MyApi) Refresh(ctx context.Context, in RefreshParams) (*SessionToken, error) {
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	if !ok {
+		return nil, ApiError{http.StatusBadRequest, fmt.Errorf("not a session token")}
+	}
+	// старый токен больше не действует
+	srv.Sessions.Revoke(session)
+	token, refreshed := srv.Sessions.Issue(session.UserID)
+	return &SessionToken{token, refreshed.ExpiresAt}, nil
+}
+
+// apigen:api {"url": "/user/password", "auth": true, "method": "POST", "ratelimit": {"rps": 1, "burst": 5, "key": "auth"}}
+func (srv *MyApi) ChangePassword(ctx context.Context, in ChangePasswordParams) (*ChangePasswordResult, error) {
+	caller, err := srv.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller == serviceUser {
+		return nil, ApiError{http.StatusBadRequest, fmt.Errorf("service account has no password")}
+	}
+	user, err := srv.users.GetByID(caller.ID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ApiError{http.StatusNotFound, err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !checkPassword(user.PasswordHash, in.OldPassword) {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("bad old_password")}
+	}
+
+	user.PasswordHash = hashPassword(in.NewPassword)
+	// Смена пароля выкидывает все сессии пользователя, в том числе текущую: украденный токен больше не работает
+	user.PasswordChangedAt = time.Now().UnixNano()
+	if err := srv.users.Update(user); err != nil {
+		return nil, err
+	}
+	return &ChangePasswordResult{true}, nil
+}
+
+// apigen:api {"url": "/audit", "auth": true, "method": "GET", "role": "admin"}
+func (srv *MyApi) Audit(ctx context.Context, in AuditParams) (*AuditTrail, error) {
+	auditLog, ok := srv.AuditSink.(AuditLog)
+	if !ok {
+		return nil, ApiError{http.StatusNotImplemented, fmt.Errorf("audit log is not readable")}
+	}
+	if in.From > 0 && in.To > 0 && in.To <= in.From {
+		return nil, ApiError{http.StatusBadRequest, fmt.Errorf("to must be > from")}
+	}
+	filter := AuditFilter{Principal: in.User, Limit: in.Limit}
+	if in.From > 0 {
+		filter.From = time.Unix(int64(in.From), 0)
+	}
+	if in.To > 0 {
+		filter.To = time.Unix(int64(in.To), 0)
+	}
+
+	entries, err := auditLog.Query(filter)
+	if err != nil {
+		return nil, err
+	}
+	trail := &AuditTrail{Records: []*AuditRecord{}}
+	for _, entry := range entries {
+		trail.Records = append(trail.Records, &AuditRecord{
+			Time:      entry.Time.Unix(),
+			RequestID: entry.RequestID,
+			User:      entry.Principal,
+			Endpoint:  entry.Endpoint,
+			Method:    entry.Method,
+			Params:    entry.Params,
+			ResultID:  entry.ResultID,
+		})
+	}
+	return trail, nil
 }
 
 // 2-я часть
@@ -124,13 +555,24 @@ func (srv *MyApi) Create(ctx context.Context, in CreateParams) (*NewUser, error)
 // код, созданный вашим кодогенератором работает с конкретной струткурой, про другие ничего не знает
 // поэтому то что рядом есть ещё походая структура с такими же методами его нисколько не смущает
 
+// apigen:struct {"middleware": ["withServerHeader"]}
 type OtherApi struct {
+	Logger       *slog.Logger
+	SpanExporter SpanExporter
 }
 
 func NewOtherApi() *OtherApi {
 	return &OtherApi{}
 }
 
+// withServerHeader - пример middleware, подключается через apigen:struct
+func (srv *OtherApi) withServerHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "OtherApi")
+		next.ServeHTTP(w, r)
+	})
+}
+
 type OtherCreateParams struct {
 	Username string `apivalidator:"required,min=3"`
 	Name     string `apivalidator:"paramname=account_name"`
@@ -145,7 +587,7 @@ type OtherUser struct {
 	Level    int    `json:"level"`
 }
 
-// apigen:api {"url": "/user/create", "auth": true, "method": "POST"}
+// apigen:api {"url": "/user/create", "auth": true, "method": "POST", "strict": true}
 func (srv *OtherApi) Create(ctx context.Context, in OtherCreateParams) (*OtherUser, error) {
 	return &OtherUser{
 		ID:       12,
@@ -8,10 +8,26 @@ import "strconv"
 import "context"
 import "io/ioutil"
 import "fmt"
+import "sort"
+import "net/url"
+import "log/slog"
+import "time"
+import "sync"
+import "os"
+import "crypto/rand"
+import "encoding/hex"
+import "net"
+import "math"
+import "runtime/debug"
+import "crypto/sha256"
+import "bytes"
+import "crypto/subtle"
+import "unicode"
 
 type HTTPResponse struct {
-	Error    string      `json:"error"`
-	Response interface{} `json:"response,omitempty"`
+	Error     string      `json:"error"`
+	Response  interface{} `json:"response,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
 }
 
 func response(w http.ResponseWriter, apiErr *ApiError, res interface{}) {
@@ -20,10 +36,23 @@ func response(w http.ResponseWriter, apiErr *ApiError, res interface{}) {
 		Error:    apiErr.Error(),
 		Response: res,
 	}
+	// request_id в конверте с ошибкой включается через apigen:struct {"request_id_in_errors": true}
+	if rec := apigenWriterOf(w); rec != nil && apiErr.HTTPStatus != http.StatusOK {
+		resp.RequestID = rec.requestID
+	}
 	bytes, _ := json.Marshal(resp)
 	w.Write(bytes)
 }
 
+// apiErrorStatus - статус из ApiError, для всех остальных ошибок 500
+func apiErrorStatus(err error) int {
+	var apiErr ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus
+	}
+	return http.StatusInternalServerError
+}
+
 func getParamFromPost(postParams string, key string) string {
 	for _, p := range strings.Split(postParams, ",") {
 		kv := strings.Split(p, "=")
@@ -43,6 +72,8 @@ type Restrictions struct {
 	Required bool
 	Min *int
 	Max *int
+	Classes []string
+	OmitEmpty bool
 	ParamName string
 	Enum *struct {
 		List []string
@@ -58,6 +89,10 @@ func parseRestrictions(restr string) *Restrictions {
 			restrictions.Required = true
 		}
 
+		if pair == "omitempty" {
+			restrictions.OmitEmpty = true
+		}
+
 		kv := strings.Split(pair, "=")
 		if len(kv) == 2 {
 			k := kv[0]
@@ -77,6 +112,10 @@ func parseRestrictions(restr string) *Restrictions {
 				restrictions.ParamName = v
 			}
 
+			if k == "classes" {
+				restrictions.Classes = strings.Split(v, "|")
+			}
+
 			if k == "enum" {
 				values := strings.Split(v, "|")
 				restrictions.Enum = &struct{List []string; Default string}{
@@ -100,15 +139,14 @@ func parseRestrictions(restr string) *Restrictions {
 }
 
 func queryParamsToMap(getParams map[string][]string, postParams string, method string) map[string]string {
-	println("parsing for method:", method)
 	values := map[string]string{}
 
 	if method == "POST" {
 		for _, kv := range strings.Split(postParams, "&") {
 			pair := strings.Split(kv, "=")
 			if len(pair) == 2 {
-				k := pair[0]
-				v := pair[1]
+				k := unescapeParam(pair[0])
+				v := unescapeParam(pair[1])
 				values[k] = v
 			}
 		}
@@ -121,6 +159,73 @@ func queryParamsToMap(getParams map[string][]string, postParams string, method s
 	return values
 }
 
+// unescapeParam раскодирует application/x-www-form-urlencoded, а битые значения оставляет как есть
+func unescapeParam(s string) string {
+	unescaped, err := url.QueryUnescape(s)
+	if err != nil {
+		return s
+	}
+	return unescaped
+}
+
+func checkStrictParams(getParams map[string][]string, postParams string, method string, known []string) error {
+	counts := map[string]int{}
+
+	// У POST параметры читаются из тела, но и query не должен молча игнорироваться:
+	// лишние ключи в нём - неизвестные, а ключ и там, и там - повтор
+	for k, arr := range getParams {
+		counts[k] += len(arr)
+	}
+	if method == "POST" {
+		for _, kv := range strings.Split(postParams, "&") {
+			k := unescapeParam(strings.Split(kv, "=")[0])
+			if k != "" {
+				counts[k]++
+			}
+		}
+	}
+
+	unknown := []string{}
+	duplicated := []string{}
+	for k, n := range counts {
+		if !contains(known, k) {
+			unknown = append(unknown, k)
+		}
+		if n > 1 {
+			duplicated = append(duplicated, k)
+		}
+	}
+	sort.Strings(unknown)
+	sort.Strings(duplicated)
+
+	problems := []string{}
+	if len(unknown) > 0 {
+		problems = append(problems, "unknown params: "+strings.Join(unknown, ", "))
+	}
+	if len(duplicated) > 0 {
+		problems = append(problems, "duplicated params: "+strings.Join(duplicated, ", "))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// apigenParamError - ошибка валидации конкретного параметра, текст не меняет
+type apigenParamError struct {
+	Param string
+	Err   error
+}
+
+func (e *apigenParamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *apigenParamError) Unwrap() error {
+	return e.Err
+}
+
 func validParamStr(paramName string, restrRaw string, queryParams map[string]string) (string, error, int) {
 	restr := parseRestrictions(restrRaw)
 	
@@ -135,12 +240,21 @@ func validParamStr(paramName string, restrRaw string, queryParams map[string]str
 		return "", errors.New(name + " must me not empty"), http.StatusBadRequest
 	}
 
-	if restr.Max != nil && len(value) > *restr.Max {
-		return "", errors.New(name + " len must be <= " + fmt.Sprint(*restr.Max)), http.StatusBadRequest
-	}
+	// С omitempty пустое значение - "не передан": длину и классы символов у него не проверяем
+	if !(restr.OmitEmpty && value == "") {
+		if restr.Max != nil && len(value) > *restr.Max {
+			return "", errors.New(name + " len must be <= " + fmt.Sprint(*restr.Max)), http.StatusBadRequest
+		}
+
+		if restr.Min != nil && len(value) < *restr.Min {
+			return "", errors.New(name + " len must be >= " + fmt.Sprint(*restr.Min)), http.StatusBadRequest
+		}
 
-	if restr.Min != nil && len(value) < *restr.Min {
-		return "", errors.New(name + " len must be >= " + fmt.Sprint(*restr.Min)), http.StatusBadRequest
+		for _, class := range restr.Classes {
+			if !hasCharClass(value, class) {
+				return "", errors.New(name + " must contain " + charClassNames[class]), http.StatusBadRequest
+			}
+		}
 	}
 
 	if restr.Enum != nil {
@@ -169,6 +283,10 @@ func validParamInt(paramName string, restrRaw string, queryParams map[string]str
 	if restr.Required && value == "" {
 		return 0, errors.New(name + " must me not empty"), http.StatusBadRequest
 	}
+	// default у числа разбирается туда же, куда и у enum
+	if value == "" && restr.Enum != nil && restr.Enum.Default != "" {
+		value = restr.Enum.Default
+	}
 
 	num, err := strconv.Atoi(value)
 	if err != nil {
@@ -179,7 +297,7 @@ func validParamInt(paramName string, restrRaw string, queryParams map[string]str
 		return 0, errors.New(name + " must be <= " + fmt.Sprint(*restr.Max)), http.StatusBadRequest
 	}
 
-	if restr.Max != nil && num < *restr.Min {
+	if restr.Min != nil && num < *restr.Min {
 		return 0, errors.New(name + " must be >= " + fmt.Sprint(*restr.Min)), http.StatusBadRequest
 	}
 
@@ -196,230 +314,3299 @@ func contains(s []string, str string) bool {
 	return false
 }
 
+// Классы символов для classes=... в apivalidator, как они называются в ошибке
+var charClassNames = map[string]string{
+	"lower":   "a lowercase letter",
+	"upper":   "an uppercase letter",
+	"digit":   "a digit",
+	"special": "a special character",
+}
 
-func (srv *MyApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	
-	case "/user/profile":
-		srv.ProfileHTTPHandler(w, r)
-		return
-	
-	case "/user/create":
-		srv.CreateHTTPHandler(w, r)
-		return
-	
-	default:
-		response(w, &ApiError{http.StatusNotFound, errors.New("unknown method")}, nil)
-		return
+func hasCharClass(value string, class string) bool {
+	for _, c := range value {
+		switch {
+		case class == "lower" && unicode.IsLower(c),
+			class == "upper" && unicode.IsUpper(c),
+			class == "digit" && unicode.IsDigit(c),
+			class == "special" && !unicode.IsLetter(c) && !unicode.IsDigit(c) && !unicode.IsSpace(c):
+			return true
+		}
 	}
+	return false
 }
 
-func (srv *MyApi) ProfileHTTPHandler(w http.ResponseWriter, r *http.Request) {
-	
-	
 
-	body, _ := ioutil.ReadAll(r.Body)
-	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
-	
-	// Создаем пустые переменные под параметры
-	
-	paramLogin, err, statusCode := validParamStr("Login", "required", queryParams)
-	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
+// apigenRequestInfo заполняет обработчик, а читает обвязка apigenServe
+type apigenRequestInfo struct {
+	Params        map[string]string
+	ValidationErr error
+	Err           error
+}
+
+type apigenRequestInfoKey struct{}
+
+// apigenRequestInfoFrom никогда не возвращает nil, чтобы обработчик работал и без обвязки
+func apigenRequestInfoFrom(ctx context.Context) *apigenRequestInfo {
+	if info, ok := ctx.Value(apigenRequestInfoKey{}).(*apigenRequestInfo); ok {
+		return info
+	}
+	return &apigenRequestInfo{}
+}
+
+// apigenResponseWriter запоминает отданный статус и начат ли уже ответ.
+// Если задан requestID, response добавляет его в конверт с ошибкой
+type apigenResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	requestID   string
+}
+
+func (w *apigenResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
 		return
 	}
-	
-	// Структура параметров для слоя стора
-	urlParams := ProfileParams{
-		
-		Login: paramLogin,
+	w.status = status
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *apigenResponseWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext - X-Request-ID текущего запроса, пустая строка вне сгенерированного ServeHTTP
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID берёт X-Request-ID клиента или придумывает свой, кладёт его в контекст и в ответ.
+// Чужой id принимаем, только если он не сломает логи: до 128 символов без пробелов и спецсимволов
+func withRequestID(w http.ResponseWriter, r *http.Request) (*http.Request, string) {
+	id := r.Header.Get("X-Request-ID")
+	if !isValidRequestID(id) {
+		id = newTraceID(16)
 	}
+	w.Header().Set("X-Request-ID", id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)), id
+}
 
-	ctx := context.Background()
-	data, err := srv.Profile(ctx, urlParams)
-	if err != nil {
-		var statusCode int
-		if err.Error() == "user not exist" {
-			statusCode = http.StatusNotFound
-		} else {
-			if strings.Contains(err.Error(), "exist") && !strings.Contains(err.Error(), "not")  {
-				statusCode = http.StatusConflict
-			} else {
-				statusCode = http.StatusInternalServerError
-			}
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
 		}
-		response(w, &ApiError{statusCode, err}, nil)
-		return
 	}
+	return true
+}
 
-	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+// apigenWriterOf ищет apigenResponseWriter под обёртками, которые умеют Unwrap
+func apigenWriterOf(w http.ResponseWriter) *apigenResponseWriter {
+	for {
+		switch typed := w.(type) {
+		case *apigenResponseWriter:
+			return typed
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = typed.Unwrap()
+		default:
+			return nil
+		}
+	}
 }
 
-func (srv *MyApi) CreateHTTPHandler(w http.ResponseWriter, r *http.Request) {
-	
-	if r.Method != "POST" {
-		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
-		return
+const redactedParam = "[REDACTED]"
+
+// redactParams - копия параметров, в которой значения sensitive-полей заменены
+func redactParams(params map[string]string, sensitive []string) map[string]string {
+	redacted := make(map[string]string, len(params))
+	for k, v := range params {
+		if contains(sensitive, k) {
+			v = redactedParam
+		}
+		redacted[k] = v
 	}
-	
-	
-	headerValue, ok := r.Header["X-Auth"]
-	if !ok {
-		response(w, &ApiError{http.StatusForbidden, errors.New("unauthorized")}, nil)
-		return
+	return redacted
+}
+
+func logApigenRequest(logger *slog.Logger, r *http.Request, endpoint string, status int, latency time.Duration, info *apigenRequestInfo) {
+	if logger == nil {
+		logger = slog.Default()
 	}
-	if len(headerValue) != 1 && headerValue[0] != "100500" {
-		response(w, &ApiError{http.StatusForbidden, errors.New("unauthorized")}, nil)
-		return
+
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
 	}
-	
 
-	body, _ := ioutil.ReadAll(r.Body)
-	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
-	
-	// Создаем пустые переменные под параметры
-	
-	paramLogin, err, statusCode := validParamStr("Login", "required,min=10", queryParams)
-	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
-		return
+	attrs := []slog.Attr{
+		slog.String("request_id", RequestIDFromContext(r.Context())),
+		slog.String("endpoint", endpoint),
+		slog.String("method", r.Method),
+		slog.Int("status", status),
+		slog.Duration("latency", latency),
 	}
-	
-	paramName, err, statusCode := validParamStr("Name", "paramname=full_name", queryParams)
-	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
-		return
+	if info.Params != nil {
+		attrs = append(attrs, slog.Any("params", info.Params))
 	}
-	
-	paramStatus, err, statusCode := validParamStr("Status", "enum=user|moderator|admin,default=user", queryParams)
-	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
-		return
+	if info.ValidationErr != nil {
+		attrs = append(attrs, slog.String("validation_error", info.ValidationErr.Error()))
 	}
-	
-	paramAge, err, statusCode := validParamInt("Age", "min=0,max=128", queryParams)
-	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
-		return
+	if info.Err != nil {
+		attrs = append(attrs, slog.String("error", info.Err.Error()))
 	}
-	
-	// Структура параметров для слоя стора
-	urlParams := CreateParams{
-		
-		Login: paramLogin,
-		Name: paramName,
-		Status: paramStatus,
-		Age: paramAge,
+	logger.LogAttrs(r.Context(), level, "apigen request", attrs...)
+}
+
+// PanicHook получает панику из обработчика вместе со стеком - например, чтобы отправить её в трекер ошибок
+type PanicHook func(r *http.Request, recovered interface{}, stack []byte)
+
+// handleApigenPanic логирует панику со стеком, зовёт hook и отвечает 500, если ответ ещё не начат.
+// Детали паники клиенту не отдаём
+func handleApigenPanic(w *apigenResponseWriter, r *http.Request, endpoint string, recovered interface{}, logger *slog.Logger, hook PanicHook) error {
+	if recovered == http.ErrAbortHandler {
+		// net/http использует эту панику, чтобы оборвать ответ - не мешаем
+		panic(recovered)
+	}
+	if logger == nil {
+		logger = slog.Default()
 	}
 
-	ctx := context.Background()
-	data, err := srv.Create(ctx, urlParams)
-	if err != nil {
-		var statusCode int
-		if err.Error() == "user not exist" {
-			statusCode = http.StatusNotFound
-		} else {
-			if strings.Contains(err.Error(), "exist") && !strings.Contains(err.Error(), "not")  {
-				statusCode = http.StatusConflict
-			} else {
-				statusCode = http.StatusInternalServerError
-			}
-		}
-		response(w, &ApiError{statusCode, err}, nil)
-		return
+	stack := debug.Stack()
+	logger.LogAttrs(r.Context(), slog.LevelError, "apigen panic",
+		slog.String("request_id", RequestIDFromContext(r.Context())),
+		slog.String("endpoint", endpoint),
+		slog.String("panic", fmt.Sprint(recovered)),
+		slog.String("stack", string(stack)),
+	)
+	if hook != nil {
+		hook(r, recovered, stack)
 	}
 
-	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+	if !w.wroteHeader {
+		response(w, &ApiError{http.StatusInternalServerError, errors.New("internal error")}, nil)
+	}
+	return fmt.Errorf("panic: %v", recovered)
 }
 
+// Span - отрезок обработки запроса: маршрутизация, авторизация, валидация, вызов метода
+type Span struct {
+	TraceID  string            `json:"trace_id"`
+	SpanID   string            `json:"span_id"`
+	ParentID string            `json:"parent_id,omitempty"`
+	Name     string            `json:"name"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Sampled  bool              `json:"sampled"`
 
-func (srv *OtherApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	
-	case "/user/create":
-		srv.CreateHTTPHandler(w, r)
-		return
-	
-	default:
-		response(w, &ApiError{http.StatusNotFound, errors.New("unknown method")}, nil)
-		return
-	}
+	exporter SpanExporter
+	ended    bool
 }
 
-func (srv *OtherApi) CreateHTTPHandler(w http.ResponseWriter, r *http.Request) {
-	
-	if r.Method != "POST" {
-		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
-		return
+// SpanExporter получает каждый завершённый спан
+type SpanExporter interface {
+	ExportSpan(span Span)
+}
+
+type spanContextKey struct{}
+
+// SpanFromContext - текущий спан; nil, если запрос пришёл не через сгенерированный ServeHTTP
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// StartSpan начинает дочерний спан текущего. Его можно вызывать и из методов API
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		SpanID:  newTraceID(8),
+		Name:    name,
+		Start:   time.Now(),
+		Sampled: true,
 	}
-	
-	
-	headerValue, ok := r.Header["X-Auth"]
-	if !ok {
-		response(w, &ApiError{http.StatusForbidden, errors.New("unauthorized")}, nil)
-		return
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.Sampled = parent.Sampled
+		span.exporter = parent.exporter
+	} else {
+		span.TraceID = newTraceID(16)
 	}
-	if len(headerValue) != 1 && headerValue[0] != "100500" {
-		response(w, &ApiError{http.StatusForbidden, errors.New("unauthorized")}, nil)
-		return
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// startTrace начинает корневой спан запроса, продолжая trace из заголовка traceparent
+func startTrace(r *http.Request, exporter SpanExporter, name string) (context.Context, *Span) {
+	ctx, span := StartSpan(r.Context(), name)
+	span.exporter = exporter
+	if traceID, parentID, sampled, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+		span.TraceID = traceID
+		span.ParentID = parentID
+		span.Sampled = sampled
 	}
-	
+	return ctx, span
+}
 
-	body, _ := ioutil.ReadAll(r.Body)
-	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
-	
-	// Создаем пустые переменные под параметры
-	
-	paramUsername, err, statusCode := validParamStr("Username", "required,min=3", queryParams)
-	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
-		return
+func (s *Span) SetAttr(key string, value string) {
+	if s.Attrs == nil {
+		s.Attrs = map[string]string{}
 	}
-	
-	paramName, err, statusCode := validParamStr("Name", "paramname=account_name", queryParams)
+	s.Attrs[key] = value
+}
+
+func (s *Span) SetError(err error) {
 	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
-		return
+		s.Error = err.Error()
 	}
-	
-	paramClass, err, statusCode := validParamStr("Class", "enum=warrior|sorcerer|rouge,default=warrior", queryParams)
-	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
+}
+
+// Finish завершает спан и отдаёт его экспортеру; повторные вызовы ничего не делают
+func (s *Span) Finish() {
+	if s.ended {
 		return
 	}
-	
-	paramLevel, err, statusCode := validParamInt("Level", "min=1,max=50", queryParams)
-	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
-		return
+	s.ended = true
+	s.End = time.Now()
+	if s.exporter != nil && s.Sampled {
+		s.exporter.ExportSpan(*s)
 	}
-	
-	// Структура параметров для слоя стора
-	urlParams := OtherCreateParams{
-		
-		Username: paramUsername,
-		Name: paramName,
-		Class: paramClass,
-		Level: paramLevel,
+}
+
+// TraceParent - заголовок traceparent, в котором родителем указан этот спан
+func (s *Span) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
 	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
 
-	ctx := context.Background()
-	data, err := srv.Create(ctx, urlParams)
-	if err != nil {
-		var statusCode int
-		if err.Error() == "user not exist" {
-			statusCode = http.StatusNotFound
-		} else {
-			if strings.Contains(err.Error(), "exist") && !strings.Contains(err.Error(), "not")  {
-				statusCode = http.StatusConflict
-			} else {
-				statusCode = http.StatusInternalServerError
-			}
+// parseTraceParent разбирает "00-<trace-id>-<parent-id>-<flags>" по W3C Trace Context
+func parseTraceParent(header string) (traceID string, parentID string, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", "", false, false
+	}
+	if !isTraceHex(parts[0]) || !isTraceHex(parts[1]) || !isTraceHex(parts[2]) || !isTraceHex(parts[3]) {
+		return "", "", false, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false, false
+	}
+	flags, _ := strconv.ParseUint(parts[3], 16, 8)
+	return parts[1], parts[2], flags&1 == 1, true
+}
+
+// isTraceHex - только строчные hex-символы, как требует спецификация
+func isTraceHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
 		}
-		response(w, &ApiError{statusCode, err}, nil)
-		return
 	}
+	return s != ""
+}
 
-	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+func newTraceID(size int) string {
+	buf := make([]byte, size)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// InMemoryExporter копит спаны в памяти - для тестов
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (e *InMemoryExporter) ExportSpan(span Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans - копия накопленных спанов в порядке завершения
+func (e *InMemoryExporter) Spans() []Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Span{}, e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// JSONLinesExporter дописывает спаны в файл, по одному json-объекту на строку
+type JSONLinesExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJSONLinesExporter(path string) (*JSONLinesExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesExporter{file: file}, nil
+}
+
+func (e *JSONLinesExporter) ExportSpan(span Span) {
+	line, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	e.file.Write(append(line, '\n'))
+	e.mu.Unlock()
+}
+
+func (e *JSONLinesExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+type corsPolicy struct {
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      int
+}
+
+// Заголовки ответа, которые браузер покажет скрипту
+const corsExposeHeaders = "X-Request-ID, traceparent, Retry-After"
+
+// handleCORS возвращает true, если запрос был preflight и ответ уже отправлен
+func handleCORS(w http.ResponseWriter, r *http.Request, policy *corsPolicy) bool {
+	origin := r.Header.Get("Origin")
+	if policy == nil || origin == "" {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if !contains(policy.Origins, "*") && !contains(policy.Origins, origin) {
+		if preflight {
+			response(w, &ApiError{http.StatusForbidden, errors.New("cors: origin not allowed")}, nil)
+			return true
+		}
+		return false
+	}
+
+	if contains(policy.Origins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if policy.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		return false
+	}
+
+	if !contains(policy.Methods, r.Header.Get("Access-Control-Request-Method")) {
+		response(w, &ApiError{http.StatusForbidden, errors.New("cors: method not allowed")}, nil)
+		return true
+	}
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !containsFold(policy.Headers, header) {
+			response(w, &ApiError{http.StatusForbidden, errors.New("cors: header " + header + " not allowed")}, nil)
+			return true
+		}
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+	if policy.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// containsFold - contains без учёта регистра, имена заголовков регистронезависимы
+func containsFold(s []string, str string) bool {
+	for _, v := range s {
+		if strings.EqualFold(v, str) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseCache хранит закодированные ответы GET-эндпоинтов
+type ResponseCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, body []byte, ttl time.Duration)
+	// DeletePrefix выкидывает ответы, ключ которых начинается с prefix - например, все ответы эндпоинта
+	DeletePrefix(prefix string)
+}
+
+type cachedResponse struct {
+	body    []byte
+	expires time.Time
+}
+
+// InMemoryResponseCache - кэш в памяти процесса, нулевое значение готово к работе
+type InMemoryResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+func (c *InMemoryResponseCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (c *InMemoryResponseCache) Set(key string, body []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = map[string]cachedResponse{}
+	}
+	// Просроченное чистим при записи, чтобы кэш не рос бесконечно
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedResponse{body, now.Add(ttl)}
+}
+
+func (c *InMemoryResponseCache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+var defaultResponseCache = &InMemoryResponseCache{}
+
+func responseCacheOrDefault(cache ResponseCache) ResponseCache {
+	if cache == nil {
+		return defaultResponseCache
+	}
+	return cache
+}
+
+// responseCacheKey - эндпоинт плюс уже проверенные параметры: порядок полей в json фиксирован
+func responseCacheKey(endpoint string, params interface{}) string {
+	data, _ := json.Marshal(params)
+	return endpoint + "?" + string(data)
+}
+
+// encodeResponse кодирует успешный ответ так же, как response
+func encodeResponse(res interface{}) []byte {
+	bytes, _ := json.Marshal(&HTTPResponse{Response: res})
+	return bytes
+}
+
+// writeCacheable отдаёт закодированный ответ с ETag и Cache-Control, на совпавший If-None-Match - 304
+func writeCacheable(w http.ResponseWriter, r *http.Request, body []byte, cacheControl string) {
+	sum := sha256.Sum256(body)
+	etag := "\"" + hex.EncodeToString(sum[:16]) + "\""
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// Сколько помним ответ на ключ
+const idempotencyTTL = 24 * time.Hour
+
+// IdempotencyRecord - ответ, сохранённый по ключу. Status 0 - первый запрос ещё выполняется
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	Body        []byte
+}
+
+// IdempotencyStore хранит ответы по ключам идемпотентности
+type IdempotencyStore interface {
+	// Reserve занимает ключ под новый запрос. Если ключ уже занят - возвращает его запись и false
+	Reserve(key string, fingerprint string, ttl time.Duration) (IdempotencyRecord, bool)
+	// Complete сохраняет ответ на занятый ключ
+	Complete(key string, status int, body []byte)
+	// Release освобождает ключ, если запрос не удался и его можно повторить
+	Release(key string)
+}
+
+type idempotencyEntry struct {
+	record  IdempotencyRecord
+	expires time.Time
+}
+
+// InMemoryIdempotencyStore - ключи в памяти процесса, нулевое значение готово к работе
+type InMemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+func (s *InMemoryIdempotencyStore) Reserve(key string, fingerprint string, ttl time.Duration) (IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.entries == nil {
+		s.entries = map[string]*idempotencyEntry{}
+	}
+	for k, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, k)
+		}
+	}
+
+	if entry, ok := s.entries[key]; ok {
+		return entry.record, false
+	}
+	s.entries[key] = &idempotencyEntry{IdempotencyRecord{Fingerprint: fingerprint}, now.Add(ttl)}
+	return IdempotencyRecord{}, true
+}
+
+func (s *InMemoryIdempotencyStore) Complete(key string, status int, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok {
+		entry.record.Status = status
+		entry.record.Body = body
+	}
+}
+
+func (s *InMemoryIdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+}
+
+var defaultIdempotencyStore = &InMemoryIdempotencyStore{}
+
+func idempotencyStoreOrDefault(store IdempotencyStore) IdempotencyStore {
+	if store == nil {
+		return defaultIdempotencyStore
+	}
+	return store
+}
+
+// idempotencyCaller - чьи ключи: проверенный вызывающий, а не сам токен.
+// Токен меняется при refresh, а ретрай с новым должен получить тот же ответ; к тому же токен - секрет
+func idempotencyCaller(r *http.Request) string {
+	if principal := PrincipalFromContext(r.Context()); principal != "" {
+		return "principal:" + principal
+	}
+	if token := r.Header.Get("X-Auth"); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "auth:" + hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+// idempotencyFingerprint - по нему отличаем ретрай от другого запроса с тем же ключом
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n" + string(body)))
+	return hex.EncodeToString(sum[:])
+}
+
+// replayIdempotent отвечает на повторный ключ: сохранённым ответом, 409 или 422
+func replayIdempotent(w http.ResponseWriter, record IdempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		response(w, &ApiError{http.StatusUnprocessableEntity, errors.New("idempotency key is already used with a different payload")}, nil)
+	case record.Status == 0:
+		response(w, &ApiError{http.StatusConflict, errors.New("request with this idempotency key is in progress")}, nil)
+	default:
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Status)
+		w.Write(record.Body)
+	}
+}
+
+// idempotencyRecorder копит ответ, чтобы сохранить его по ключу
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (w *idempotencyRecorder) WriteHeader(status int) {
+	if !w.wrote {
+		w.status = status
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotencyRecorder) Write(data []byte) (int, error) {
+	if !w.wrote {
+		w.status = http.StatusOK
+		w.wrote = true
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish сохраняет ответ; ошибки сервера и паники (ответа ещё нет) ключ освобождают, чтобы запрос можно было повторить
+func (w *idempotencyRecorder) finish(store IdempotencyStore, key string) {
+	if !w.wrote || w.status >= http.StatusInternalServerError {
+		store.Release(key)
+		return
+	}
+	store.Complete(key, w.status, w.body.Bytes())
+}
+
+// Authenticator проверяет токен из X-Auth. В возвращённый контекст он может положить
+// того, кто делает запрос, - контекст получит метод API
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (context.Context, error)
+}
+
+// StaticTokenAuthenticator пускает с одним заранее известным токеном
+type StaticTokenAuthenticator string
+
+func (a StaticTokenAuthenticator) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a)) != 1 {
+		return nil, errUnauthorized
+	}
+	return ctx, nil
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+var defaultAuthenticator Authenticator = StaticTokenAuthenticator("100500")
+
+func authenticatorOrDefault(a Authenticator) Authenticator {
+	if a == nil {
+		return defaultAuthenticator
+	}
+	return a
+}
+
+// authenticate - общая проверка для HTTP и JSON-RPC. Ошибка - текст для ответа 403
+func authenticate(ctx context.Context, a Authenticator, r *http.Request) (context.Context, error) {
+	values := r.Header["X-Auth"]
+	if len(values) != 1 || values[0] == "" {
+		return nil, errUnauthorized
+	}
+	return authenticatorOrDefault(a).Authenticate(ctx, values[0])
+}
+
+type roleKey struct{}
+
+// ContextWithRole - Authenticator кладёт так роль вызывающего для проверки "role" и "roles"
+func ContextWithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+func RoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleKey{}).(string)
+	return role, ok
+}
+
+// checkRole пускает, только если роль вызывающего в списке allowed
+func checkRole(ctx context.Context, allowed []string) error {
+	role, ok := RoleFromContext(ctx)
+	if !ok {
+		return errors.New("forbidden: caller has no role, required one of: " + strings.Join(allowed, ", "))
+	}
+	if !contains(allowed, role) {
+		return errors.New("forbidden: role " + role + " is not allowed, required one of: " + strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// AuditEntry - одна запись журнала аудита
+type AuditEntry struct {
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"request_id"`
+	Principal string            `json:"principal"` // пусто, если Authenticator не положил его в контекст
+	Endpoint  string            `json:"endpoint"`
+	Method    string            `json:"method"`
+	Params    map[string]string `json:"params"` // sensitive-параметры скрыты
+	ResultID  string            `json:"result_id,omitempty"`
+}
+
+// AuditSink принимает записи аудита. Запись только дописывается, ошибка не отменяет уже сделанный вызов
+type AuditSink interface {
+	WriteAudit(entry AuditEntry) error
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal - Authenticator кладёт так имя вызывающего для журнала аудита
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// auditResultID - поле "id" ответа, если оно есть
+func auditResultID(data interface{}) string {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	result := struct {
+		ID json.RawMessage `json:"id"`
+	}{}
+	if json.Unmarshal(raw, &result) != nil || result.ID == nil {
+		return ""
+	}
+	var id string
+	if json.Unmarshal(result.ID, &id) == nil {
+		return id
+	}
+	return string(result.ID)
+}
+
+// writeAudit пишет запись об успешном вызове. Сбой журнала только логируется - ответ уже не изменить
+func writeAudit(ctx context.Context, sink AuditSink, logger *slog.Logger, endpoint string, method string, params map[string]string, data interface{}) {
+	if sink == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:      time.Now().UTC(),
+		RequestID: RequestIDFromContext(ctx),
+		Principal: PrincipalFromContext(ctx),
+		Endpoint:  endpoint,
+		Method:    method,
+		Params:    params,
+		ResultID:  auditResultID(data),
+	}
+	if err := sink.WriteAudit(entry); err != nil {
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.LogAttrs(ctx, slog.LevelError, "apigen audit failed",
+			slog.String("request_id", entry.RequestID),
+			slog.String("endpoint", endpoint),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// RateLimiterStore хранит token bucket'ы клиентов
+type RateLimiterStore interface {
+	// Allow забирает токен из ведра key. Если токенов нет - возвращает, через сколько появится следующий
+	Allow(key string, rps float64, burst int) (bool, time.Duration)
+}
+
+// tokenBucket помнит свои rps и burst: в одном хранилище лежат вёдра эндпоинтов с разными лимитами
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rps    float64
+	burst  int
+}
+
+// InMemoryRateLimiterStore - вёдра в памяти процесса, нулевое значение готово к работе
+type InMemoryRateLimiterStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func (s *InMemoryRateLimiterStore) Allow(key string, rps float64, burst int) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.buckets == nil {
+		s.buckets = map[string]*tokenBucket{}
+	}
+	// Полные вёдра ничем не отличаются от новых - выкидываем, чтобы не копить клиентов
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, b := range s.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*b.rps >= float64(b.burst) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	b.rps, b.burst = rps, burst
+	b.tokens += now.Sub(b.last).Seconds() * rps
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rps * float64(time.Second))
+}
+
+var defaultRateLimiterStore = &InMemoryRateLimiterStore{}
+
+// rateLimitKey - клиент, по которому считается лимит. Без заголовка считаем по ip.
+// auth зовётся только после успешной проверки X-Auth: иначе лимит обходится случайными токенами
+func rateLimitKey(r *http.Request, kind string) string {
+	switch {
+	case kind == "auth" && PrincipalFromContext(r.Context()) != "":
+		return "principal:" + PrincipalFromContext(r.Context())
+	case kind == "auth" && r.Header.Get("X-Auth") != "":
+		// Сам токен - секрет, в памяти храним только его хэш
+		sum := sha256.Sum256([]byte(r.Header.Get("X-Auth")))
+		return "auth:" + hex.EncodeToString(sum[:])
+	case strings.HasPrefix(kind, "header:") && r.Header.Get(strings.TrimPrefix(kind, "header:")) != "":
+		return kind + ":" + r.Header.Get(strings.TrimPrefix(kind, "header:"))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimited отвечает 429, если лимит исчерпан
+func rateLimited(w http.ResponseWriter, store RateLimiterStore, endpoint string, key string, rps float64, burst int) bool {
+	err, retryAfter := checkRateLimit(store, endpoint, key, rps, burst)
+	if err == nil {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	response(w, &ApiError{http.StatusTooManyRequests, err}, nil)
+	return true
+}
+
+// checkRateLimit возвращает ошибку для ответа 429 и сколько секунд подождать
+func checkRateLimit(store RateLimiterStore, endpoint string, key string, rps float64, burst int) (error, int) {
+	if store == nil {
+		store = defaultRateLimiterStore
+	}
+	ok, wait := store.Allow(endpoint+"|"+key, rps, burst)
+	if ok {
+		return nil, 0
+	}
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	return errors.New("rate limit exceeded, retry after " + strconv.Itoa(retryAfter) + "s"), retryAfter
+}
+
+// Границы корзин гистограммы задержек, в секундах - как у клиента Prometheus по умолчанию
+var apigenLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type apigenRequestKey struct {
+	Endpoint string
+	Method   string
+	Status   int
+}
+
+type apigenValidationKey struct {
+	Endpoint string
+	Param    string
+}
+
+type apigenHistogram struct {
+	Buckets []uint64 // не накопительные, суммируем при выводе
+	Sum     float64
+	Count   uint64
+}
+
+type apigenMetrics struct {
+	mu         sync.Mutex
+	api        string
+	endpoints  []string
+	requests   map[apigenRequestKey]uint64
+	latency    map[string]*apigenHistogram
+	inFlight   map[string]int64
+	validation map[apigenValidationKey]uint64
+}
+
+func newApigenMetrics(api string, endpoints []string) *apigenMetrics {
+	m := &apigenMetrics{
+		api:        api,
+		endpoints:  endpoints,
+		requests:   map[apigenRequestKey]uint64{},
+		latency:    map[string]*apigenHistogram{},
+		inFlight:   map[string]int64{},
+		validation: map[apigenValidationKey]uint64{},
+	}
+	// Гистограммы и in-flight видны сразу, ещё до первого запроса
+	for _, endpoint := range endpoints {
+		m.latency[endpoint] = &apigenHistogram{Buckets: make([]uint64, len(apigenLatencyBuckets))}
+		m.inFlight[endpoint] = 0
+	}
+	return m
+}
+
+func (m *apigenMetrics) begin(endpoint string) {
+	m.mu.Lock()
+	m.inFlight[endpoint]++
+	m.mu.Unlock()
+}
+
+func (m *apigenMetrics) end(endpoint string, method string, status int, latency time.Duration, info *apigenRequestInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[endpoint]--
+	m.requests[apigenRequestKey{endpoint, metricsMethod(method), status}]++
+
+	hist, ok := m.latency[endpoint]
+	if !ok {
+		// Эндпоинт, которого не было при генерации - например, обработчик вызвали напрямую
+		hist = &apigenHistogram{Buckets: make([]uint64, len(apigenLatencyBuckets))}
+		m.latency[endpoint] = hist
+		m.endpoints = append(m.endpoints, endpoint)
+	}
+	seconds := latency.Seconds()
+	for i, bound := range apigenLatencyBuckets {
+		if seconds <= bound {
+			hist.Buckets[i]++
+			break
+		}
+	}
+	hist.Sum += seconds
+	hist.Count++
+
+	if info.ValidationErr != nil {
+		// Ошибки строгого режима не привязаны к одному параметру
+		param := "_strict"
+		var paramErr *apigenParamError
+		if errors.As(info.ValidationErr, &paramErr) {
+			param = paramErr.Param
+		}
+		m.validation[apigenValidationKey{endpoint, param}]++
+	}
+}
+
+// metricsMethod не даёт клиенту наплодить серий произвольными методами
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *apigenMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(m.String()))
+}
+
+// String - все метрики реестра в текстовом формате Prometheus
+func (m *apigenMetrics) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	api := "api=" + strconv.Quote(m.api)
+	lines := []string{}
+
+	lines = append(lines,
+		"# HELP apigen_requests_total Requests handled by apigen endpoints.",
+		"# TYPE apigen_requests_total counter")
+	series := []string{}
+	for key, value := range m.requests {
+		series = append(series, fmt.Sprintf("apigen_requests_total{%s,endpoint=%q,method=%q,status=\"%d\"} %d",
+			api, key.Endpoint, key.Method, key.Status, value))
+	}
+	sort.Strings(series)
+	lines = append(lines, series...)
+
+	lines = append(lines,
+		"# HELP apigen_request_duration_seconds Latency of apigen endpoints.",
+		"# TYPE apigen_request_duration_seconds histogram")
+	for _, endpoint := range m.endpoints {
+		hist := m.latency[endpoint]
+		labels := fmt.Sprintf("%s,endpoint=%q", api, endpoint)
+		var cumulative uint64
+		for i, bound := range apigenLatencyBuckets {
+			cumulative += hist.Buckets[i]
+			lines = append(lines, fmt.Sprintf("apigen_request_duration_seconds_bucket{%s,le=%q} %d",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative))
+		}
+		lines = append(lines,
+			fmt.Sprintf("apigen_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d", labels, hist.Count),
+			fmt.Sprintf("apigen_request_duration_seconds_sum{%s} %s", labels, strconv.FormatFloat(hist.Sum, 'g', -1, 64)),
+			fmt.Sprintf("apigen_request_duration_seconds_count{%s} %d", labels, hist.Count))
+	}
+
+	lines = append(lines,
+		"# HELP apigen_requests_in_flight Requests currently being handled.",
+		"# TYPE apigen_requests_in_flight gauge")
+	for _, endpoint := range m.endpoints {
+		lines = append(lines, fmt.Sprintf("apigen_requests_in_flight{%s,endpoint=%q} %d", api, endpoint, m.inFlight[endpoint]))
+	}
+
+	lines = append(lines,
+		"# HELP apigen_validation_failures_total Requests rejected by parameter validation.",
+		"# TYPE apigen_validation_failures_total counter")
+	series = []string{}
+	for key, value := range m.validation {
+		series = append(series, fmt.Sprintf("apigen_validation_failures_total{%s,endpoint=%q,param=%q} %d",
+			api, key.Endpoint, key.Param, value))
+	}
+	sort.Strings(series)
+	lines = append(lines, series...)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCServerError    = -32000
+	jsonRPCUnauthorized   = -32001
+	jsonRPCRateLimited    = -32002
+)
+
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonRPCCall func(w http.ResponseWriter, r *http.Request, method string, rawParams json.RawMessage) (interface{}, *jsonRPCError)
+
+// jsonRPCStatus - http-статус, которым ошибка закончилась бы в обычном обработчике
+func jsonRPCStatus(status int) interface{} {
+	return map[string]int{"status": status}
+}
+
+func serveJSONRPC(w http.ResponseWriter, r *http.Request, call jsonRPCCall) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeJSONRPC(w, jsonRPCFailure(nil, &jsonRPCError{jsonRPCInvalidRequest, "only POST is allowed", nil}))
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if !json.Valid(body) {
+		writeJSONRPC(w, jsonRPCFailure(nil, &jsonRPCError{jsonRPCParseError, "parse error", nil}))
+		return
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		resp := handleJSONRPC(w, r, body, call)
+		if resp == nil {
+			// На уведомления не отвечаем
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSONRPC(w, resp)
+		return
+	}
+
+	batch := []json.RawMessage{}
+	json.Unmarshal(body, &batch)
+	if len(batch) == 0 {
+		writeJSONRPC(w, jsonRPCFailure(nil, &jsonRPCError{jsonRPCInvalidRequest, "empty batch", nil}))
+		return
+	}
+
+	responses := []*jsonRPCResponse{}
+	for _, raw := range batch {
+		if resp := handleJSONRPC(w, r, raw, call); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSONRPC(w, responses)
+}
+
+// handleJSONRPC выполняет один вызов; для уведомлений (без id) возвращает nil
+func handleJSONRPC(w http.ResponseWriter, r *http.Request, raw json.RawMessage, call jsonRPCCall) *jsonRPCResponse {
+	req := &jsonRPCRequest{}
+	if err := json.Unmarshal(raw, req); err != nil {
+		return jsonRPCFailure(nil, &jsonRPCError{jsonRPCInvalidRequest, "invalid request", nil})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return jsonRPCFailure(req.ID, &jsonRPCError{jsonRPCInvalidRequest, "invalid request", nil})
+	}
+
+	result, rpcErr := call(w, r, req.Method, req.Params)
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if rpcErr != nil {
+		return jsonRPCFailure(req.ID, rpcErr)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return jsonRPCFailure(req.ID, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(http.StatusInternalServerError)})
+	}
+	return &jsonRPCResponse{JSONRPC: "2.0", Result: data, ID: req.ID}
+}
+
+// jsonRPCMiddlewareWriter - ответ для middleware при вызове по JSON-RPC: заголовки попадают в общий ответ,
+// а статус и тело, если middleware ответил сам, не пишутся поверх JSON-RPC
+type jsonRPCMiddlewareWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *jsonRPCMiddlewareWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *jsonRPCMiddlewareWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// callThroughMiddleware выполняет вызов внутри цепочки middleware эндпоинта. Если middleware
+// не пустил запрос дальше, его ответ становится ошибкой вызова
+func callThroughMiddleware(w http.ResponseWriter, r *http.Request, chain func(http.Handler) http.Handler,
+	call func(w http.ResponseWriter, r *http.Request) (interface{}, *jsonRPCError)) (interface{}, *jsonRPCError) {
+	var result interface{}
+	var rpcErr *jsonRPCError
+	called := false
+	mw := &jsonRPCMiddlewareWriter{ResponseWriter: w}
+	chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		result, rpcErr = call(w, r)
+	})).ServeHTTP(mw, r)
+	if called {
+		return result, rpcErr
+	}
+
+	status := mw.status
+	if status < http.StatusBadRequest {
+		status = http.StatusInternalServerError
+	}
+	message := http.StatusText(status)
+	resp := HTTPResponse{}
+	if json.Unmarshal(mw.body.Bytes(), &resp) == nil && resp.Error != "" {
+		message = resp.Error
+	}
+	return nil, &jsonRPCError{jsonRPCServerError, message, jsonRPCStatus(status)}
+}
+
+// jsonRPCFingerprint - отпечаток уже разобранных params: порядок ключей в json на него не влияет
+func jsonRPCFingerprint(params map[string]string) string {
+	data, _ := json.Marshal(params)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// replayJSONRPCIdempotent - ответ на повторный Idempotency-Key: сохранённый результат, 409 или 422
+func replayJSONRPCIdempotent(w http.ResponseWriter, record IdempotencyRecord, fingerprint string) (interface{}, *jsonRPCError) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		return nil, &jsonRPCError{jsonRPCInvalidParams, "idempotency key is already used with a different payload", jsonRPCStatus(http.StatusUnprocessableEntity)}
+	case record.Status == 0:
+		return nil, &jsonRPCError{jsonRPCServerError, "request with this idempotency key is in progress", jsonRPCStatus(http.StatusConflict)}
+	}
+	saved := jsonRPCResponse{}
+	if err := json.Unmarshal(record.Body, &saved); err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(http.StatusInternalServerError)}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	if saved.Error != nil {
+		return nil, saved.Error
+	}
+	return saved.Result, nil
+}
+
+// finishJSONRPCIdempotent сохраняет результат вызова; ошибки сервера ключ освобождают, как и в HTTP
+func finishJSONRPCIdempotent(store IdempotencyStore, key string, result interface{}, rpcErr *jsonRPCError) {
+	status := http.StatusOK
+	if rpcErr != nil {
+		status = http.StatusInternalServerError
+		if data, ok := rpcErr.Data.(map[string]int); ok && data["status"] != 0 {
+			status = data["status"]
+		}
+	}
+	if status >= http.StatusInternalServerError {
+		store.Release(key)
+		return
+	}
+	saved := jsonRPCResponse{Error: rpcErr}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			store.Release(key)
+			return
+		}
+		saved.Result = data
+	}
+	body, _ := json.Marshal(saved)
+	store.Complete(key, status, body)
+}
+
+func jsonRPCFailure(id json.RawMessage, rpcErr *jsonRPCError) *jsonRPCResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &jsonRPCResponse{JSONRPC: "2.0", Error: rpcErr, ID: id}
+}
+
+func writeJSONRPC(w http.ResponseWriter, resp interface{}) {
+	bytes, _ := json.Marshal(resp)
+	w.Write(bytes)
+}
+
+// jsonRPCParamsToMap приводит params (объект по именам или массив по порядку полей)
+// к тому же виду, что queryParamsToMap, чтобы дальше работали те же валидаторы
+func jsonRPCParamsToMap(rawParams json.RawMessage, names []string, strict bool) (map[string]string, error) {
+	values := map[string]string{}
+	raw := map[string]json.RawMessage{}
+
+	trimmed := strings.TrimSpace(string(rawParams))
+	switch {
+	case trimmed == "" || trimmed == "null":
+		return values, nil
+	case strings.HasPrefix(trimmed, "["):
+		list := []json.RawMessage{}
+		if err := json.Unmarshal(rawParams, &list); err != nil {
+			return nil, errors.New("invalid params")
+		}
+		if len(list) > len(names) {
+			return nil, errors.New("too many params, expected at most " + strconv.Itoa(len(names)))
+		}
+		for i, v := range list {
+			raw[names[i]] = v
+		}
+	default:
+		if err := json.Unmarshal(rawParams, &raw); err != nil {
+			return nil, errors.New("params must be an object or an array")
+		}
+	}
+
+	unknown := []string{}
+	for k, v := range raw {
+		if !contains(names, k) {
+			unknown = append(unknown, k)
+			continue
+		}
+
+		var value interface{}
+		json.Unmarshal(v, &value)
+		switch typed := value.(type) {
+		case nil:
+			continue
+		case string:
+			values[k] = typed
+		case float64, bool:
+			values[k] = string(v)
+		default:
+			return nil, errors.New(k + " must be a string or a number")
+		}
+	}
+	if strict && len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.New("unknown params: " + strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+
+func (srv *MyApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, requestID := withRequestID(w, r)
+	ctx, span := startTrace(r, srv.SpanExporter, "apigen.request")
+	defer span.Finish()
+	span.SetAttr("request_id", requestID)
+	span.SetAttr("http.method", r.Method)
+	span.SetAttr("http.path", r.URL.Path)
+	w.Header().Set("traceparent", span.TraceParent())
+	r = r.WithContext(ctx)
+
+	
+	if handleCORS(w, r, myApiCORS[r.URL.Path]) {
+		return
+	}
+	
+	_, routeSpan := StartSpan(ctx, "apigen.route")
+	defer routeSpan.Finish()
+	switch r.URL.Path {
+	
+	case "/user/profile":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/profile", http.HandlerFunc(srv.ProfileHTTPHandler))
+		return
+	
+	case "/user/create":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/create", http.HandlerFunc(srv.CreateHTTPHandler))
+		return
+	
+	case "/user/update":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/update", http.HandlerFunc(srv.UpdateHTTPHandler))
+		return
+	
+	case "/user/delete":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/delete", http.HandlerFunc(srv.DeleteHTTPHandler))
+		return
+	
+	case "/user/list":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/list", http.HandlerFunc(srv.ListHTTPHandler))
+		return
+	
+	case "/user/login":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/login", http.HandlerFunc(srv.LoginHTTPHandler))
+		return
+	
+	case "/user/logout":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/logout", http.HandlerFunc(srv.LogoutHTTPHandler))
+		return
+	
+	case "/user/refresh":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/refresh", http.HandlerFunc(srv.RefreshHTTPHandler))
+		return
+	
+	case "/user/password":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/password", http.HandlerFunc(srv.ChangePasswordHTTPHandler))
+		return
+	
+	case "/audit":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/audit", http.HandlerFunc(srv.AuditHTTPHandler))
+		return
+	
+	
+	case "/rpc":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/rpc", http.HandlerFunc(srv.ServeJSONRPC))
+		return
+	
+	
+	case "/_routes":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(MyApiRoutesJSON))
+		return
+	case "/_docs":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(MyApiDocsHTML))
+		return
+	
+	
+	case "/_metrics":
+		myApiMetrics.ServeHTTP(w, r)
+		return
+	
+	default:
+		response(w, &ApiError{http.StatusNotFound, errors.New("unknown method")}, nil)
+		return
+	}
+}
+
+// apigenServe - обвязка вокруг каждого обработчика: одна строка лога на запрос, паника - ответ 500
+func (srv *MyApi) apigenServe(w http.ResponseWriter, r *http.Request, endpoint string, h http.Handler) {
+	start := time.Now()
+	info := &apigenRequestInfo{}
+	rec := &apigenResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	myApiMetrics.begin(endpoint)
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				info.Err = handleApigenPanic(rec, r, endpoint, recovered, srv.Logger, srv.PanicHook)
+			}
+		}()
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), apigenRequestInfoKey{}, info)))
+	}()
+	latency := time.Since(start)
+	myApiMetrics.end(endpoint, r.Method, rec.status, latency, info)
+	if span := SpanFromContext(r.Context()); span != nil {
+		span.SetAttr("apigen.endpoint", endpoint)
+		span.SetAttr("http.status_code", strconv.Itoa(rec.status))
+		span.SetError(info.Err)
+	}
+	logApigenRequest(srv.Logger, r, endpoint, rec.status, latency, info)
+}
+
+// myApiMetrics - счётчики эндпоинтов MyApi, отдаются на /_metrics
+var myApiMetrics = newApigenMetrics("MyApi", []string{ "/user/profile", "/user/create", "/user/update", "/user/delete", "/user/list", "/user/login", "/user/logout", "/user/refresh", "/user/password", "/audit", "/rpc"})
+
+
+// myApiCORS - CORS-политики по путям, собраны из apigen:struct и apigen:api
+var myApiCORS = map[string]*corsPolicy{
+	"/audit": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"GET"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/rpc": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"POST"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/user/create": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"POST"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/user/delete": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"DELETE"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/user/list": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"GET"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/user/login": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"POST"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/user/logout": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"POST"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/user/password": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"POST"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/user/profile": {
+		Origins:     []string{"*"},
+		Methods:     []string{"GET", "POST"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: false,
+		MaxAge:      600,
+	},
+	"/user/refresh": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"POST"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+	"/user/update": {
+		Origins:     []string{"http://localhost:3000"},
+		Methods:     []string{"POST"},
+		Headers:     []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"},
+		Credentials: true,
+		MaxAge:      600,
+	},
+}
+
+
+// Таблица маршрутов и страница документации собраны на этапе генерации
+const MyApiRoutesJSON = `{"error":"","response":[{"url":"/user/profile","method":"ANY","auth":false,"handler":"Profile","params":[{"name":"login","field":"Login","type":"string","rules":"required","required":true}]},{"url":"/user/create","method":"POST","auth":true,"roles":["moderator","admin"],"handler":"Create","params":[{"name":"login","field":"Login","type":"string","rules":"required,min=10","required":true,"min":10},{"name":"full_name","field":"Name","type":"string","rules":"paramname=full_name","required":false},{"name":"status","field":"Status","type":"string","rules":"enum=user|moderator|admin,default=user","required":false,"enum":["user","moderator","admin"],"default":"user"},{"name":"age","field":"Age","type":"int","rules":"min=0,max=128","required":false,"min":0,"max":128},{"name":"password","field":"Password","type":"string","rules":"min=8,max=128,classes=lower|upper|digit,sensitive,omitempty","required":false,"min":8,"max":128,"classes":["lower","upper","digit"],"sensitive":true}]},{"url":"/user/update","method":"POST","auth":true,"handler":"Update","params":[{"name":"id","field":"ID","type":"int","rules":"required,min=1","required":true,"min":1},{"name":"full_name","field":"FullName","type":"string","rules":"paramname=full_name,max=128","required":false,"max":128},{"name":"status","field":"Status","type":"string","rules":"enum=user|moderator|admin","required":false,"enum":["user","moderator","admin"]}]},{"url":"/user/delete","method":"DELETE","auth":true,"roles":["admin"],"handler":"Delete","params":[{"name":"id","field":"ID","type":"int","rules":"required,min=1","required":true,"min":1}]},{"url":"/user/list","method":"GET","auth":true,"roles":["moderator","admin"],"handler":"List","params":[{"name":"cursor","field":"Cursor","type":"string","rules":"max=64","required":false,"max":64},{"name":"limit","field":"Limit","type":"int","rules":"min=1,max=100,default=20","required":false,"min":1,"max":100,"default":"20"},{"name":"status","field":"Status","type":"string","rules":"enum=user|moderator|admin","required":false,"enum":["user","moderator","admin"]},{"name":"login_prefix","field":"LoginPrefix","type":"string","rules":"paramname=login_prefix,max=64","required":false,"max":64}]},{"url":"/user/login","method":"POST","auth":false,"handler":"Login","params":[{"name":"login","field":"Login","type":"string","rules":"required","required":true},{"name":"password","field":"Password","type":"string","rules":"required,sensitive","required":true,"sensitive":true}]},{"url":"/user/logout","method":"POST","auth":true,"handler":"Logout","params":[]},{"url":"/user/refresh","method":"POST","auth":true,"handler":"Refresh","params":[]},{"url":"/user/password","method":"POST","auth":true,"handler":"ChangePassword","params":[{"name":"old_password","field":"OldPassword","type":"string","rules":"paramname=old_password,required,sensitive","required":true,"sensitive":true},{"name":"new_password","field":"NewPassword","type":"string","rules":"paramname=new_password,required,min=8,max=128,classes=lower|upper|digit,sensitive","required":true,"min":8,"max":128,"classes":["lower","upper","digit"],"sensitive":true}]},{"url":"/audit","method":"GET","auth":true,"roles":["admin"],"handler":"Audit","params":[{"name":"user","field":"User","type":"string","rules":"max=128","required":false,"max":128},{"name":"from","field":"From","type":"int","rules":"min=0,default=0","required":false,"min":0,"default":"0"},{"name":"to","field":"To","type":"int","rules":"min=0,default=0","required":false,"min":0,"default":"0"},{"name":"limit","field":"Limit","type":"int","rules":"min=1,max=1000,default=100","required":false,"min":1,"max":1000,"default":"100"}]}]}`
+
+const MyApiDocsHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>MyApi</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+form { border: 1px solid #ccc; padding: 1em; margin-bottom: 1em; }
+label { display: block; margin: 0.3em 0; }
+pre { background: #f4f4f4; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>MyApi</h1>
+
+<form class="route" data-url="/user/profile">
+<h2>/user/profile</h2>
+<p>Profile, method: ANY</p>
+<label>method
+<select name="_method"><option>GET</option><option>POST</option></select>
+</label>
+
+
+<label>login (string; required) <input name="login"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/user/create">
+<h2>/user/create</h2>
+<p>Create, method: POST, auth required, roles: moderator, admin</p>
+<label>method
+<select name="_method"><option>POST</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<label>login (string; required,min=10) <input name="login"></label>
+
+<label>full_name (string; paramname=full_name) <input name="full_name"></label>
+
+<label>status (string; enum=user|moderator|admin,default=user) <input name="status" placeholder="user"></label>
+
+<label>age (int; min=0,max=128) <input name="age"></label>
+
+<label>password (string; min=8,max=128,classes=lower|upper|digit,sensitive,omitempty) <input name="password" type="password"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/user/update">
+<h2>/user/update</h2>
+<p>Update, method: POST, auth required</p>
+<label>method
+<select name="_method"><option>POST</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<label>id (int; required,min=1) <input name="id"></label>
+
+<label>full_name (string; paramname=full_name,max=128) <input name="full_name"></label>
+
+<label>status (string; enum=user|moderator|admin) <input name="status"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/user/delete">
+<h2>/user/delete</h2>
+<p>Delete, method: DELETE, auth required, roles: admin</p>
+<label>method
+<select name="_method"><option>DELETE</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<label>id (int; required,min=1) <input name="id"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/user/list">
+<h2>/user/list</h2>
+<p>List, method: GET, auth required, roles: moderator, admin</p>
+<label>method
+<select name="_method"><option>GET</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<label>cursor (string; max=64) <input name="cursor"></label>
+
+<label>limit (int; min=1,max=100,default=20) <input name="limit" placeholder="20"></label>
+
+<label>status (string; enum=user|moderator|admin) <input name="status"></label>
+
+<label>login_prefix (string; paramname=login_prefix,max=64) <input name="login_prefix"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/user/login">
+<h2>/user/login</h2>
+<p>Login, method: POST</p>
+<label>method
+<select name="_method"><option>POST</option></select>
+</label>
+
+
+<label>login (string; required) <input name="login"></label>
+
+<label>password (string; required,sensitive) <input name="password" type="password"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/user/logout">
+<h2>/user/logout</h2>
+<p>Logout, method: POST, auth required</p>
+<label>method
+<select name="_method"><option>POST</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/user/refresh">
+<h2>/user/refresh</h2>
+<p>Refresh, method: POST, auth required</p>
+<label>method
+<select name="_method"><option>POST</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/user/password">
+<h2>/user/password</h2>
+<p>ChangePassword, method: POST, auth required</p>
+<label>method
+<select name="_method"><option>POST</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<label>old_password (string; paramname=old_password,required,sensitive) <input name="old_password" type="password"></label>
+
+<label>new_password (string; paramname=new_password,required,min=8,max=128,classes=lower|upper|digit,sensitive) <input name="new_password" type="password"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<form class="route" data-url="/audit">
+<h2>/audit</h2>
+<p>Audit, method: GET, auth required, roles: admin</p>
+<label>method
+<select name="_method"><option>GET</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<label>user (string; max=128) <input name="user"></label>
+
+<label>from (int; min=0,default=0) <input name="from" placeholder="0"></label>
+
+<label>to (int; min=0,default=0) <input name="to" placeholder="0"></label>
+
+<label>limit (int; min=1,max=1000,default=100) <input name="limit" placeholder="100"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<script>
+document.querySelectorAll("form.route").forEach(function (form) {
+  form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    var params = new URLSearchParams();
+    var method = "GET";
+    var headers = {};
+    new FormData(form).forEach(function (value, key) {
+      if (key === "_method") {
+        method = value;
+      } else if (key === "_auth") {
+        if (value !== "") headers["X-Auth"] = value;
+      } else if (value !== "") {
+        params.append(key, value);
+      }
+    });
+    var url = form.dataset.url;
+    var init = { method: method, headers: headers };
+    
+    if (method === "POST") {
+      headers["Content-Type"] = "application/x-www-form-urlencoded";
+      init.body = params.toString();
+    } else {
+      url += "?" + params.toString();
+    }
+    var out = form.querySelector(".result");
+    fetch(url, init).then(function (resp) {
+      return resp.text().then(function (text) {
+        out.textContent = resp.status + "\n" + text;
+      });
+    }).catch(function (err) {
+      out.textContent = String(err);
+    });
+  });
+});
+</script>
+</body>
+</html>
+`
+
+
+func (srv *MyApi) ProfileHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseProfileParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+	cacheKey := responseCacheKey("/user/profile", urlParams)
+	if r.Method == http.MethodGet {
+		if body, ok := responseCacheOrDefault(srv.ResponseCache).Get(cacheKey); ok {
+			w.Header().Set("X-Cache", "HIT")
+			writeCacheable(w, r, body, "public, max-age=10")
+			return
+		}
+	}
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.Profile")
+	data, err := srv.Profile(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	
+	if r.Method == http.MethodGet {
+		body := encodeResponse(data)
+		responseCacheOrDefault(srv.ResponseCache).Set(cacheKey, body, 30*time.Second)
+		w.Header().Set("X-Cache", "MISS")
+		writeCacheable(w, r, body, "public, max-age=10")
+		return
+	}
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseProfileParams проверяет параметры по правилам apivalidator и собирает из них ProfileParams
+func (srv *MyApi) parseProfileParams(queryParams map[string]string) (ProfileParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramLogin, err, statusCode := validParamStr("Login", "required", queryParams)
+	if err != nil {
+		return ProfileParams{}, &apigenParamError{"login", err}, statusCode
+	}
+	
+	return ProfileParams{
+		
+		Login: paramLogin,
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) CreateHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "POST" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	if rateLimited(w, srv.RateLimiter, "/user/create", rateLimitKey(r, "ip"), 5, 20) {
+		return
+	}
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), srv.Authenticator, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	
+	if err := checkRole(r.Context(), []string{ "moderator", "admin", }); err != nil {
+		response(w, &ApiError{http.StatusForbidden, err}, nil)
+		return
+	}
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ "password", })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseCreateParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+	if key := r.Header.Get("Idempotency-Key"); key != "" && r.Method == http.MethodPost {
+		// Ключи разных клиентов не пересекаются
+		store := idempotencyStoreOrDefault(srv.IdempotencyStore)
+		storeKey := "/user/create|" + idempotencyCaller(r) + "|" + key
+		fingerprint := idempotencyFingerprint(r, body)
+		if record, reserved := store.Reserve(storeKey, fingerprint, idempotencyTTL); !reserved {
+			replayIdempotent(w, record, fingerprint)
+			return
+		}
+		idempotent := &idempotencyRecorder{ResponseWriter: w}
+		defer idempotent.finish(store, storeKey)
+		w = idempotent
+	}
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.Create")
+	data, err := srv.Create(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	responseCacheOrDefault(srv.ResponseCache).DeletePrefix("/user/profile?")
+	writeAudit(r.Context(), srv.AuditSink, srv.Logger, "/user/create", "POST", info.Params, data)
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseCreateParams проверяет параметры по правилам apivalidator и собирает из них CreateParams
+func (srv *MyApi) parseCreateParams(queryParams map[string]string) (CreateParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramLogin, err, statusCode := validParamStr("Login", "required,min=10", queryParams)
+	if err != nil {
+		return CreateParams{}, &apigenParamError{"login", err}, statusCode
+	}
+	
+	paramName, err, statusCode := validParamStr("Name", "paramname=full_name", queryParams)
+	if err != nil {
+		return CreateParams{}, &apigenParamError{"full_name", err}, statusCode
+	}
+	
+	paramStatus, err, statusCode := validParamStr("Status", "enum=user|moderator|admin,default=user", queryParams)
+	if err != nil {
+		return CreateParams{}, &apigenParamError{"status", err}, statusCode
+	}
+	
+	paramAge, err, statusCode := validParamInt("Age", "min=0,max=128", queryParams)
+	if err != nil {
+		return CreateParams{}, &apigenParamError{"age", err}, statusCode
+	}
+	
+	paramPassword, err, statusCode := validParamStr("Password", "min=8,max=128,classes=lower|upper|digit,sensitive,omitempty", queryParams)
+	if err != nil {
+		return CreateParams{}, &apigenParamError{"password", err}, statusCode
+	}
+	
+	return CreateParams{
+		
+		Login: paramLogin,
+		Name: paramName,
+		Status: paramStatus,
+		Age: paramAge,
+		Password: paramPassword,
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) UpdateHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "POST" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), srv.Authenticator, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseUpdateParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.Update")
+	data, err := srv.Update(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	responseCacheOrDefault(srv.ResponseCache).DeletePrefix("/user/profile?")
+	writeAudit(r.Context(), srv.AuditSink, srv.Logger, "/user/update", "POST", info.Params, data)
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseUpdateParams проверяет параметры по правилам apivalidator и собирает из них UpdateParams
+func (srv *MyApi) parseUpdateParams(queryParams map[string]string) (UpdateParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramID, err, statusCode := validParamInt("ID", "required,min=1", queryParams)
+	if err != nil {
+		return UpdateParams{}, &apigenParamError{"id", err}, statusCode
+	}
+	
+	paramFullName, err, statusCode := validParamStr("FullName", "paramname=full_name,max=128", queryParams)
+	if err != nil {
+		return UpdateParams{}, &apigenParamError{"full_name", err}, statusCode
+	}
+	
+	paramStatus, err, statusCode := validParamStr("Status", "enum=user|moderator|admin", queryParams)
+	if err != nil {
+		return UpdateParams{}, &apigenParamError{"status", err}, statusCode
+	}
+	
+	return UpdateParams{
+		
+		ID: paramID,
+		FullName: paramFullName,
+		Status: paramStatus,
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) DeleteHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "DELETE" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), srv.Authenticator, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	
+	if err := checkRole(r.Context(), []string{ "admin", }); err != nil {
+		response(w, &ApiError{http.StatusForbidden, err}, nil)
+		return
+	}
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseDeleteParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.Delete")
+	data, err := srv.Delete(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	responseCacheOrDefault(srv.ResponseCache).DeletePrefix("/user/profile?")
+	writeAudit(r.Context(), srv.AuditSink, srv.Logger, "/user/delete", "DELETE", info.Params, data)
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseDeleteParams проверяет параметры по правилам apivalidator и собирает из них DeleteParams
+func (srv *MyApi) parseDeleteParams(queryParams map[string]string) (DeleteParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramID, err, statusCode := validParamInt("ID", "required,min=1", queryParams)
+	if err != nil {
+		return DeleteParams{}, &apigenParamError{"id", err}, statusCode
+	}
+	
+	return DeleteParams{
+		
+		ID: paramID,
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) ListHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "GET" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), srv.Authenticator, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	
+	if err := checkRole(r.Context(), []string{ "moderator", "admin", }); err != nil {
+		response(w, &ApiError{http.StatusForbidden, err}, nil)
+		return
+	}
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseListParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.List")
+	data, err := srv.List(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseListParams проверяет параметры по правилам apivalidator и собирает из них ListParams
+func (srv *MyApi) parseListParams(queryParams map[string]string) (ListParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramCursor, err, statusCode := validParamStr("Cursor", "max=64", queryParams)
+	if err != nil {
+		return ListParams{}, &apigenParamError{"cursor", err}, statusCode
+	}
+	
+	paramLimit, err, statusCode := validParamInt("Limit", "min=1,max=100,default=20", queryParams)
+	if err != nil {
+		return ListParams{}, &apigenParamError{"limit", err}, statusCode
+	}
+	
+	paramStatus, err, statusCode := validParamStr("Status", "enum=user|moderator|admin", queryParams)
+	if err != nil {
+		return ListParams{}, &apigenParamError{"status", err}, statusCode
+	}
+	
+	paramLoginPrefix, err, statusCode := validParamStr("LoginPrefix", "paramname=login_prefix,max=64", queryParams)
+	if err != nil {
+		return ListParams{}, &apigenParamError{"login_prefix", err}, statusCode
+	}
+	
+	return ListParams{
+		
+		Cursor: paramCursor,
+		Limit: paramLimit,
+		Status: paramStatus,
+		LoginPrefix: paramLoginPrefix,
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) LoginHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "POST" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	if rateLimited(w, srv.RateLimiter, "/user/login", rateLimitKey(r, "ip"), 1, 10) {
+		return
+	}
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ "password", })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseLoginParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.Login")
+	data, err := srv.Login(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	writeAudit(r.Context(), srv.AuditSink, srv.Logger, "/user/login", "POST", info.Params, data)
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseLoginParams проверяет параметры по правилам apivalidator и собирает из них LoginParams
+func (srv *MyApi) parseLoginParams(queryParams map[string]string) (LoginParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramLogin, err, statusCode := validParamStr("Login", "required", queryParams)
+	if err != nil {
+		return LoginParams{}, &apigenParamError{"login", err}, statusCode
+	}
+	
+	paramPassword, err, statusCode := validParamStr("Password", "required,sensitive", queryParams)
+	if err != nil {
+		return LoginParams{}, &apigenParamError{"password", err}, statusCode
+	}
+	
+	return LoginParams{
+		
+		Login: paramLogin,
+		Password: paramPassword,
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) LogoutHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "POST" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), srv.Authenticator, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseLogoutParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.Logout")
+	data, err := srv.Logout(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	writeAudit(r.Context(), srv.AuditSink, srv.Logger, "/user/logout", "POST", info.Params, data)
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseLogoutParams проверяет параметры по правилам apivalidator и собирает из них LogoutParams
+func (srv *MyApi) parseLogoutParams(queryParams map[string]string) (LogoutParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	return LogoutParams{
+		
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) RefreshHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "POST" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), srv.Authenticator, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseRefreshParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.Refresh")
+	data, err := srv.Refresh(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	writeAudit(r.Context(), srv.AuditSink, srv.Logger, "/user/refresh", "POST", info.Params, data)
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseRefreshParams проверяет параметры по правилам apivalidator и собирает из них RefreshParams
+func (srv *MyApi) parseRefreshParams(queryParams map[string]string) (RefreshParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	return RefreshParams{
+		
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) ChangePasswordHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "POST" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), srv.Authenticator, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		// С неверным токеном своего ведра нет - считаем по ip
+		if rateLimited(w, srv.RateLimiter, "/user/password", rateLimitKey(r, "ip"), 1, 5) {
+			return
+		}
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	if rateLimited(w, srv.RateLimiter, "/user/password", rateLimitKey(r, "auth"), 1, 5) {
+		return
+	}
+	
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ "old_password", "new_password", })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseChangePasswordParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.ChangePassword")
+	data, err := srv.ChangePassword(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	writeAudit(r.Context(), srv.AuditSink, srv.Logger, "/user/password", "POST", info.Params, data)
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseChangePasswordParams проверяет параметры по правилам apivalidator и собирает из них ChangePasswordParams
+func (srv *MyApi) parseChangePasswordParams(queryParams map[string]string) (ChangePasswordParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramOldPassword, err, statusCode := validParamStr("OldPassword", "paramname=old_password,required,sensitive", queryParams)
+	if err != nil {
+		return ChangePasswordParams{}, &apigenParamError{"old_password", err}, statusCode
+	}
+	
+	paramNewPassword, err, statusCode := validParamStr("NewPassword", "paramname=new_password,required,min=8,max=128,classes=lower|upper|digit,sensitive", queryParams)
+	if err != nil {
+		return ChangePasswordParams{}, &apigenParamError{"new_password", err}, statusCode
+	}
+	
+	return ChangePasswordParams{
+		
+		OldPassword: paramOldPassword,
+		NewPassword: paramNewPassword,
+	}, nil, http.StatusOK
+}
+
+func (srv *MyApi) AuditHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "GET" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), srv.Authenticator, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	
+	if err := checkRole(r.Context(), []string{ "admin", }); err != nil {
+		response(w, &ApiError{http.StatusForbidden, err}, nil)
+		return
+	}
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ })
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseAuditParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "MyApi.Audit")
+	data, err := srv.Audit(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseAuditParams проверяет параметры по правилам apivalidator и собирает из них AuditParams
+func (srv *MyApi) parseAuditParams(queryParams map[string]string) (AuditParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramUser, err, statusCode := validParamStr("User", "max=128", queryParams)
+	if err != nil {
+		return AuditParams{}, &apigenParamError{"user", err}, statusCode
+	}
+	
+	paramFrom, err, statusCode := validParamInt("From", "min=0,default=0", queryParams)
+	if err != nil {
+		return AuditParams{}, &apigenParamError{"from", err}, statusCode
+	}
+	
+	paramTo, err, statusCode := validParamInt("To", "min=0,default=0", queryParams)
+	if err != nil {
+		return AuditParams{}, &apigenParamError{"to", err}, statusCode
+	}
+	
+	paramLimit, err, statusCode := validParamInt("Limit", "min=1,max=1000,default=100", queryParams)
+	if err != nil {
+		return AuditParams{}, &apigenParamError{"limit", err}, statusCode
+	}
+	
+	return AuditParams{
+		
+		User: paramUser,
+		From: paramFrom,
+		To: paramTo,
+		Limit: paramLimit,
+	}, nil, http.StatusOK
+}
+
+
+// ServeJSONRPC - JSON-RPC 2.0 поверх тех же методов: "MyApi.Имя"
+func (srv *MyApi) ServeJSONRPC(w http.ResponseWriter, r *http.Request) {
+	serveJSONRPC(w, r, srv.callJSONRPC)
+}
+
+func (srv *MyApi) callJSONRPC(w http.ResponseWriter, r *http.Request, method string, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	switch method {
+	
+	case "MyApi.Profile":
+		return srv.jsonRPCProfile(w, r, rawParams)
+	
+	case "MyApi.Create":
+		return srv.jsonRPCCreate(w, r, rawParams)
+	
+	case "MyApi.Update":
+		return srv.jsonRPCUpdate(w, r, rawParams)
+	
+	case "MyApi.Delete":
+		return srv.jsonRPCDelete(w, r, rawParams)
+	
+	case "MyApi.List":
+		return srv.jsonRPCList(w, r, rawParams)
+	
+	case "MyApi.Login":
+		return srv.jsonRPCLogin(w, r, rawParams)
+	
+	case "MyApi.Logout":
+		return srv.jsonRPCLogout(w, r, rawParams)
+	
+	case "MyApi.Refresh":
+		return srv.jsonRPCRefresh(w, r, rawParams)
+	
+	case "MyApi.ChangePassword":
+		return srv.jsonRPCChangePassword(w, r, rawParams)
+	
+	case "MyApi.Audit":
+		return srv.jsonRPCAudit(w, r, rawParams)
+	
+	}
+	return nil, &jsonRPCError{jsonRPCMethodNotFound, "method not found", nil}
+}
+
+func (srv *MyApi) jsonRPCProfile(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "login", }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseProfileParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.Profile")
+	data, err := srv.Profile(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCCreate(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (result interface{}, rpcErr *jsonRPCError) {
+	ctx := r.Context()
+	
+	if err, retryAfter := checkRateLimit(srv.RateLimiter, "/user/create", rateLimitKey(r, "ip"), 5, 20); err != nil {
+		return nil, &jsonRPCError{jsonRPCRateLimited, err.Error(), map[string]int{"status": http.StatusTooManyRequests, "retry_after": retryAfter}}
+	}
+	
+	
+	authCtx, err := authenticate(ctx, srv.Authenticator, r)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	if err := checkRole(ctx, []string{ "moderator", "admin", }); err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "login", "full_name", "status", "age", "password", }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseCreateParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		store := idempotencyStoreOrDefault(srv.IdempotencyStore)
+		// Ответы JSON-RPC хранятся в другом формате, чем HTTP, поэтому и ключи у них свои
+		storeKey := "rpc|/user/create|" + idempotencyCaller(r.WithContext(ctx)) + "|" + key
+		fingerprint := jsonRPCFingerprint(queryParams)
+		if record, reserved := store.Reserve(storeKey, fingerprint, idempotencyTTL); !reserved {
+			return replayJSONRPCIdempotent(w, record, fingerprint)
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				store.Release(storeKey)
+				panic(p)
+			}
+			finishJSONRPCIdempotent(store, storeKey, result, rpcErr)
+		}()
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.Create")
+	data, err := srv.Create(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	responseCacheOrDefault(srv.ResponseCache).DeletePrefix("/user/profile?")
+	writeAudit(ctx, srv.AuditSink, srv.Logger, "/user/create", "POST", redactParams(queryParams, []string{ "password", }), data)
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCUpdate(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	authCtx, err := authenticate(ctx, srv.Authenticator, r)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "id", "full_name", "status", }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseUpdateParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.Update")
+	data, err := srv.Update(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	responseCacheOrDefault(srv.ResponseCache).DeletePrefix("/user/profile?")
+	writeAudit(ctx, srv.AuditSink, srv.Logger, "/user/update", "POST", redactParams(queryParams, []string{ }), data)
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCDelete(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	authCtx, err := authenticate(ctx, srv.Authenticator, r)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	if err := checkRole(ctx, []string{ "admin", }); err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "id", }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseDeleteParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.Delete")
+	data, err := srv.Delete(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	responseCacheOrDefault(srv.ResponseCache).DeletePrefix("/user/profile?")
+	writeAudit(ctx, srv.AuditSink, srv.Logger, "/user/delete", "DELETE", redactParams(queryParams, []string{ }), data)
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCList(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	authCtx, err := authenticate(ctx, srv.Authenticator, r)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	if err := checkRole(ctx, []string{ "moderator", "admin", }); err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "cursor", "limit", "status", "login_prefix", }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseListParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.List")
+	data, err := srv.List(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCLogin(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	if err, retryAfter := checkRateLimit(srv.RateLimiter, "/user/login", rateLimitKey(r, "ip"), 1, 10); err != nil {
+		return nil, &jsonRPCError{jsonRPCRateLimited, err.Error(), map[string]int{"status": http.StatusTooManyRequests, "retry_after": retryAfter}}
+	}
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "login", "password", }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseLoginParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.Login")
+	data, err := srv.Login(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	writeAudit(ctx, srv.AuditSink, srv.Logger, "/user/login", "POST", redactParams(queryParams, []string{ "password", }), data)
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCLogout(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	authCtx, err := authenticate(ctx, srv.Authenticator, r)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseLogoutParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.Logout")
+	data, err := srv.Logout(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	writeAudit(ctx, srv.AuditSink, srv.Logger, "/user/logout", "POST", redactParams(queryParams, []string{ }), data)
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCRefresh(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	authCtx, err := authenticate(ctx, srv.Authenticator, r)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseRefreshParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.Refresh")
+	data, err := srv.Refresh(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	writeAudit(ctx, srv.AuditSink, srv.Logger, "/user/refresh", "POST", redactParams(queryParams, []string{ }), data)
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCChangePassword(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	authCtx, err := authenticate(ctx, srv.Authenticator, r)
+	// С неверным токеном своего ведра нет - считаем по ip
+	rateKey := rateLimitKey(r, "ip")
+	if err == nil {
+		rateKey = rateLimitKey(r.WithContext(authCtx), "auth")
+	}
+	if err, retryAfter := checkRateLimit(srv.RateLimiter, "/user/password", rateKey, 1, 5); err != nil {
+		return nil, &jsonRPCError{jsonRPCRateLimited, err.Error(), map[string]int{"status": http.StatusTooManyRequests, "retry_after": retryAfter}}
+	}
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "old_password", "new_password", }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseChangePasswordParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.ChangePassword")
+	data, err := srv.ChangePassword(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	writeAudit(ctx, srv.AuditSink, srv.Logger, "/user/password", "POST", redactParams(queryParams, []string{ "old_password", "new_password", }), data)
+	return data, nil
+}
+
+func (srv *MyApi) jsonRPCAudit(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	authCtx, err := authenticate(ctx, srv.Authenticator, r)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	if err := checkRole(ctx, []string{ "admin", }); err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "user", "from", "to", "limit", }, false)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseAuditParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "MyApi.Audit")
+	data, err := srv.Audit(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	return data, nil
+}
+
+
+func (srv *OtherApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, requestID := withRequestID(w, r)
+	ctx, span := startTrace(r, srv.SpanExporter, "apigen.request")
+	defer span.Finish()
+	span.SetAttr("request_id", requestID)
+	span.SetAttr("http.method", r.Method)
+	span.SetAttr("http.path", r.URL.Path)
+	w.Header().Set("traceparent", span.TraceParent())
+	r = r.WithContext(ctx)
+
+	
+	_, routeSpan := StartSpan(ctx, "apigen.route")
+	defer routeSpan.Finish()
+	switch r.URL.Path {
+	
+	case "/user/create":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/user/create", srv.withServerHeader(http.HandlerFunc(srv.CreateHTTPHandler)))
+		return
+	
+	
+	case "/rpc":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "/rpc", http.HandlerFunc(srv.ServeJSONRPC))
+		return
+	
+	
+	case "/_routes":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(OtherApiRoutesJSON))
+		return
+	case "/_docs":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(OtherApiDocsHTML))
+		return
+	
+	
+	case "/_metrics":
+		otherApiMetrics.ServeHTTP(w, r)
+		return
+	
+	default:
+		response(w, &ApiError{http.StatusNotFound, errors.New("unknown method")}, nil)
+		return
+	}
+}
+
+// apigenServe - обвязка вокруг каждого обработчика: одна строка лога на запрос, паника - ответ 500
+func (srv *OtherApi) apigenServe(w http.ResponseWriter, r *http.Request, endpoint string, h http.Handler) {
+	start := time.Now()
+	info := &apigenRequestInfo{}
+	rec := &apigenResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	otherApiMetrics.begin(endpoint)
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				info.Err = handleApigenPanic(rec, r, endpoint, recovered, srv.Logger, nil)
+			}
+		}()
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), apigenRequestInfoKey{}, info)))
+	}()
+	latency := time.Since(start)
+	otherApiMetrics.end(endpoint, r.Method, rec.status, latency, info)
+	if span := SpanFromContext(r.Context()); span != nil {
+		span.SetAttr("apigen.endpoint", endpoint)
+		span.SetAttr("http.status_code", strconv.Itoa(rec.status))
+		span.SetError(info.Err)
+	}
+	logApigenRequest(srv.Logger, r, endpoint, rec.status, latency, info)
+}
+
+// otherApiMetrics - счётчики эндпоинтов OtherApi, отдаются на /_metrics
+var otherApiMetrics = newApigenMetrics("OtherApi", []string{ "/user/create", "/rpc"})
+
+
+
+// Таблица маршрутов и страница документации собраны на этапе генерации
+const OtherApiRoutesJSON = `{"error":"","response":[{"url":"/user/create","method":"POST","auth":true,"handler":"Create","params":[{"name":"username","field":"Username","type":"string","rules":"required,min=3","required":true,"min":3},{"name":"account_name","field":"Name","type":"string","rules":"paramname=account_name","required":false},{"name":"class","field":"Class","type":"string","rules":"enum=warrior|sorcerer|rouge,default=warrior","required":false,"enum":["warrior","sorcerer","rouge"],"default":"warrior"},{"name":"level","field":"Level","type":"int","rules":"min=1,max=50","required":false,"min":1,"max":50}]}]}`
+
+const OtherApiDocsHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>OtherApi</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+form { border: 1px solid #ccc; padding: 1em; margin-bottom: 1em; }
+label { display: block; margin: 0.3em 0; }
+pre { background: #f4f4f4; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>OtherApi</h1>
+
+<form class="route" data-url="/user/create">
+<h2>/user/create</h2>
+<p>Create, method: POST, auth required</p>
+<label>method
+<select name="_method"><option>POST</option></select>
+</label>
+<label>X-Auth <input name="_auth"></label>
+
+<label>username (string; required,min=3) <input name="username"></label>
+
+<label>account_name (string; paramname=account_name) <input name="account_name"></label>
+
+<label>class (string; enum=warrior|sorcerer|rouge,default=warrior) <input name="class" placeholder="warrior"></label>
+
+<label>level (int; min=1,max=50) <input name="level"></label>
+
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+
+<script>
+document.querySelectorAll("form.route").forEach(function (form) {
+  form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    var params = new URLSearchParams();
+    var method = "GET";
+    var headers = {};
+    new FormData(form).forEach(function (value, key) {
+      if (key === "_method") {
+        method = value;
+      } else if (key === "_auth") {
+        if (value !== "") headers["X-Auth"] = value;
+      } else if (value !== "") {
+        params.append(key, value);
+      }
+    });
+    var url = form.dataset.url;
+    var init = { method: method, headers: headers };
+    
+    if (method === "POST") {
+      headers["Content-Type"] = "application/x-www-form-urlencoded";
+      init.body = params.toString();
+    } else {
+      url += "?" + params.toString();
+    }
+    var out = form.querySelector(".result");
+    fetch(url, init).then(function (resp) {
+      return resp.text().then(function (text) {
+        out.textContent = resp.status + "\n" + text;
+      });
+    }).catch(function (err) {
+      out.textContent = String(err);
+    });
+  });
+});
+</script>
+</body>
+</html>
+`
+
+
+func (srv *OtherApi) CreateHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
+	
+	if r.Method != "POST" {
+		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
+		return
+	}
+	
+	
+	
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), nil, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
+		return
+	}
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	
+	
+	
+
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
+	body, _ := ioutil.ReadAll(r.Body)
+	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
+	info.Params = redactParams(queryParams, []string{ })
+	
+	// В строгом режиме неизвестные и повторяющиеся параметры - ошибка
+	err := checkStrictParams(r.URL.Query(), string(body), r.Method, []string{ "username", "account_name", "class", "level", })
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{http.StatusBadRequest, err}, nil)
+		return
+	}
+	
+	// Структура параметров для слоя стора
+	urlParams, err, statusCode := srv.parseCreateParams(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	
+
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "OtherApi.Create")
+	data, err := srv.Create(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
+		return
+	}
+	
+	
+	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
+}
+
+// parseCreateParams проверяет параметры по правилам apivalidator и собирает из них OtherCreateParams
+func (srv *OtherApi) parseCreateParams(queryParams map[string]string) (OtherCreateParams, error, int) {
+	// Создаем пустые переменные под параметры
+	
+	paramUsername, err, statusCode := validParamStr("Username", "required,min=3", queryParams)
+	if err != nil {
+		return OtherCreateParams{}, &apigenParamError{"username", err}, statusCode
+	}
+	
+	paramName, err, statusCode := validParamStr("Name", "paramname=account_name", queryParams)
+	if err != nil {
+		return OtherCreateParams{}, &apigenParamError{"account_name", err}, statusCode
+	}
+	
+	paramClass, err, statusCode := validParamStr("Class", "enum=warrior|sorcerer|rouge,default=warrior", queryParams)
+	if err != nil {
+		return OtherCreateParams{}, &apigenParamError{"class", err}, statusCode
+	}
+	
+	paramLevel, err, statusCode := validParamInt("Level", "min=1,max=50", queryParams)
+	if err != nil {
+		return OtherCreateParams{}, &apigenParamError{"level", err}, statusCode
+	}
+	
+	return OtherCreateParams{
+		
+		Username: paramUsername,
+		Name: paramName,
+		Class: paramClass,
+		Level: paramLevel,
+	}, nil, http.StatusOK
+}
+
+
+// ServeJSONRPC - JSON-RPC 2.0 поверх тех же методов: "OtherApi.Имя"
+func (srv *OtherApi) ServeJSONRPC(w http.ResponseWriter, r *http.Request) {
+	serveJSONRPC(w, r, srv.callJSONRPC)
+}
+
+func (srv *OtherApi) callJSONRPC(w http.ResponseWriter, r *http.Request, method string, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	switch method {
+	
+	case "OtherApi.Create":
+		// Та же цепочка middleware, что и у HTTP-обработчика эндпоинта
+		return callThroughMiddleware(w, r, func(next http.Handler) http.Handler { return srv.withServerHeader(next) },
+			func(w http.ResponseWriter, r *http.Request) (interface{}, *jsonRPCError) {
+				return srv.jsonRPCCreate(w, r, rawParams)
+			})
+	
+	}
+	return nil, &jsonRPCError{jsonRPCMethodNotFound, "method not found", nil}
+}
+
+func (srv *OtherApi) jsonRPCCreate(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	ctx := r.Context()
+	
+	
+	authCtx, err := authenticate(ctx, nil, r)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	
+	
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ "username", "account_name", "class", "level", }, true)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parseCreateParams(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	ctx, callSpan := StartSpan(ctx, "OtherApi.Create")
+	data, err := srv.Create(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	return data, nil
 }
 
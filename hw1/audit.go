@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditFilter - выборка из журнала аудита. Пустые поля не ограничивают
+type AuditFilter struct {
+	Principal string
+	From      time.Time // включительно
+	To        time.Time // не включительно
+	Limit     int       // 0 - без ограничения
+}
+
+func (f AuditFilter) match(entry *AuditEntry) bool {
+	return (f.Principal == "" || entry.Principal == f.Principal) &&
+		(f.From.IsZero() || !entry.Time.Before(f.From)) &&
+		(f.To.IsZero() || entry.Time.Before(f.To))
+}
+
+// AuditLog - журнал аудита, из которого можно читать. Записи отдаются в порядке записи
+type AuditLog interface {
+	AuditSink
+	Query(filter AuditFilter) ([]AuditEntry, error)
+}
+
+// Сколько последних записей по умолчанию держит InMemoryAuditLog
+const defaultInMemoryAuditMax = 10000
+
+// InMemoryAuditLog - журнал в памяти процесса, нулевое значение готово к работе.
+// Хранит только последние Max записей и теряется при рестарте - для долгой истории есть FileAuditLog
+type InMemoryAuditLog struct {
+	Max int // 0 - defaultInMemoryAuditMax
+
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+func (l *InMemoryAuditLog) WriteAudit(entry AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	max := l.Max
+	if max <= 0 {
+		max = defaultInMemoryAuditMax
+	}
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > max {
+		// Старые записи отрезаем спереди, их место освободится при следующем росте слайса
+		l.entries = l.entries[len(l.entries)-max:]
+	}
+	return nil
+}
+
+func (l *InMemoryAuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	result := []AuditEntry{}
+	for i := range l.entries {
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
+		}
+		if filter.match(&l.entries[i]) {
+			result = append(result, l.entries[i])
+		}
+	}
+	return result, nil
+}
+
+// FileAuditLog - журнал аудита в файле, по json-строке на запись. Файл только дописывается,
+// каждая запись сбрасывается на диск до ответа
+type FileAuditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64 // длина файла после последней целой записи
+}
+
+// OpenFileAuditLog открывает журнал по пути path, создавая его при необходимости.
+// Недописанная последняя строка (процесс упал посреди записи) отбрасывается
+func OpenFileAuditLog(path string) (*FileAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size := int64(bytes.LastIndexByte(data, '\n') + 1)
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileAuditLog{path: path, file: file, size: size}, nil
+}
+
+func (l *FileAuditLog) WriteAudit(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(line, '\n'))
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		// Откатываемся к последней целой записи: запись, на которую вернули ошибку, не должна
+		// прочитаться после рестарта, а недописанная строка - оказаться перед следующими
+		l.file.Truncate(l.size)
+		l.file.Seek(l.size, io.SeekStart)
+		return err
+	}
+	l.size += int64(len(line) + 1)
+	return nil
+}
+
+// Query читает журнал с начала. Читаются только целые записи, так что писать можно параллельно
+func (l *FileAuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	l.mu.Lock()
+	size := l.size
+	l.mu.Unlock()
+
+	result := []AuditEntry{}
+	reader := bufio.NewReader(io.NewSectionReader(l.file, 0, size))
+	for line := 1; filter.Limit == 0 || len(result) < filter.Limit; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := AuditEntry{}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", l.path, line, err)
+		}
+		if filter.match(&entry) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+func (l *FileAuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
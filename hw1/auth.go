@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// Сколько живёт токен сессии, продлевается через Refresh
+const sessionTTL = 24 * time.Hour
+
+// Session - то, что подписано в токене
+type Session struct {
+	ID        string `json:"sid"`
+	UserID    uint64 `json:"uid"`
+	ExpiresAt int64  `json:"exp"` // unix-время
+	IssuedAt  int64  `json:"iat"` // unix-время в наносекундах, сравнивается с User.PasswordChangedAt
+}
+
+// SessionTokens выдаёт и проверяет токены вида base64(сессия).base64(hmac-sha256 от неё).
+// Отозванные токены помнятся в памяти процесса до истечения их срока
+type SessionTokens struct {
+	secret  []byte
+	ttl     time.Duration
+	mu      sync.Mutex
+	revoked map[string]int64 // ID сессии -> когда она истекает
+}
+
+func NewSessionTokens(secret []byte, ttl time.Duration) *SessionTokens {
+	return &SessionTokens{
+		secret:  secret,
+		ttl:     ttl,
+		revoked: map[string]int64{},
+	}
+}
+
+// randomSecret - ключ подписи для процесса, если он не задан явно. Токены не переживут рестарт
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+func (st *SessionTokens) sign(payload string) string {
+	mac := hmac.New(sha256.New, st.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue выдаёт новый токен пользователю
+func (st *SessionTokens) Issue(userID uint64) (string, *Session) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	session := &Session{
+		ID:        hex.EncodeToString(id),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(st.ttl).Unix(),
+		IssuedAt:  time.Now().UnixNano(),
+	}
+	data, _ := json.Marshal(session)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + st.sign(payload), session
+}
+
+// Parse проверяет подпись, срок и отзыв токена
+func (st *SessionTokens) Parse(token string) (*Session, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(st.sign(payload))) {
+		return nil, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	session := &Session{}
+	if err := json.Unmarshal(data, session); err != nil || session.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= session.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	st.mu.Lock()
+	_, revoked := st.revoked[session.ID]
+	st.mu.Unlock()
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return session, nil
+}
+
+// Revoke отзывает сессию до истечения её срока
+func (st *SessionTokens) Revoke(session *Session) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now().Unix()
+	for id, expires := range st.revoked {
+		if now >= expires {
+			delete(st.revoked, id)
+		}
+	}
+	st.revoked[session.ID] = session.ExpiresAt
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"text/template"
+)
+
+// auditedMethods - вызовы каких эндпоинтов что-то меняют и попадают в журнал аудита
+var auditedMethods = []string{http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// Audited - пишется ли успешный вызов эндпоинта в AuditSink
+func (h *HttpHandlerData) Audited() bool {
+	return contains(auditedMethods, h.Params.Method)
+}
+
+// auditRuntime - журнал аудита: кто, когда и с какими параметрами успешно вызвал меняющий эндпоинт.
+// Куда писать, задаёт поле AuditSink в API-структуре, без него аудита нет
+var (
+	auditRuntime = template.Must(template.New("auditRuntime").Parse(`
+// AuditEntry - одна запись журнала аудита
+type AuditEntry struct {
+	Time      time.Time         ` + "`json:\"time\"`" + `
+	RequestID string            ` + "`json:\"request_id\"`" + `
+	Principal string            ` + "`json:\"principal\"`" + ` // пусто, если Authenticator не положил его в контекст
+	Endpoint  string            ` + "`json:\"endpoint\"`" + `
+	Method    string            ` + "`json:\"method\"`" + `
+	Params    map[string]string ` + "`json:\"params\"`" + ` // sensitive-параметры скрыты
+	ResultID  string            ` + "`json:\"result_id,omitempty\"`" + `
+}
+
+// AuditSink принимает записи аудита. Запись только дописывается, ошибка не отменяет уже сделанный вызов
+type AuditSink interface {
+	WriteAudit(entry AuditEntry) error
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal - Authenticator кладёт так имя вызывающего для журнала аудита
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// auditResultID - поле "id" ответа, если оно есть
+func auditResultID(data interface{}) string {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	result := struct {
+		ID json.RawMessage ` + "`json:\"id\"`" + `
+	}{}
+	if json.Unmarshal(raw, &result) != nil || result.ID == nil {
+		return ""
+	}
+	var id string
+	if json.Unmarshal(result.ID, &id) == nil {
+		return id
+	}
+	return string(result.ID)
+}
+
+// writeAudit пишет запись об успешном вызове. Сбой журнала только логируется - ответ уже не изменить
+func writeAudit(ctx context.Context, sink AuditSink, logger *slog.Logger, endpoint string, method string, params map[string]string, data interface{}) {
+	if sink == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:      time.Now().UTC(),
+		RequestID: RequestIDFromContext(ctx),
+		Principal: PrincipalFromContext(ctx),
+		Endpoint:  endpoint,
+		Method:    method,
+		Params:    params,
+		ResultID:  auditResultID(data),
+	}
+	if err := sink.WriteAudit(entry); err != nil {
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.LogAttrs(ctx, slog.LevelError, "apigen audit failed",
+			slog.String("request_id", entry.RequestID),
+			slog.String("endpoint", endpoint),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+`))
+)
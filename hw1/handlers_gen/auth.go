@@ -0,0 +1,72 @@
+package main
+
+import "text/template"
+
+// Токен, с которым пускают API-структуры без поля Authenticator - как было в исходном задании
+const legacyAuthToken = "100500"
+
+// authRuntime - проверка X-Auth для эндпоинтов с "auth": true.
+// Проверку подменяет поле Authenticator в API-структуре
+var (
+	authRuntime = template.Must(template.New("authRuntime").Parse(`
+// Authenticator проверяет токен из X-Auth. В возвращённый контекст он может положить
+// того, кто делает запрос, - контекст получит метод API
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (context.Context, error)
+}
+
+// StaticTokenAuthenticator пускает с одним заранее известным токеном
+type StaticTokenAuthenticator string
+
+func (a StaticTokenAuthenticator) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a)) != 1 {
+		return nil, errUnauthorized
+	}
+	return ctx, nil
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+var defaultAuthenticator Authenticator = StaticTokenAuthenticator({{printf "%q" .}})
+
+func authenticatorOrDefault(a Authenticator) Authenticator {
+	if a == nil {
+		return defaultAuthenticator
+	}
+	return a
+}
+
+// authenticate - общая проверка для HTTP и JSON-RPC. Ошибка - текст для ответа 403
+func authenticate(ctx context.Context, a Authenticator, r *http.Request) (context.Context, error) {
+	values := r.Header["X-Auth"]
+	if len(values) != 1 || values[0] == "" {
+		return nil, errUnauthorized
+	}
+	return authenticatorOrDefault(a).Authenticate(ctx, values[0])
+}
+
+type roleKey struct{}
+
+// ContextWithRole - Authenticator кладёт так роль вызывающего для проверки "role" и "roles"
+func ContextWithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+func RoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleKey{}).(string)
+	return role, ok
+}
+
+// checkRole пускает, только если роль вызывающего в списке allowed
+func checkRole(ctx context.Context, allowed []string) error {
+	role, ok := RoleFromContext(ctx)
+	if !ok {
+		return errors.New("forbidden: caller has no role, required one of: " + strings.Join(allowed, ", "))
+	}
+	if !contains(allowed, role) {
+		return errors.New("forbidden: role " + role + " is not allowed, required one of: " + strings.Join(allowed, ", "))
+	}
+	return nil
+}
+`))
+)
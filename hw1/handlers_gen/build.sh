@@ -1,8 +1,18 @@
 rm ../api_handlers.go
 rm codegen
-go build codegen.go
-./codegen ../api.go ../api_handlers.go
+echo '\n=== Testing codegen... ===\n'
+go test .
+go build -o codegen *.go
+./codegen -docs -metrics -tests .. -fuzz .. -jsonrpc /rpc -permissions ../PERMISSIONS.md -client ../client ../api.go ../api_handlers.go
+
+# OpenAPI и TypeScript в репозиторий не кладём - только проверяем, что они пишутся,
+# а каркас, собранный обратно из OpenAPI, снова разбирается генератором
+out=$(mktemp -d)
+./codegen -openapi $out -openapi-format yaml -ts $out/api.ts ../api.go
+./codegen -from-openapi $out/MyApi.openapi.yaml $out/api.go
+./codegen $out/api.go $out/api_handlers.go
+rm -r $out
 
 cd ..
 echo '\n=== Testing... ===\n'
-go test -v
\ No newline at end of file
+go test -v
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/template"
+)
+
+// CacheParams - "cache" в apigen:api для GET-эндпоинтов: {"max_age": 60, "ttl": 30}
+type CacheParams struct {
+	MaxAge  int  `json:"max_age"` // Cache-Control: max-age в секундах, 0 - только ревалидация по ETag
+	Private bool `json:"private"` // ответ только для браузера, не для общих прокси
+	TTL     int  `json:"ttl"`     // сколько секунд держать ответ в кэше процесса, 0 - не держать
+}
+
+// CacheControl - значение заголовка Cache-Control
+func (c *CacheParams) CacheControl() string {
+	scope := "public"
+	if c.Private {
+		scope = "private"
+	}
+	if c.MaxAge == 0 {
+		return scope + ", no-cache"
+	}
+	return scope + ", max-age=" + strconv.Itoa(c.MaxAge)
+}
+
+// checkCache проверяет cache и invalidates у всех обработчиков одной API-структуры
+func checkCache(apiName string, handlers []*HttpHandlerData) error {
+	cached := map[string]bool{}
+	for _, handler := range handlers {
+		c := handler.Params.Cache
+		if c == nil {
+			continue
+		}
+		if handler.Params.Method != "" && handler.Params.Method != http.MethodGet {
+			return fmt.Errorf("%s.%s: cache is allowed only for GET endpoints", apiName, handler.Name)
+		}
+		if c.MaxAge < 0 || c.TTL < 0 {
+			return fmt.Errorf("%s.%s: cache: max_age and ttl must be >= 0", apiName, handler.Name)
+		}
+		// Ответ с авторизацией у каждого вызывающего свой: общий кэш процесса отдал бы его чужому,
+		// а public разрешил бы хранить его общим прокси
+		if handler.Params.Auth && c.TTL > 0 {
+			return fmt.Errorf("%s.%s: cache ttl is not allowed with auth", apiName, handler.Name)
+		}
+		if handler.Params.Auth && !c.Private {
+			return fmt.Errorf("%s.%s: cache with auth must be private", apiName, handler.Name)
+		}
+		if c.TTL > 0 {
+			cached[handler.Params.Url] = true
+		}
+	}
+
+	for _, handler := range handlers {
+		for _, url := range handler.Params.Invalidates {
+			if !cached[url] {
+				return fmt.Errorf("%s.%s: invalidates %s, but it has no cache with ttl", apiName, handler.Name, url)
+			}
+		}
+	}
+	return nil
+}
+
+// cacheRuntime - ETag, условный GET и кэш ответов в памяти процесса.
+// Кэш подменяется полем ResponseCache в API-структуре
+var (
+	cacheRuntime = template.Must(template.New("cacheRuntime").Parse(`
+// ResponseCache хранит закодированные ответы GET-эндпоинтов
+type ResponseCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, body []byte, ttl time.Duration)
+	// DeletePrefix выкидывает ответы, ключ которых начинается с prefix - например, все ответы эндпоинта
+	DeletePrefix(prefix string)
+}
+
+type cachedResponse struct {
+	body    []byte
+	expires time.Time
+}
+
+// InMemoryResponseCache - кэш в памяти процесса, нулевое значение готово к работе
+type InMemoryResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+func (c *InMemoryResponseCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (c *InMemoryResponseCache) Set(key string, body []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = map[string]cachedResponse{}
+	}
+	// Просроченное чистим при записи, чтобы кэш не рос бесконечно
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedResponse{body, now.Add(ttl)}
+}
+
+func (c *InMemoryResponseCache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+var defaultResponseCache = &InMemoryResponseCache{}
+
+func responseCacheOrDefault(cache ResponseCache) ResponseCache {
+	if cache == nil {
+		return defaultResponseCache
+	}
+	return cache
+}
+
+// responseCacheKey - эндпоинт плюс уже проверенные параметры: порядок полей в json фиксирован
+func responseCacheKey(endpoint string, params interface{}) string {
+	data, _ := json.Marshal(params)
+	return endpoint + "?" + string(data)
+}
+
+// encodeResponse кодирует успешный ответ так же, как response
+func encodeResponse(res interface{}) []byte {
+	bytes, _ := json.Marshal(&HTTPResponse{Response: res})
+	return bytes
+}
+
+// writeCacheable отдаёт закодированный ответ с ETag и Cache-Control, на совпавший If-None-Match - 304
+func writeCacheable(w http.ResponseWriter, r *http.Request, body []byte, cacheControl string) {
+	sum := sha256.Sum256(body)
+	etag := "\"" + hex.EncodeToString(sum[:16]) + "\""
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+`))
+)
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCache(t *testing.T) {
+	cases := []struct {
+		Params GenParams
+		Error  string
+	}{
+		{GenParams{Url: "/a", Cache: &CacheParams{MaxAge: 10, TTL: 30}}, ""},
+		{GenParams{Url: "/a", Auth: true, Cache: &CacheParams{MaxAge: 10, Private: true}}, ""},
+		{GenParams{Url: "/a", Auth: true, Cache: &CacheParams{MaxAge: 10, Private: true, TTL: 30}}, "cache ttl is not allowed with auth"},
+		{GenParams{Url: "/a", Auth: true, Cache: &CacheParams{MaxAge: 10}}, "cache with auth must be private"},
+		{GenParams{Url: "/a", Method: "POST", Cache: &CacheParams{}}, "cache is allowed only for GET endpoints"},
+	}
+	for i, item := range cases {
+		err := checkCache("Api", []*HttpHandlerData{{Name: "Get", Params: item.Params}})
+		if item.Error == "" && err != nil {
+			t.Errorf("[%d] unexpected error: %v", i, err)
+		}
+		if item.Error != "" && (err == nil || !strings.Contains(err.Error(), item.Error)) {
+			t.Errorf("[%d] expected error %q, got %v", i, item.Error, err)
+		}
+	}
+}
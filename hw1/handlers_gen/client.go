@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+)
+
+var (
+	clientTmp = template.Must(template.New("clientTmp").Parse(`// Code generated by codegen; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	{{- if .UsesStrconv}}
+	"strconv"
+	{{- end}}
+	"strings"
+)
+
+// ApiError - ошибка, которую вернул сервер: статус ответа и текст из поля error
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+{{range .Types}}
+{{.}}
+{{end}}
+
+type httpResponse struct {
+	Error    string          ` + "`json:\"error\"`" + `
+	Response json.RawMessage ` + "`json:\"response\"`" + `
+}
+
+func doRequest(ctx context.Context, httpClient *http.Client, method string, endpoint string, params url.Values, authToken string, out interface{}) error {
+	// Как и сервер (queryParamsToMap), тело с параметрами читается только у POST
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(params.Encode())
+	} else {
+		endpoint += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if authToken != "" {
+		req.Header.Set("X-Auth", authToken)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	envelope := &httpResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(envelope); err != nil {
+		return ApiError{resp.StatusCode, fmt.Errorf("cant unpack response: %v", err)}
+	}
+	if resp.StatusCode != http.StatusOK || envelope.Error != "" {
+		return ApiError{resp.StatusCode, errors.New(envelope.Error)}
+	}
+	if len(envelope.Response) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(envelope.Response, out)
+}
+{{range $api := .Apis}}
+// {{$api.Name}}Client ходит в {{$api.Name}} по http
+type {{$api.Name}}Client struct {
+	BaseURL    string
+	AuthToken  string // значение X-Auth для методов с авторизацией
+	HTTPClient *http.Client
+}
+
+func New{{$api.Name}}Client(baseURL string, authToken string) *{{$api.Name}}Client {
+	return &{{$api.Name}}Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		AuthToken:  authToken,
+		HTTPClient: http.DefaultClient,
+	}
+}
+{{range $handler := $api.Handlers}}
+func (c *{{$api.Name}}Client) {{$handler.Name}}(ctx context.Context, in {{$handler.ParamsStructName}}) (*{{$handler.ResultTypeName}}, error) {
+	params := url.Values{}
+	{{- range $field := $handler.ParamFields}}{{$rules := $field.Rules}}
+	{{- if eq $field.Type "int"}}
+	{{- if $rules.HasDefault}}
+	if in.{{$field.Name}} == 0 {
+		params.Set("{{$rules.ParamName}}", "{{$rules.Default}}")
+	} else {
+		params.Set("{{$rules.ParamName}}", strconv.Itoa(in.{{$field.Name}}))
+	}
+	{{- else}}
+	params.Set("{{$rules.ParamName}}", strconv.Itoa(in.{{$field.Name}}))
+	{{- end}}
+	{{- else}}
+	if in.{{$field.Name}} != "" {
+		params.Set("{{$rules.ParamName}}", in.{{$field.Name}})
+	}{{if $rules.HasDefault}} else {
+		params.Set("{{$rules.ParamName}}", "{{$rules.Default}}")
+	}{{end}}
+	{{- end}}
+	{{- end}}
+
+	out := &{{$handler.ResultTypeName}}{}
+	err := doRequest(ctx, c.HTTPClient, "{{if $handler.Params.Method}}{{$handler.Params.Method}}{{else}}GET{{end}}", c.BaseURL+"{{$handler.Params.Url}}", params, {{if $handler.Params.Auth}}c.AuthToken{{else}}""{{end}}, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+{{end}}
+{{end}}
+`))
+)
+
+type clientApi struct {
+	Name     string
+	Handlers []*HttpHandlerData
+}
+
+// writeClient пишет пакет с клиентом: копии структур параметров и ответов плюс по клиенту на API-структуру
+func writeClient(dir string, pkg string, src *ApiSource) error {
+	if pkg == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		pkg = filepath.Base(abs)
+	}
+
+	data := &struct {
+		Package     string
+		UsesStrconv bool
+		Types       []string
+		Apis        []clientApi
+	}{Package: pkg}
+
+	typeNames := map[string]bool{}
+	for _, apiName := range src.Handlers.StructNames() {
+		data.Apis = append(data.Apis, clientApi{apiName, src.Handlers[apiName]})
+		for _, handler := range src.Handlers[apiName] {
+			collectTypeNames(handler.ParamsStructName, src, typeNames)
+			collectTypeNames(handler.ResultTypeName, src, typeNames)
+			for _, field := range handler.ParamFields {
+				if field.Type == "int" {
+					data.UsesStrconv = true
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(typeNames))
+	for name := range typeNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		decl := &ast.GenDecl{
+			Tok: token.TYPE,
+			Specs: []ast.Spec{&ast.TypeSpec{
+				Name: ast.NewIdent(name),
+				Type: src.Structs[name],
+			}},
+		}
+		buf := &bytes.Buffer{}
+		if err := printer.Fprint(buf, src.Fset, decl); err != nil {
+			return err
+		}
+		data.Types = append(data.Types, buf.String())
+	}
+
+	buf := &bytes.Buffer{}
+	if err := clientTmp.Execute(buf, data); err != nil {
+		return err
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "client.go"), code, 0644)
+}
+
+// collectTypeNames собирает структуру и все структуры из разбираемого файла, на которые она ссылается
+func collectTypeNames(name string, src *ApiSource, names map[string]bool) {
+	structType, ok := src.Structs[name]
+	if !ok || names[name] {
+		return
+	}
+	names[name] = true
+
+	ast.Inspect(structType, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			collectTypeNames(ident.Name, src, names)
+		}
+		return true
+	})
+}
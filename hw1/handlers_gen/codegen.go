@@ -5,26 +5,61 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"log"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"unicode"
 )
 
 type GenParams struct {
 	Url    string `json:"url"`
 	Auth   bool   `json:"auth"`
 	Method string `json:"method"`
+	Strict bool   `json:"strict"`
+	// Имена методов API-структуры вида func(http.Handler) http.Handler,
+	// первый в списке - внешний. Если не указано - берётся из apigen:struct
+	Middleware []string `json:"middleware"`
+	// Ограничение частоты запросов, nil - без ограничения
+	RateLimit *RateLimitParams `json:"ratelimit"`
+	// CORS эндпоинта поверх настроек из apigen:struct
+	CORS *CORSParams `json:"cors"`
+	// ETag и Cache-Control для GET, с ttl - ещё и кэш ответов в процессе
+	Cache *CacheParams `json:"cache"`
+	// Урлы кэшируемых эндпоинтов, кэш которых сбрасывается после успешного вызова
+	Invalidates []string `json:"invalidates"`
+	// Повторять первый ответ на запросы с тем же заголовком Idempotency-Key
+	Idempotent bool `json:"idempotent"`
+	// Минимальная роль вызывающего или явный перечень ролей, уровни - в apigen:struct
+	Role  string   `json:"role"`
+	Roles []string `json:"roles"`
+}
+
+// StructGenParams - настройки всей API-структуры из комментария // apigen:struct {...}
+type StructGenParams struct {
+	Middleware []string `json:"middleware"`
+	// Добавлять request_id в конверт с ошибкой
+	RequestIDInErrors bool `json:"request_id_in_errors"`
+	// CORS для всех эндпоинтов структуры
+	CORS *CORSParams `json:"cors"`
+	// Роли и их уровни для "role" и "roles" в apigen:api: {"user": 0, "admin": 20}
+	Roles map[string]int `json:"roles"`
 }
 
 var (
 	respAction = template.Must(template.New("respAction").Parse(`type HTTPResponse struct {
-	Error    string      ` + "\x60" + `json:"error"` + "\x60" + `
-	Response interface{} ` + "\x60" + `json:"response,omitempty"` + "\x60" + `
+	Error     string      ` + "\x60" + `json:"error"` + "\x60" + `
+	Response  interface{} ` + "\x60" + `json:"response,omitempty"` + "\x60" + `
+	RequestID string      ` + "\x60" + `json:"request_id,omitempty"` + "\x60" + `
 }
 
 func response(w http.ResponseWriter, apiErr *ApiError, res interface{}) {
@@ -33,10 +68,23 @@ func response(w http.ResponseWriter, apiErr *ApiError, res interface{}) {
 		Error:    apiErr.Error(),
 		Response: res,
 	}
+	// request_id в конверте с ошибкой включается через apigen:struct {"request_id_in_errors": true}
+	if rec := apigenWriterOf(w); rec != nil && apiErr.HTTPStatus != http.StatusOK {
+		resp.RequestID = rec.requestID
+	}
 	bytes, _ := json.Marshal(resp)
 	w.Write(bytes)
 }
 
+// apiErrorStatus - статус из ApiError, для всех остальных ошибок 500
+func apiErrorStatus(err error) int {
+	var apiErr ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus
+	}
+	return http.StatusInternalServerError
+}
+
 func getParamFromPost(postParams string, key string) string {
 	for _, p := range strings.Split(postParams, ",") {
 		kv := strings.Split(p, "=")
@@ -57,10 +105,48 @@ func getParamFromPost(postParams string, key string) string {
 var (
 	serveHttpTmp = template.Must(template.New("serveHttpTmp").Parse(`{{ $apiStructName := .ApiStructName }}
 func (srv *{{$apiStructName}}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, requestID := withRequestID(w, r)
+	ctx, span := startTrace(r, {{if .HasExporter}}srv.SpanExporter{{else}}nil{{end}}, "apigen.request")
+	defer span.Finish()
+	span.SetAttr("request_id", requestID)
+	span.SetAttr("http.method", r.Method)
+	span.SetAttr("http.path", r.URL.Path)
+	w.Header().Set("traceparent", span.TraceParent())
+	r = r.WithContext(ctx)
+
+	{{if .CORS}}
+	if handleCORS(w, r, {{.CORS}}[r.URL.Path]) {
+		return
+	}
+	{{end}}
+	_, routeSpan := StartSpan(ctx, "apigen.route")
+	defer routeSpan.Finish()
 	switch r.URL.Path {
 	{{range $handler := .Handlers}}
 	case "{{.Params.Url}}":
-		srv.{{$handler.Name}}HTTPHandler(w, r)
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "{{.Params.Url}}", {{$handler.HandlerChain}})
+		return
+	{{end}}
+	{{if .JSONRPC}}
+	case "{{.JSONRPC}}":
+		routeSpan.Finish()
+		srv.apigenServe(w, r, "{{.JSONRPC}}", http.HandlerFunc(srv.ServeJSONRPC))
+		return
+	{{end}}
+	{{if .Docs}}
+	case "/_routes":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte({{$apiStructName}}RoutesJSON))
+		return
+	case "/_docs":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte({{$apiStructName}}DocsHTML))
+		return
+	{{end}}
+	{{if .Metrics}}
+	case "/_metrics":
+		{{.Metrics}}.ServeHTTP(w, r)
 		return
 	{{end}}
 	default:
@@ -68,63 +154,296 @@ func (srv *{{$apiStructName}}) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 }
+
+// apigenServe - обвязка вокруг каждого обработчика: одна строка лога на запрос, паника - ответ 500
+func (srv *{{$apiStructName}}) apigenServe(w http.ResponseWriter, r *http.Request, endpoint string, h http.Handler) {
+	start := time.Now()
+	info := &apigenRequestInfo{}
+	rec := &apigenResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	{{- if .RequestIDInErrors}}
+	rec.requestID = RequestIDFromContext(r.Context())
+	{{- end}}
+	{{- if .Metrics}}
+	{{.Metrics}}.begin(endpoint)
+	{{- end}}
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				info.Err = handleApigenPanic(rec, r, endpoint, recovered, {{if .HasLogger}}srv.Logger{{else}}nil{{end}}, {{if .HasPanicHook}}srv.PanicHook{{else}}nil{{end}})
+			}
+		}()
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), apigenRequestInfoKey{}, info)))
+	}()
+	latency := time.Since(start)
+	{{- if .Metrics}}
+	{{.Metrics}}.end(endpoint, r.Method, rec.status, latency, info)
+	{{- end}}
+	if span := SpanFromContext(r.Context()); span != nil {
+		span.SetAttr("apigen.endpoint", endpoint)
+		span.SetAttr("http.status_code", strconv.Itoa(rec.status))
+		span.SetError(info.Err)
+	}
+	logApigenRequest({{if .HasLogger}}srv.Logger{{else}}nil{{end}}, r, endpoint, rec.status, latency, info)
+}
+{{if .Metrics}}
+// {{.Metrics}} - счётчики эндпоинтов {{$apiStructName}}, отдаются на /_metrics
+var {{.Metrics}} = newApigenMetrics("{{$apiStructName}}", []string{ {{range .Handlers}}"{{.Params.Url}}", {{end}}{{if .JSONRPC}}"{{.JSONRPC}}"{{end}}})
+{{end}}
+{{if .CORS}}
+// {{.CORS}} - CORS-политики по путям, собраны из apigen:struct и apigen:api
+var {{.CORS}} = map[string]*corsPolicy{
+	{{- range $path, $policy := .CORSPolicies}}
+	"{{$path}}": {
+		Origins:     {{printf "%#v" $policy.Origins}},
+		Methods:     {{printf "%#v" $policy.Methods}},
+		Headers:     {{printf "%#v" $policy.Headers}},
+		Credentials: {{$policy.Credentials}},
+		MaxAge:      {{$policy.MaxAge}},
+	},
+	{{- end}}
+}
+{{end}}
+{{if .Docs}}
+// Таблица маршрутов и страница документации собраны на этапе генерации
+const {{$apiStructName}}RoutesJSON = {{.Docs.RoutesJSON}}
+
+const {{$apiStructName}}DocsHTML = {{.Docs.DocsHTML}}
+{{end}}
 {{range $handler := .Handlers}}
 func (srv *{{$apiStructName}}) {{$handler.Name}}HTTPHandler(w http.ResponseWriter, r *http.Request) {
+	info := apigenRequestInfoFrom(r.Context())
 	{{if $handler.Params.Method }}
 	if r.Method != "{{$handler.Params.Method}}" {
 		response(w, &ApiError{http.StatusNotAcceptable, errors.New("bad method")}, nil)
 		return
 	}
 	{{end}}
-	{{if eq $handler.Params.Method "POST" }}
-	headerValue, ok := r.Header["X-Auth"]
-	if !ok {
-		response(w, &ApiError{http.StatusForbidden, errors.New("unauthorized")}, nil)
+	{{with $handler.Params.RateLimit}}{{if ne .Key "auth"}}
+	if rateLimited(w, {{if $.HasRateLimiter}}srv.RateLimiter{{else}}nil{{end}}, "{{$handler.Params.Url}}", rateLimitKey(r, "{{.Key}}"), {{.RPS}}, {{.Burst}}) {
+		return
+	}
+	{{end}}{{end}}
+	{{if $handler.Params.Auth}}
+	_, authSpan := StartSpan(r.Context(), "apigen.auth")
+	authCtx, authErr := authenticate(r.Context(), {{if $.HasAuthenticator}}srv.Authenticator{{else}}nil{{end}}, r)
+	authSpan.SetError(authErr)
+	authSpan.Finish()
+	if authErr != nil {
+		{{- with $handler.Params.RateLimit}}{{if eq .Key "auth"}}
+		// С неверным токеном своего ведра нет - считаем по ip
+		if rateLimited(w, {{if $.HasRateLimiter}}srv.RateLimiter{{else}}nil{{end}}, "{{$handler.Params.Url}}", rateLimitKey(r, "ip"), {{.RPS}}, {{.Burst}}) {
+			return
+		}
+		{{- end}}{{end}}
+		response(w, &ApiError{http.StatusForbidden, authErr}, nil)
 		return
 	}
-	if len(headerValue) != 1 && headerValue[0] != "100500" {
-		response(w, &ApiError{http.StatusForbidden, errors.New("unauthorized")}, nil)
+	// Дальше в контексте то, что положил Authenticator - например, пользователь
+	r = r.WithContext(authCtx)
+	{{with $handler.Params.RateLimit}}{{if eq .Key "auth"}}
+	if rateLimited(w, {{if $.HasRateLimiter}}srv.RateLimiter{{else}}nil{{end}}, "{{$handler.Params.Url}}", rateLimitKey(r, "auth"), {{.RPS}}, {{.Burst}}) {
 		return
 	}
+	{{end}}{{end}}
+	{{if $handler.AllowedRoles}}
+	if err := checkRole(r.Context(), []string{ {{range $handler.AllowedRoles}}"{{.}}", {{end}}}); err != nil {
+		response(w, &ApiError{http.StatusForbidden, err}, nil)
+		return
+	}
+	{{end}}
 	{{end}}
 
+	_, validateSpan := StartSpan(r.Context(), "apigen.validate")
 	body, _ := ioutil.ReadAll(r.Body)
 	queryParams := queryParamsToMap(r.URL.Query(), string(body), r.Method)
-	
-	// Создаем пустые переменные под параметры
-	{{range $urlParam := .ParamFields}}
-	param{{.Name}}, err, statusCode := validParam{{if eq .Type "string"}}Str{{else}}Int{{end}}("{{.Name}}", {{.Tags}}, queryParams)
+	info.Params = redactParams(queryParams, []string{ {{range .SensitiveParams}}"{{.}}", {{end}}})
+	{{if $handler.Params.Strict }}
+	// В строгом режиме неизвестные и повторяющиеся параметры - ошибка
+	err := checkStrictParams(r.URL.Query(), string(body), r.Method, []string{ {{range .ParamFields}}"{{.ParamName}}", {{end}}})
 	if err != nil {
-		response(w, &ApiError{statusCode, err}, nil)
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{http.StatusBadRequest, err}, nil)
 		return
 	}
 	{{end}}
 	// Структура параметров для слоя стора
-	urlParams := {{$handler.ParamsStructName}}{
-		{{range $urlParam := .ParamFields}}
-		{{.Name}}: param{{.Name}},{{end}}
+	urlParams, err, statusCode := srv.parse{{$handler.Name}}Params(queryParams)
+	if err != nil {
+		info.ValidationErr = err
+		validateSpan.SetError(err)
+		validateSpan.Finish()
+		response(w, &ApiError{statusCode, err}, nil)
+		return
+	}
+	validateSpan.Finish()
+	{{if $handler.Params.Idempotent}}
+	if key := r.Header.Get("Idempotency-Key"); key != "" && r.Method == http.MethodPost {
+		// Ключи разных клиентов не пересекаются
+		store := idempotencyStoreOrDefault({{if $.HasIdempotencyStore}}srv.IdempotencyStore{{else}}nil{{end}})
+		storeKey := "{{$handler.Params.Url}}|" + idempotencyCaller(r) + "|" + key
+		fingerprint := idempotencyFingerprint(r, body)
+		if record, reserved := store.Reserve(storeKey, fingerprint, idempotencyTTL); !reserved {
+			replayIdempotent(w, record, fingerprint)
+			return
+		}
+		idempotent := &idempotencyRecorder{ResponseWriter: w}
+		defer idempotent.finish(store, storeKey)
+		w = idempotent
 	}
+	{{end}}
+	{{- with $handler.Params.Cache}}{{if gt .TTL 0}}
+	cacheKey := responseCacheKey("{{$handler.Params.Url}}", urlParams)
+	if r.Method == http.MethodGet {
+		if body, ok := responseCacheOrDefault({{if $.HasResponseCache}}srv.ResponseCache{{else}}nil{{end}}).Get(cacheKey); ok {
+			w.Header().Set("X-Cache", "HIT")
+			writeCacheable(w, r, body, "{{.CacheControl}}")
+			return
+		}
+	}
+	{{end}}{{end}}
 
-	ctx := context.Background()
+	// Спан вызова лежит в ctx - метод может начинать от него свои спаны
+	ctx, callSpan := StartSpan(r.Context(), "{{$apiStructName}}.{{$handler.Name}}")
 	data, err := srv.{{$handler.Name}}(ctx, urlParams)
+	callSpan.SetError(err)
+	callSpan.Finish()
 	if err != nil {
-		var statusCode int
-		if err.Error() == "user not exist" {
-			statusCode = http.StatusNotFound
-		} else {
-			if strings.Contains(err.Error(), "exist") && !strings.Contains(err.Error(), "not")  {
-				statusCode = http.StatusConflict
-			} else {
-				statusCode = http.StatusInternalServerError
-			}
-		}
-		response(w, &ApiError{statusCode, err}, nil)
+		info.Err = err
+		response(w, &ApiError{apiErrorStatus(err), err}, nil)
 		return
 	}
-
+	{{range $handler.Params.Invalidates}}
+	responseCacheOrDefault({{if $.HasResponseCache}}srv.ResponseCache{{else}}nil{{end}}).DeletePrefix("{{.}}?")
+	{{- end}}
+	{{- if and $.HasAuditSink $handler.Audited}}
+	writeAudit(r.Context(), srv.AuditSink, {{if $.HasLogger}}srv.Logger{{else}}nil{{end}}, "{{$handler.Params.Url}}", "{{$handler.Params.Method}}", info.Params, data)
+	{{- end}}
+	{{with $handler.Params.Cache}}
+	if r.Method == http.MethodGet {
+		body := encodeResponse(data)
+		{{- if gt .TTL 0}}
+		responseCacheOrDefault({{if $.HasResponseCache}}srv.ResponseCache{{else}}nil{{end}}).Set(cacheKey, body, {{.TTL}}*time.Second)
+		w.Header().Set("X-Cache", "MISS")
+		{{- end}}
+		writeCacheable(w, r, body, "{{.CacheControl}}")
+		return
+	}
+	{{end}}
 	response(w, &ApiError{http.StatusOK, errors.New("")}, data)
 }
+
+// parse{{$handler.Name}}Params проверяет параметры по правилам apivalidator и собирает из них {{$handler.ParamsStructName}}
+func (srv *{{$apiStructName}}) parse{{$handler.Name}}Params(queryParams map[string]string) ({{$handler.ParamsStructName}}, error, int) {
+	// Создаем пустые переменные под параметры
+	{{range $urlParam := .ParamFields}}
+	param{{.Name}}, err, statusCode := validParam{{if eq .Type "string"}}Str{{else}}Int{{end}}("{{.Name}}", {{.Tags}}, queryParams)
+	if err != nil {
+		return {{$handler.ParamsStructName}}{}, &apigenParamError{"{{.ParamName}}", err}, statusCode
+	}
+	{{end}}
+	return {{$handler.ParamsStructName}}{
+		{{range $urlParam := .ParamFields}}
+		{{.Name}}: param{{.Name}},{{end}}
+	}, nil, http.StatusOK
+}
 {{end}}
+{{if .JSONRPC}}
+// ServeJSONRPC - JSON-RPC 2.0 поверх тех же методов: "{{$apiStructName}}.Имя"
+func (srv *{{$apiStructName}}) ServeJSONRPC(w http.ResponseWriter, r *http.Request) {
+	serveJSONRPC(w, r, srv.callJSONRPC)
+}
+
+func (srv *{{$apiStructName}}) callJSONRPC(w http.ResponseWriter, r *http.Request, method string, rawParams json.RawMessage) (interface{}, *jsonRPCError) {
+	switch method {
+	{{range $handler := .Handlers}}
+	case "{{$apiStructName}}.{{$handler.Name}}":
+		{{- if $handler.Middleware}}
+		// Та же цепочка middleware, что и у HTTP-обработчика эндпоинта
+		return callThroughMiddleware(w, r, func(next http.Handler) http.Handler { return {{$handler.WrapMiddleware "next"}} },
+			func(w http.ResponseWriter, r *http.Request) (interface{}, *jsonRPCError) {
+				return srv.jsonRPC{{$handler.Name}}(w, r, rawParams)
+			})
+		{{- else}}
+		return srv.jsonRPC{{$handler.Name}}(w, r, rawParams)
+		{{- end}}
+	{{end}}
+	}
+	return nil, &jsonRPCError{jsonRPCMethodNotFound, "method not found", nil}
+}
+{{range $handler := .Handlers}}
+func (srv *{{$apiStructName}}) jsonRPC{{$handler.Name}}(w http.ResponseWriter, r *http.Request, rawParams json.RawMessage) {{if $handler.Params.Idempotent}}(result interface{}, rpcErr *jsonRPCError){{else}}(interface{}, *jsonRPCError){{end}} {
+	ctx := r.Context()
+	{{with $handler.Params.RateLimit}}{{if ne .Key "auth"}}
+	if err, retryAfter := checkRateLimit({{if $.HasRateLimiter}}srv.RateLimiter{{else}}nil{{end}}, "{{$handler.Params.Url}}", rateLimitKey(r, "{{.Key}}"), {{.RPS}}, {{.Burst}}); err != nil {
+		return nil, &jsonRPCError{jsonRPCRateLimited, err.Error(), map[string]int{"status": http.StatusTooManyRequests, "retry_after": retryAfter}}
+	}
+	{{end}}{{end}}
+	{{if $handler.Params.Auth}}
+	authCtx, err := authenticate(ctx, {{if $.HasAuthenticator}}srv.Authenticator{{else}}nil{{end}}, r)
+	{{- with $handler.Params.RateLimit}}{{if eq .Key "auth"}}
+	// С неверным токеном своего ведра нет - считаем по ip
+	rateKey := rateLimitKey(r, "ip")
+	if err == nil {
+		rateKey = rateLimitKey(r.WithContext(authCtx), "auth")
+	}
+	if err, retryAfter := checkRateLimit({{if $.HasRateLimiter}}srv.RateLimiter{{else}}nil{{end}}, "{{$handler.Params.Url}}", rateKey, {{.RPS}}, {{.Burst}}); err != nil {
+		return nil, &jsonRPCError{jsonRPCRateLimited, err.Error(), map[string]int{"status": http.StatusTooManyRequests, "retry_after": retryAfter}}
+	}
+	{{- end}}{{end}}
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	ctx = authCtx
+	{{if $handler.AllowedRoles}}
+	if err := checkRole(ctx, []string{ {{range $handler.AllowedRoles}}"{{.}}", {{end}}}); err != nil {
+		return nil, &jsonRPCError{jsonRPCUnauthorized, err.Error(), jsonRPCStatus(http.StatusForbidden)}
+	}
+	{{end}}
+	{{end}}
+	queryParams, err := jsonRPCParamsToMap(rawParams, []string{ {{range .ParamFields}}"{{.ParamName}}", {{end}}}, {{$handler.Params.Strict}})
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(http.StatusBadRequest)}
+	}
+	in, err, statusCode := srv.parse{{$handler.Name}}Params(queryParams)
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCInvalidParams, err.Error(), jsonRPCStatus(statusCode)}
+	}
+	{{- if $handler.Params.Idempotent}}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		store := idempotencyStoreOrDefault({{if $.HasIdempotencyStore}}srv.IdempotencyStore{{else}}nil{{end}})
+		// Ответы JSON-RPC хранятся в другом формате, чем HTTP, поэтому и ключи у них свои
+		storeKey := "rpc|{{$handler.Params.Url}}|" + idempotencyCaller(r.WithContext(ctx)) + "|" + key
+		fingerprint := jsonRPCFingerprint(queryParams)
+		if record, reserved := store.Reserve(storeKey, fingerprint, idempotencyTTL); !reserved {
+			return replayJSONRPCIdempotent(w, record, fingerprint)
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				store.Release(storeKey)
+				panic(p)
+			}
+			finishJSONRPCIdempotent(store, storeKey, result, rpcErr)
+		}()
+	}
+	{{- end}}
+	ctx, callSpan := StartSpan(ctx, "{{$apiStructName}}.{{$handler.Name}}")
+	data, err := srv.{{$handler.Name}}(ctx, in)
+	callSpan.SetError(err)
+	callSpan.Finish()
+	if err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(apiErrorStatus(err))}
+	}
+	{{- range $handler.Params.Invalidates}}
+	responseCacheOrDefault({{if $.HasResponseCache}}srv.ResponseCache{{else}}nil{{end}}).DeletePrefix("{{.}}?")
+	{{- end}}
+	{{- if and $.HasAuditSink $handler.Audited}}
+	writeAudit(ctx, srv.AuditSink, {{if $.HasLogger}}srv.Logger{{else}}nil{{end}}, "{{$handler.Params.Url}}", "{{$handler.Params.Method}}", redactParams(queryParams, []string{ {{range .SensitiveParams}}"{{.}}", {{end}}}), data)
+	{{- end}}
+	return data, nil
+}
+{{end}}{{end}}
 `))
 )
 
@@ -134,6 +453,8 @@ type Restrictions struct {
 	Required bool
 	Min *int
 	Max *int
+	Classes []string
+	OmitEmpty bool
 	ParamName string
 	Enum *struct {
 		List []string
@@ -149,6 +470,10 @@ func parseRestrictions(restr string) *Restrictions {
 			restrictions.Required = true
 		}
 
+		if pair == "omitempty" {
+			restrictions.OmitEmpty = true
+		}
+
 		kv := strings.Split(pair, "=")
 		if len(kv) == 2 {
 			k := kv[0]
@@ -168,6 +493,10 @@ func parseRestrictions(restr string) *Restrictions {
 				restrictions.ParamName = v
 			}
 
+			if k == "classes" {
+				restrictions.Classes = strings.Split(v, "|")
+			}
+
 			if k == "enum" {
 				values := strings.Split(v, "|")
 				restrictions.Enum = &struct{List []string; Default string}{
@@ -191,15 +520,14 @@ func parseRestrictions(restr string) *Restrictions {
 }
 
 func queryParamsToMap(getParams map[string][]string, postParams string, method string) map[string]string {
-	println("parsing for method:", method)
 	values := map[string]string{}
 
 	if method == "POST" {
 		for _, kv := range strings.Split(postParams, "&") {
 			pair := strings.Split(kv, "=")
 			if len(pair) == 2 {
-				k := pair[0]
-				v := pair[1]
+				k := unescapeParam(pair[0])
+				v := unescapeParam(pair[1])
 				values[k] = v
 			}
 		}
@@ -212,6 +540,73 @@ func queryParamsToMap(getParams map[string][]string, postParams string, method s
 	return values
 }
 
+// unescapeParam раскодирует application/x-www-form-urlencoded, а битые значения оставляет как есть
+func unescapeParam(s string) string {
+	unescaped, err := url.QueryUnescape(s)
+	if err != nil {
+		return s
+	}
+	return unescaped
+}
+
+func checkStrictParams(getParams map[string][]string, postParams string, method string, known []string) error {
+	counts := map[string]int{}
+
+	// У POST параметры читаются из тела, но и query не должен молча игнорироваться:
+	// лишние ключи в нём - неизвестные, а ключ и там, и там - повтор
+	for k, arr := range getParams {
+		counts[k] += len(arr)
+	}
+	if method == "POST" {
+		for _, kv := range strings.Split(postParams, "&") {
+			k := unescapeParam(strings.Split(kv, "=")[0])
+			if k != "" {
+				counts[k]++
+			}
+		}
+	}
+
+	unknown := []string{}
+	duplicated := []string{}
+	for k, n := range counts {
+		if !contains(known, k) {
+			unknown = append(unknown, k)
+		}
+		if n > 1 {
+			duplicated = append(duplicated, k)
+		}
+	}
+	sort.Strings(unknown)
+	sort.Strings(duplicated)
+
+	problems := []string{}
+	if len(unknown) > 0 {
+		problems = append(problems, "unknown params: "+strings.Join(unknown, ", "))
+	}
+	if len(duplicated) > 0 {
+		problems = append(problems, "duplicated params: "+strings.Join(duplicated, ", "))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// apigenParamError - ошибка валидации конкретного параметра, текст не меняет
+type apigenParamError struct {
+	Param string
+	Err   error
+}
+
+func (e *apigenParamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *apigenParamError) Unwrap() error {
+	return e.Err
+}
+
 func validParamStr(paramName string, restrRaw string, queryParams map[string]string) (string, error, int) {
 	restr := parseRestrictions(restrRaw)
 	
@@ -226,12 +621,21 @@ func validParamStr(paramName string, restrRaw string, queryParams map[string]str
 		return "", errors.New(name + " must me not empty"), http.StatusBadRequest
 	}
 
-	if restr.Max != nil && len(value) > *restr.Max {
-		return "", errors.New(name + " len must be <= " + fmt.Sprint(*restr.Max)), http.StatusBadRequest
-	}
+	// С omitempty пустое значение - "не передан": длину и классы символов у него не проверяем
+	if !(restr.OmitEmpty && value == "") {
+		if restr.Max != nil && len(value) > *restr.Max {
+			return "", errors.New(name + " len must be <= " + fmt.Sprint(*restr.Max)), http.StatusBadRequest
+		}
 
-	if restr.Min != nil && len(value) < *restr.Min {
-		return "", errors.New(name + " len must be >= " + fmt.Sprint(*restr.Min)), http.StatusBadRequest
+		if restr.Min != nil && len(value) < *restr.Min {
+			return "", errors.New(name + " len must be >= " + fmt.Sprint(*restr.Min)), http.StatusBadRequest
+		}
+
+		for _, class := range restr.Classes {
+			if !hasCharClass(value, class) {
+				return "", errors.New(name + " must contain " + charClassNames[class]), http.StatusBadRequest
+			}
+		}
 	}
 
 	if restr.Enum != nil {
@@ -260,6 +664,10 @@ func validParamInt(paramName string, restrRaw string, queryParams map[string]str
 	if restr.Required && value == "" {
 		return 0, errors.New(name + " must me not empty"), http.StatusBadRequest
 	}
+	// default у числа разбирается туда же, куда и у enum
+	if value == "" && restr.Enum != nil && restr.Enum.Default != "" {
+		value = restr.Enum.Default
+	}
 
 	num, err := strconv.Atoi(value)
 	if err != nil {
@@ -270,7 +678,7 @@ func validParamInt(paramName string, restrRaw string, queryParams map[string]str
 		return 0, errors.New(name + " must be <= " + fmt.Sprint(*restr.Max)), http.StatusBadRequest
 	}
 
-	if restr.Max != nil && num < *restr.Min {
+	if restr.Min != nil && num < *restr.Min {
 		return 0, errors.New(name + " must be >= " + fmt.Sprint(*restr.Min)), http.StatusBadRequest
 	}
 
@@ -287,15 +695,142 @@ func contains(s []string, str string) bool {
 	return false
 }
 
+// Классы символов для classes=... в apivalidator, как они называются в ошибке
+var charClassNames = map[string]string{
+	"lower":   "a lowercase letter",
+	"upper":   "an uppercase letter",
+	"digit":   "a digit",
+	"special": "a special character",
+}
+
+func hasCharClass(value string, class string) bool {
+	for _, c := range value {
+		switch {
+		case class == "lower" && unicode.IsLower(c),
+			class == "upper" && unicode.IsUpper(c),
+			class == "digit" && unicode.IsDigit(c),
+			class == "special" && !unicode.IsLetter(c) && !unicode.IsDigit(c) && !unicode.IsSpace(c):
+			return true
+		}
+	}
+	return false
+}
+
 `))
 )
 
+// Классы символов, которые понимает classes=... в apivalidator, и как их называет рантайм в ошибке
+var (
+	charClasses    = []string{"lower", "upper", "digit", "special"}
+	charClassNames = map[string]string{
+		"lower":   "a lowercase letter",
+		"upper":   "an uppercase letter",
+		"digit":   "a digit",
+		"special": "a special character",
+	}
+)
+
 type ParamField struct {
 	Name string
 	Type string
 	Tags string
 }
 
+// ParamRules - правила apivalidator, разобранные на этапе генерации
+type ParamRules struct {
+	Required   bool
+	ParamName  string
+	Min        *int
+	Max        *int
+	Enum       []string
+	Default    string
+	HasDefault bool
+	Sensitive  bool     // значение не попадает в логи
+	Classes    []string // классы символов, каждый из которых должен встретиться в строке
+	OmitEmpty  bool     // пустая строка не проверяется на min и classes
+}
+
+func (pf ParamField) Rules() ParamRules {
+	tags, err := strconv.Unquote(pf.Tags)
+	if err != nil {
+		tags = pf.Tags
+	}
+
+	rules := ParamRules{ParamName: strings.ToLower(pf.Name)}
+	for _, pair := range strings.Split(tags, ",") {
+		if pair == "required" {
+			rules.Required = true
+		}
+		if pair == "sensitive" {
+			rules.Sensitive = true
+		}
+		if pair == "omitempty" {
+			rules.OmitEmpty = true
+		}
+
+		kv := strings.Split(pair, "=")
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "paramname":
+			rules.ParamName = kv[1]
+		case "min":
+			min, _ := strconv.Atoi(kv[1])
+			rules.Min = &min
+		case "max":
+			max, _ := strconv.Atoi(kv[1])
+			rules.Max = &max
+		case "enum":
+			rules.Enum = strings.Split(kv[1], "|")
+		case "classes":
+			rules.Classes = strings.Split(kv[1], "|")
+		case "default":
+			rules.Default = kv[1]
+			rules.HasDefault = true
+		}
+	}
+	return rules
+}
+
+// Omittable - можно ли не передавать параметр: пустую строку без required пропускают omitempty
+// или отсутствие min и classes, а число без default на пустом значении падает с "must be int"
+func (pf ParamField) Omittable() bool {
+	rules := pf.Rules()
+	if rules.Required {
+		return false
+	}
+	if pf.Type == "string" {
+		return rules.OmitEmpty || (rules.Min == nil || *rules.Min <= 0) && len(rules.Classes) == 0
+	}
+	return rules.HasDefault
+}
+
+// checkParamRules ловит на этапе генерации правила, которые в рантайме молча не сработали бы
+func checkParamRules(handler *HttpHandlerData) error {
+	for _, field := range handler.ParamFields {
+		rules := field.Rules()
+		if rules.Classes == nil {
+			continue
+		}
+		if field.Type != "string" {
+			return fmt.Errorf("%s: classes is allowed only for string params", rules.ParamName)
+		}
+		for _, class := range rules.Classes {
+			if !contains(charClasses, class) {
+				return fmt.Errorf("%s: unknown character class %q, expected one of: %s",
+					rules.ParamName, class, strings.Join(charClasses, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// ParamName - имя параметра в запросе: paramname из тегов или lowercase от имени поля
+func (pf ParamField) ParamName() string {
+	return pf.Rules().ParamName
+}
+
 type HandlerParams = map[string][]ParamField
 
 type HttpHandlerData struct {
@@ -303,21 +838,183 @@ type HttpHandlerData struct {
 	Params           GenParams
 	ParamsStructName string
 	ParamFields      []ParamField
+	ResultTypeName   string // User для (*User, error)
+	ErrorStatuses    []int  // статусы ApiError, которые явно возвращает метод
+	Middleware       []string
+	CORS             *CORSPolicy // nil - CORS не настроен
+	AllowedRoles     []string    // nil - роль не проверяется
+}
+
+// HandlerChain - обработчик, обёрнутый в middleware: первый в списке вызывается первым
+func (h *HttpHandlerData) HandlerChain() string {
+	return h.WrapMiddleware("http.HandlerFunc(srv." + h.Name + "HTTPHandler)")
+}
+
+// WrapMiddleware - выражение inner, обёрнутое в middleware эндпоинта
+func (h *HttpHandlerData) WrapMiddleware(inner string) string {
+	chain := inner
+	for i := len(h.Middleware) - 1; i >= 0; i-- {
+		chain = "srv." + h.Middleware[i] + "(" + chain + ")"
+	}
+	return chain
+}
+
+// SensitiveParams - имена параметров, помеченных sensitive: в логах их значения скрыты
+func (h *HttpHandlerData) SensitiveParams() []string {
+	names := []string{}
+	for _, field := range h.ParamFields {
+		if rules := field.Rules(); rules.Sensitive {
+			names = append(names, rules.ParamName)
+		}
+	}
+	return names
 }
 
 type serverStructName = string
 
 type HTTPHandlers map[serverStructName][]*HttpHandlerData
 
+// StructNames - имена API-структур в алфавитном порядке, чтобы результат генерации не менялся от запуска к запуску
+func (h HTTPHandlers) StructNames() []string {
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApiSource - всё, что удалось вытащить из разбираемого файла
+type ApiSource struct {
+	PackageName string
+	Handlers    HTTPHandlers
+	Structs     map[string]*ast.StructType
+	Fset        *token.FileSet
+	// Конструкторы вида func NewMyApi() *MyApi - по имени структуры
+	Constructors map[string]string
+	// Настройки из // apigen:struct {...} - по имени структуры
+	StructParams map[string]*StructGenParams
+}
+
+// HasField - есть ли у структуры поле с таким именем и типом, например Logger *slog.Logger.
+// Так генератор находит необязательные настройки, которые можно подложить в API-структуру
+func (src *ApiSource) HasField(structName string, name string, fieldType string) bool {
+	st, ok := src.Structs[structName]
+	if !ok {
+		return false
+	}
+	for _, field := range st.Fields.List {
+		for _, fieldName := range field.Names {
+			if fieldName.Name == name && types.ExprString(field.Type) == fieldType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	openapiDir    = flag.String("openapi", "", "директория, куда писать OpenAPI 3 документы (по одному на API-структуру)")
+	openapiFormat = flag.String("openapi-format", "json", "формат OpenAPI документов: json или yaml")
+	clientDir     = flag.String("client", "", "директория, куда писать пакет с go-клиентом для API-структур")
+	clientPackage = flag.String("client-package", "", "имя пакета клиента, по умолчанию - имя директории")
+	tsFile        = flag.String("ts", "", "файл, куда писать TypeScript типы и fetch-клиент")
+	withDocs      = flag.Bool("docs", false, "добавить в ServeHTTP маршруты /_routes и /_docs")
+	withMetrics   = flag.Bool("metrics", false, "считать метрики эндпоинтов и отдавать их на /_metrics в формате Prometheus")
+	testsDir      = flag.String("tests", "", "директория, куда писать сгенерированные по правилам apivalidator тесты")
+	fuzzDir       = flag.String("fuzz", "", "директория, куда писать fuzz-тесты для обработчиков")
+	jsonRPCPath   = flag.String("jsonrpc", "", "путь, на котором ServeHTTP отвечает по JSON-RPC 2.0, например /rpc")
+	fromOpenAPI   = flag.String("from-openapi", "", "обратный режим: OpenAPI 3 документ (json или yaml), по которому пишется каркас api.go")
+	scaffoldPkg   = flag.String("package", "main", "имя пакета для каркаса api.go в режиме -from-openapi")
+	permissions   = flag.String("permissions", "", "файл, куда писать матрицу прав: какие роли могут звать каждый эндпоинт")
+)
+
 func main() {
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, os.Args[1], nil, parser.ParseComments)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: codegen [flags] api.go [api_handlers.go]")
+		fmt.Fprintln(os.Stderr, "       codegen -from-openapi openapi.yaml api.go")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *fromOpenAPI != "" {
+		if err := writeApiFromOpenAPI(*fromOpenAPI, flag.Arg(0), *scaffoldPkg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	src, err := parseApiFile(flag.Arg(0))
 	if err != nil {
 		log.Fatal(err)
 	}
-	out, _ := os.Create(os.Args[2])
 
-	fmt.Fprintln(out, `package `+node.Name.Name)
+	if flag.Arg(1) != "" {
+		out, err := os.Create(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = writeHandlersFile(out, src, HandlersOptions{
+			Docs:    *withDocs,
+			Metrics: *withMetrics,
+			JSONRPC: *jsonRPCPath,
+		})
+		out.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *openapiDir != "" {
+		if err := writeOpenAPI(*openapiDir, *openapiFormat, src); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *clientDir != "" {
+		if err := writeClient(*clientDir, *clientPackage, src); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *tsFile != "" {
+		if err := writeTypeScript(*tsFile, src); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *testsDir != "" {
+		if err := writeTests(*testsDir, src); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *fuzzDir != "" {
+		if err := writeFuzzTests(*fuzzDir, src); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *permissions != "" {
+		if err := writePermissions(*permissions, src); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// HandlersOptions - то, что включается флагами при генерации обработчиков
+type HandlersOptions struct {
+	Docs    bool   // маршруты /_routes и /_docs
+	Metrics bool   // счётчики и маршрут /_metrics
+	JSONRPC string // путь JSON-RPC эндпоинта, пустой - не генерировать
+}
+
+func writeHandlersFile(out *os.File, src *ApiSource, opts HandlersOptions) error {
+	fmt.Fprintln(out, `package `+src.PackageName)
 	fmt.Fprintln(out)
 	fmt.Fprintln(out, `import "net/http"`)
 	fmt.Fprintln(out, `import "encoding/json"`)
@@ -327,12 +1024,55 @@ func main() {
 	fmt.Fprintln(out, `import "context"`)
 	fmt.Fprintln(out, `import "io/ioutil"`)
 	fmt.Fprintln(out, `import "fmt"`)
+	fmt.Fprintln(out, `import "sort"`)
+	fmt.Fprintln(out, `import "net/url"`)
+	fmt.Fprintln(out, `import "log/slog"`)
+	fmt.Fprintln(out, `import "time"`)
+	fmt.Fprintln(out, `import "sync"`)
+	fmt.Fprintln(out, `import "os"`)
+	fmt.Fprintln(out, `import "crypto/rand"`)
+	fmt.Fprintln(out, `import "encoding/hex"`)
+	fmt.Fprintln(out, `import "net"`)
+	fmt.Fprintln(out, `import "math"`)
+	fmt.Fprintln(out, `import "runtime/debug"`)
+	fmt.Fprintln(out, `import "crypto/sha256"`)
+	fmt.Fprintln(out, `import "bytes"`)
+	fmt.Fprintln(out, `import "crypto/subtle"`)
+	fmt.Fprintln(out, `import "unicode"`)
 	fmt.Fprintln(out)
 	respAction.Execute(out, nil)
 	urlParamsValidator.Execute(out, nil)
+	requestLogRuntime.Execute(out, nil)
+	tracingRuntime.Execute(out, nil)
+	corsRuntime.Execute(out, nil)
+	cacheRuntime.Execute(out, nil)
+	idempotencyRuntime.Execute(out, nil)
+	authRuntime.Execute(out, legacyAuthToken)
+	auditRuntime.Execute(out, nil)
+	rateLimitRuntime.Execute(out, nil)
+	if opts.Metrics {
+		metricsRuntime.Execute(out, nil)
+	}
+	if opts.JSONRPC != "" {
+		jsonRPCRuntime.Execute(out, nil)
+	}
+
+	return writeHTTPHandlers(out, src, opts)
+}
+
+func parseApiFile(path string) (*ApiSource, error) {
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
 
 	httpHandlers := HTTPHandlers{}
 	handlerParams := HandlerParams{}
+	structs := map[string]*ast.StructType{}
+	constructors := map[string]string{}
+	structParams := map[string]*StructGenParams{}
+	middlewares := map[string]map[string]bool{}
 
 	// Ищем объявления структур
 	for _, dec := range node.Decls {
@@ -345,7 +1085,23 @@ func main() {
 				if currType, ok := spec.(*ast.TypeSpec); ok {
 					// Если спарсили type
 					typeName := currType.Name.Name
-					if currStruct, ok := currType.Type.(*ast.StructType); ok && strings.Contains(typeName, "Params") {
+					currStruct, ok := currType.Type.(*ast.StructType)
+					if ok {
+						// Запоминаем все структуры - они понадобятся для описания ответов
+						structs[typeName] = currStruct
+					}
+					// Комментарий у одиночного type лежит в GenDecl, у сгруппированных - в TypeSpec
+					for _, doc := range []*ast.CommentGroup{genDecs.Doc, currType.Doc} {
+						if doc == nil {
+							continue
+						}
+						for _, comment := range doc.List {
+							if strings.Contains(comment.Text, "apigen:struct") {
+								structParams[typeName] = parseStructDocs(comment.Text)
+							}
+						}
+					}
+					if ok && strings.Contains(typeName, "Params") {
 						// Если спарсили struct
 						handlerParams[typeName] = []ParamField{}
 
@@ -365,6 +1121,21 @@ func main() {
 		}
 
 		funcDecl, ok := dec.(*ast.FuncDecl)
+		if ok && funcDecl.Recv == nil && len(funcDecl.Type.Params.List) == 0 {
+			resultName := parseResultTypeName(funcDecl)
+			if resultName != "" && funcDecl.Name.Name == "New"+resultName {
+				constructors[resultName] = funcDecl.Name.Name
+			}
+		}
+
+		if ok && funcDecl.Recv != nil && isMiddlewareFunc(funcDecl) {
+			recvTypeName := parseRecieverType(funcDecl.Recv.List[0])
+			if middlewares[recvTypeName] == nil {
+				middlewares[recvTypeName] = map[string]bool{}
+			}
+			middlewares[recvTypeName][funcDecl.Name.Name] = true
+		}
+
 		// Handle only recievers with docs
 		if ok && (funcDecl.Doc != nil) && (funcDecl.Recv != nil) {
 			var recvTypeName string         // MyApi
@@ -384,6 +1155,8 @@ func main() {
 						Name:             funcDecl.Name.Name,
 						Params:           *genParams,
 						ParamsStructName: recvParamsStructName,
+						ResultTypeName:   parseResultTypeName(funcDecl),
+						ErrorStatuses:    parseApiErrorStatuses(funcDecl),
 					})
 					break
 				}
@@ -400,12 +1173,67 @@ func main() {
 		}
 	}
 
-	writeHTTPHandlers(out, httpHandlers)
+	for apiName, v := range httpHandlers {
+		for _, handler := range v {
+			if err := checkParamRules(handler); err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", apiName, handler.Name, err)
+			}
+		}
+	}
+
+	for apiName, v := range httpHandlers {
+		if err := checkCache(apiName, v); err != nil {
+			return nil, err
+		}
+		for _, handler := range v {
+			if err := checkIdempotent(apiName, handler); err != nil {
+				return nil, err
+			}
+			handler.Middleware = handler.Params.Middleware
+			if handler.Middleware == nil && structParams[apiName] != nil {
+				handler.Middleware = structParams[apiName].Middleware
+			}
+			var structCORS *CORSParams
+			if structParams[apiName] != nil {
+				structCORS = structParams[apiName].CORS
+			}
+			handler.CORS, err = resolveCORS(structCORS, handler.Params.CORS, handlerMethods(handler))
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", apiName, handler.Name, err)
+			}
+			handler.AllowedRoles, err = resolveRoles(structParams[apiName], handler)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", apiName, handler.Name, err)
+			}
+			if rl := handler.Params.RateLimit; rl != nil {
+				if err := rl.check(handler.Params.Auth); err != nil {
+					return nil, fmt.Errorf("%s.%s: %v", apiName, handler.Name, err)
+				}
+			}
+			for _, name := range handler.Middleware {
+				if !middlewares[apiName][name] {
+					return nil, fmt.Errorf("%s.%s: unknown middleware %q, expected method func (srv *%s) %s(next http.Handler) http.Handler",
+						apiName, handler.Name, name, apiName, name)
+				}
+			}
+		}
+	}
+
+	return &ApiSource{
+		PackageName: node.Name.Name,
+		Handlers:    httpHandlers,
+		Structs:     structs,
+		Fset:        fset,
+
+		Constructors: constructors,
+		StructParams: structParams,
+	}, nil
 }
 
 func parseDocs(rawStr string) *GenParams {
 	firstStructPos := strings.Index(rawStr, "{")
-	lastStructPos := strings.Index(rawStr, "}")
+	// Последняя скобка, а не первая - внутри могут быть вложенные объекты, например ratelimit
+	lastStructPos := strings.LastIndex(rawStr, "}")
 	stringJson := rawStr[firstStructPos : lastStructPos+1]
 
 	params := &GenParams{}
@@ -414,6 +1242,35 @@ func parseDocs(rawStr string) *GenParams {
 	return params
 }
 
+func parseStructDocs(rawStr string) *StructGenParams {
+	firstStructPos := strings.Index(rawStr, "{")
+	lastStructPos := strings.LastIndex(rawStr, "}")
+	params := &StructGenParams{}
+	if firstStructPos < 0 || lastStructPos < firstStructPos {
+		return params
+	}
+
+	json.Unmarshal([]byte(rawStr[firstStructPos:lastStructPos+1]), params)
+	return params
+}
+
+// isMiddlewareFunc - метод вида func (srv *MyApi) name(next http.Handler) http.Handler
+func isMiddlewareFunc(funcDecl *ast.FuncDecl) bool {
+	isHandler := func(expr ast.Expr) bool {
+		sel, ok := expr.(*ast.SelectorExpr)
+		if !ok {
+			return false
+		}
+		pkg, ok := sel.X.(*ast.Ident)
+		return ok && pkg.Name == "http" && sel.Sel.Name == "Handler"
+	}
+
+	params := funcDecl.Type.Params.List
+	results := funcDecl.Type.Results
+	return len(params) == 1 && len(params[0].Names) <= 1 && isHandler(params[0].Type) &&
+		results != nil && len(results.List) == 1 && len(results.List[0].Names) <= 1 && isHandler(results.List[0].Type)
+}
+
 func parseRecieverType(recv *ast.Field) (typeName string) {
 	switch xv := recv.Type.(type) {
 	case *ast.StarExpr:
@@ -443,6 +1300,83 @@ func parseRecieverParamsStructName(funcDecl *ast.FuncDecl) (typeName string) {
 	return
 }
 
+func parseResultTypeName(funcDecl *ast.FuncDecl) (typeName string) {
+	if funcDecl.Type.Results == nil || len(funcDecl.Type.Results.List) == 0 {
+		return
+	}
+	switch xv := funcDecl.Type.Results.List[0].Type.(type) {
+	case *ast.StarExpr:
+		if si, ok := xv.X.(*ast.Ident); ok {
+			typeName = si.Name
+		}
+	case *ast.Ident:
+		typeName = xv.Name
+	}
+	return
+}
+
+// parseApiErrorStatuses ищет в теле метода литералы ApiError{http.StatusXxx, ...}
+func parseApiErrorStatuses(funcDecl *ast.FuncDecl) []int {
+	statuses := []int{}
+	if funcDecl.Body == nil {
+		return statuses
+	}
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		if ident, ok := lit.Type.(*ast.Ident); !ok || ident.Name != "ApiError" || len(lit.Elts) == 0 {
+			return true
+		}
+		statusExpr := lit.Elts[0]
+		if kv, ok := statusExpr.(*ast.KeyValueExpr); ok {
+			statusExpr = kv.Value
+		}
+		if status := httpStatusFromExpr(statusExpr); status != 0 {
+			statuses = appendUniqueInt(statuses, status)
+		}
+		return true
+	})
+	sort.Ints(statuses)
+	return statuses
+}
+
+// httpStatusFromExpr понимает как http.StatusNotFound, так и просто 404
+func httpStatusFromExpr(expr ast.Expr) int {
+	switch xv := expr.(type) {
+	case *ast.BasicLit:
+		status, _ := strconv.Atoi(xv.Value)
+		return status
+	case *ast.SelectorExpr:
+		for code := 100; code < 600; code++ {
+			text := http.StatusText(code)
+			if text == "" {
+				continue
+			}
+			name := "Status" + strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, text)
+			if name == xv.Sel.Name {
+				return code
+			}
+		}
+	}
+	return 0
+}
+
+func appendUniqueInt(list []int, v int) []int {
+	for _, item := range list {
+		if item == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
 func parseFieldType(field *ast.Field) (typeName string) {
 	switch xv := field.Type.(type) {
 	case *ast.StarExpr:
@@ -455,17 +1389,71 @@ func parseFieldType(field *ast.Field) (typeName string) {
 	return
 }
 
-func writeHTTPHandlers(out *os.File, data HTTPHandlers) {
-	for k, v := range data {
+func writeHTTPHandlers(out *os.File, src *ApiSource, opts HandlersOptions) error {
+	for _, k := range src.Handlers.StructNames() {
+		v := src.Handlers[k]
 		templateData := &struct {
-			ApiStructName string
-			Handlers      []*HttpHandlerData
+			ApiStructName       string
+			Handlers            []*HttpHandlerData
+			Docs                *apiDocs
+			JSONRPC             string
+			HasLogger           bool
+			HasExporter         bool
+			HasRateLimiter      bool
+			HasPanicHook        bool
+			HasResponseCache    bool
+			HasIdempotencyStore bool
+			HasAuthenticator    bool
+			HasAuditSink        bool
+			RequestIDInErrors   bool
+			CORS                string // имя переменной с CORS-политиками
+			CORSPolicies        map[string]*CORSPolicy
+			Metrics             string // имя переменной с реестром метрик
 		}{
-			ApiStructName: k,
-			Handlers:      v,
+			ApiStructName:       k,
+			Handlers:            v,
+			JSONRPC:             opts.JSONRPC,
+			HasLogger:           src.HasField(k, "Logger", "*slog.Logger"),
+			HasExporter:         src.HasField(k, "SpanExporter", "SpanExporter"),
+			HasRateLimiter:      src.HasField(k, "RateLimiter", "RateLimiterStore"),
+			HasPanicHook:        src.HasField(k, "PanicHook", "PanicHook"),
+			HasResponseCache:    src.HasField(k, "ResponseCache", "ResponseCache"),
+			HasIdempotencyStore: src.HasField(k, "IdempotencyStore", "IdempotencyStore"),
+			HasAuthenticator:    src.HasField(k, "Authenticator", "Authenticator"),
+			HasAuditSink:        src.HasField(k, "AuditSink", "AuditSink"),
+			RequestIDInErrors:   src.StructParams[k] != nil && src.StructParams[k].RequestIDInErrors,
 		}
-		serveHttpTmp.Execute(out, templateData)
+		policies, err := corsPolicies(src, k, opts)
+		if err != nil {
+			return err
+		}
+		if len(policies) > 0 {
+			templateData.CORS = lowerFirst(k) + "CORS"
+			templateData.CORSPolicies = policies
+		}
+		if opts.Metrics {
+			templateData.Metrics = lowerFirst(k) + "Metrics"
+		}
+		if opts.Docs {
+			docs, err := buildApiDocs(k, v)
+			if err != nil {
+				return err
+			}
+			templateData.Docs = docs
+		}
+		if err := serveHttpTmp.Execute(out, templateData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lowerFirst - MyApi -> myApi, для имён неэкспортируемых переменных
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
 	}
+	return strings.ToLower(s[:1]) + s[1:]
 }
 
 func clearStructTags(tags string) string {
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// CORSParams - "cors" в apigen:struct и apigen:api. У эндпоинта заданные поля
+// перекрывают настройки структуры, незаданные берутся из неё
+type CORSParams struct {
+	Origins     []string `json:"origins"`
+	Methods     []string `json:"methods"`
+	Headers     []string `json:"headers"`
+	Credentials *bool    `json:"credentials"`
+	MaxAge      *int     `json:"max_age"`
+}
+
+// CORSPolicy - итоговые настройки эндпоинта, которые попадают в сгенерированный код
+type CORSPolicy struct {
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      int
+}
+
+// Заголовки, которые шлёт наш же клиент, разрешены по умолчанию
+var defaultCORSHeaders = []string{"Content-Type", "X-Auth", "X-Request-ID", "traceparent", "Idempotency-Key"}
+
+// resolveCORS собирает политику эндпоинта; nil - CORS для него не настроен
+func resolveCORS(structParams *CORSParams, handlerParams *CORSParams, methods []string) (*CORSPolicy, error) {
+	if structParams == nil && handlerParams == nil {
+		return nil, nil
+	}
+
+	merged := CORSParams{}
+	for _, p := range []*CORSParams{structParams, handlerParams} {
+		if p == nil {
+			continue
+		}
+		if p.Origins != nil {
+			merged.Origins = p.Origins
+		}
+		if p.Methods != nil {
+			merged.Methods = p.Methods
+		}
+		if p.Headers != nil {
+			merged.Headers = p.Headers
+		}
+		if p.Credentials != nil {
+			merged.Credentials = p.Credentials
+		}
+		if p.MaxAge != nil {
+			merged.MaxAge = p.MaxAge
+		}
+	}
+
+	policy := &CORSPolicy{
+		Origins: merged.Origins,
+		Methods: merged.Methods,
+		Headers: merged.Headers,
+	}
+	if merged.Credentials != nil {
+		policy.Credentials = *merged.Credentials
+	}
+	if merged.MaxAge != nil {
+		policy.MaxAge = *merged.MaxAge
+	}
+	// Методы по умолчанию - те, на которые эндпоинт вообще отвечает
+	if policy.Methods == nil {
+		policy.Methods = methods
+	}
+	if policy.Headers == nil {
+		policy.Headers = defaultCORSHeaders
+	}
+
+	if len(policy.Origins) == 0 {
+		return nil, fmt.Errorf("cors: origins must not be empty")
+	}
+	if policy.Credentials && contains(policy.Origins, "*") {
+		return nil, fmt.Errorf("cors: credentials cannot be allowed for origin *")
+	}
+	if policy.MaxAge < 0 {
+		return nil, fmt.Errorf("cors: max_age must be >= 0")
+	}
+	return policy, nil
+}
+
+// handlerMethods - методы, на которые отвечает обработчик: указанный в apigen:api или GET и POST
+func handlerMethods(handler *HttpHandlerData) []string {
+	if handler.Params.Method != "" {
+		return []string{handler.Params.Method}
+	}
+	return []string{http.MethodGet, http.MethodPost}
+}
+
+// corsPolicies - политики по путям для ServeHTTP. JSON-RPC эндпоинт живёт по настройкам структуры
+func corsPolicies(src *ApiSource, apiName string, opts HandlersOptions) (map[string]*CORSPolicy, error) {
+	policies := map[string]*CORSPolicy{}
+	for _, handler := range src.Handlers[apiName] {
+		if handler.CORS != nil {
+			policies[handler.Params.Url] = handler.CORS
+		}
+	}
+	if opts.JSONRPC != "" && src.StructParams[apiName] != nil {
+		policy, err := resolveCORS(src.StructParams[apiName].CORS, nil, []string{http.MethodPost})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", apiName, err)
+		}
+		if policy != nil {
+			policies[opts.JSONRPC] = policy
+		}
+	}
+	return policies, nil
+}
+
+// corsRuntime отвечает на preflight по таблице маршрутов и ставит Access-Control-* на обычные ответы
+var (
+	corsRuntime = template.Must(template.New("corsRuntime").Parse(`
+type corsPolicy struct {
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      int
+}
+
+// Заголовки ответа, которые браузер покажет скрипту
+const corsExposeHeaders = "X-Request-ID, traceparent, Retry-After"
+
+// handleCORS возвращает true, если запрос был preflight и ответ уже отправлен
+func handleCORS(w http.ResponseWriter, r *http.Request, policy *corsPolicy) bool {
+	origin := r.Header.Get("Origin")
+	if policy == nil || origin == "" {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if !contains(policy.Origins, "*") && !contains(policy.Origins, origin) {
+		if preflight {
+			response(w, &ApiError{http.StatusForbidden, errors.New("cors: origin not allowed")}, nil)
+			return true
+		}
+		return false
+	}
+
+	if contains(policy.Origins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if policy.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		return false
+	}
+
+	if !contains(policy.Methods, r.Header.Get("Access-Control-Request-Method")) {
+		response(w, &ApiError{http.StatusForbidden, errors.New("cors: method not allowed")}, nil)
+		return true
+	}
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !containsFold(policy.Headers, header) {
+			response(w, &ApiError{http.StatusForbidden, errors.New("cors: header " + header + " not allowed")}, nil)
+			return true
+		}
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+	if policy.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// containsFold - contains без учёта регистра, имена заголовков регистронезависимы
+func containsFold(s []string, str string) bool {
+	for _, v := range s {
+		if strings.EqualFold(v, str) {
+			return true
+		}
+	}
+	return false
+}
+`))
+)
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"strconv"
+	"strings"
+)
+
+var (
+	docsPageTmp = template.Must(template.New("docsPageTmp").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.ApiName}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+form { border: 1px solid #ccc; padding: 1em; margin-bottom: 1em; }
+label { display: block; margin: 0.3em 0; }
+pre { background: #f4f4f4; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>{{.ApiName}}</h1>
+{{range .Routes}}
+<form class="route" data-url="{{.Url}}">
+<h2>{{.Url}}</h2>
+<p>{{.Handler}}, method: {{.Method}}{{if .Auth}}, auth required{{end}}{{if .Roles}}, roles: {{range $i, $role := .Roles}}{{if $i}}, {{end}}{{$role}}{{end}}{{end}}</p>
+<label>method
+<select name="_method">{{if eq .Method "ANY"}}<option>GET</option><option>POST</option>{{else}}<option>{{.Method}}</option>{{end}}</select>
+</label>
+{{if .Auth}}<label>X-Auth <input name="_auth"></label>{{end}}
+{{range .Params}}
+<label>{{.Name}} ({{.Type}}{{if .Rules}}; {{.Rules}}{{end}}) <input name="{{.Name}}"{{if .Sensitive}} type="password"{{end}}{{if .Default}} placeholder="{{.Default}}"{{end}}></label>
+{{end}}
+<button type="submit">try it</button>
+<pre class="result"></pre>
+</form>
+{{end}}
+<script>
+document.querySelectorAll("form.route").forEach(function (form) {
+  form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    var params = new URLSearchParams();
+    var method = "GET";
+    var headers = {};
+    new FormData(form).forEach(function (value, key) {
+      if (key === "_method") {
+        method = value;
+      } else if (key === "_auth") {
+        if (value !== "") headers["X-Auth"] = value;
+      } else if (value !== "") {
+        params.append(key, value);
+      }
+    });
+    var url = form.dataset.url;
+    var init = { method: method, headers: headers };
+    // Как и сервер, тело с параметрами читается только у POST
+    if (method === "POST") {
+      headers["Content-Type"] = "application/x-www-form-urlencoded";
+      init.body = params.toString();
+    } else {
+      url += "?" + params.toString();
+    }
+    var out = form.querySelector(".result");
+    fetch(url, init).then(function (resp) {
+      return resp.text().then(function (text) {
+        out.textContent = resp.status + "\n" + text;
+      });
+    }).catch(function (err) {
+      out.textContent = String(err);
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
+)
+
+// apiDocs - уже закавыченные go-строки, которые попадают в сгенерированный код константами
+type apiDocs struct {
+	RoutesJSON string
+	DocsHTML   string
+}
+
+type routeParam struct {
+	Name     string   `json:"name"`
+	Field    string   `json:"field"`
+	Type     string   `json:"type"`
+	Rules    string   `json:"rules"`
+	Required bool     `json:"required"`
+	Min      *int     `json:"min,omitempty"`
+	Max      *int     `json:"max,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+	Default  string   `json:"default,omitempty"`
+	Classes  []string `json:"classes,omitempty"`
+	// Значение скрывается в логах, а в форме вводится как пароль
+	Sensitive bool `json:"sensitive,omitempty"`
+}
+
+type routeInfo struct {
+	Url     string       `json:"url"`
+	Method  string       `json:"method"`
+	Auth    bool         `json:"auth"`
+	Roles   []string     `json:"roles,omitempty"`
+	Handler string       `json:"handler"`
+	Params  []routeParam `json:"params"`
+}
+
+func buildRoutes(handlers []*HttpHandlerData) []routeInfo {
+	routes := make([]routeInfo, 0, len(handlers))
+	for _, handler := range handlers {
+		route := routeInfo{
+			Url:     handler.Params.Url,
+			Method:  handler.Params.Method,
+			Auth:    handler.Params.Auth,
+			Roles:   handler.AllowedRoles,
+			Handler: handler.Name,
+			Params:  []routeParam{},
+		}
+		if route.Method == "" {
+			route.Method = "ANY"
+		}
+
+		for _, field := range handler.ParamFields {
+			rules := field.Rules()
+			rawRules, err := strconv.Unquote(field.Tags)
+			if err != nil {
+				rawRules = field.Tags
+			}
+			route.Params = append(route.Params, routeParam{
+				Name:      rules.ParamName,
+				Field:     field.Name,
+				Type:      field.Type,
+				Rules:     rawRules,
+				Required:  rules.Required,
+				Min:       rules.Min,
+				Max:       rules.Max,
+				Enum:      rules.Enum,
+				Default:   rules.Default,
+				Classes:   rules.Classes,
+				Sensitive: rules.Sensitive,
+			})
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+func buildApiDocs(apiName string, handlers []*HttpHandlerData) (*apiDocs, error) {
+	routes := buildRoutes(handlers)
+
+	// Отвечаем в том же конверте, что и обычные методы
+	routesJSON, err := json.Marshal(struct {
+		Error    string      `json:"error"`
+		Response []routeInfo `json:"response"`
+	}{"", routes})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &bytes.Buffer{}
+	err = docsPageTmp.Execute(page, struct {
+		ApiName string
+		Routes  []routeInfo
+	}{apiName, routes})
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiDocs{
+		RoutesJSON: goStringLiteral(string(routesJSON)),
+		DocsHTML:   goStringLiteral(page.String()),
+	}, nil
+}
+
+// goStringLiteral по возможности оставляет текст читаемым в сгенерированном коде
+func goStringLiteral(s string) string {
+	if strings.Contains(s, "`") || strings.Contains(s, "\r") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// checkIdempotent - "idempotent": true имеет смысл только там, где принимается POST
+func checkIdempotent(apiName string, handler *HttpHandlerData) error {
+	if handler.Params.Idempotent && handler.Params.Method != "" && handler.Params.Method != http.MethodPost {
+		return fmt.Errorf("%s.%s: idempotent is allowed only for POST endpoints", apiName, handler.Name)
+	}
+	return nil
+}
+
+// idempotencyRuntime - запоминаем первый ответ на каждый Idempotency-Key и повторяем его на ретраях.
+// Хранилище подменяется полем IdempotencyStore в API-структуре
+var (
+	idempotencyRuntime = template.Must(template.New("idempotencyRuntime").Parse(`
+// Сколько помним ответ на ключ
+const idempotencyTTL = 24 * time.Hour
+
+// IdempotencyRecord - ответ, сохранённый по ключу. Status 0 - первый запрос ещё выполняется
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	Body        []byte
+}
+
+// IdempotencyStore хранит ответы по ключам идемпотентности
+type IdempotencyStore interface {
+	// Reserve занимает ключ под новый запрос. Если ключ уже занят - возвращает его запись и false
+	Reserve(key string, fingerprint string, ttl time.Duration) (IdempotencyRecord, bool)
+	// Complete сохраняет ответ на занятый ключ
+	Complete(key string, status int, body []byte)
+	// Release освобождает ключ, если запрос не удался и его можно повторить
+	Release(key string)
+}
+
+type idempotencyEntry struct {
+	record  IdempotencyRecord
+	expires time.Time
+}
+
+// InMemoryIdempotencyStore - ключи в памяти процесса, нулевое значение готово к работе
+type InMemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+func (s *InMemoryIdempotencyStore) Reserve(key string, fingerprint string, ttl time.Duration) (IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.entries == nil {
+		s.entries = map[string]*idempotencyEntry{}
+	}
+	for k, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, k)
+		}
+	}
+
+	if entry, ok := s.entries[key]; ok {
+		return entry.record, false
+	}
+	s.entries[key] = &idempotencyEntry{IdempotencyRecord{Fingerprint: fingerprint}, now.Add(ttl)}
+	return IdempotencyRecord{}, true
+}
+
+func (s *InMemoryIdempotencyStore) Complete(key string, status int, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok {
+		entry.record.Status = status
+		entry.record.Body = body
+	}
+}
+
+func (s *InMemoryIdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+}
+
+var defaultIdempotencyStore = &InMemoryIdempotencyStore{}
+
+func idempotencyStoreOrDefault(store IdempotencyStore) IdempotencyStore {
+	if store == nil {
+		return defaultIdempotencyStore
+	}
+	return store
+}
+
+// idempotencyCaller - чьи ключи: проверенный вызывающий, а не сам токен.
+// Токен меняется при refresh, а ретрай с новым должен получить тот же ответ; к тому же токен - секрет
+func idempotencyCaller(r *http.Request) string {
+	if principal := PrincipalFromContext(r.Context()); principal != "" {
+		return "principal:" + principal
+	}
+	if token := r.Header.Get("X-Auth"); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "auth:" + hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+// idempotencyFingerprint - по нему отличаем ретрай от другого запроса с тем же ключом
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n" + string(body)))
+	return hex.EncodeToString(sum[:])
+}
+
+// replayIdempotent отвечает на повторный ключ: сохранённым ответом, 409 или 422
+func replayIdempotent(w http.ResponseWriter, record IdempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		response(w, &ApiError{http.StatusUnprocessableEntity, errors.New("idempotency key is already used with a different payload")}, nil)
+	case record.Status == 0:
+		response(w, &ApiError{http.StatusConflict, errors.New("request with this idempotency key is in progress")}, nil)
+	default:
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Status)
+		w.Write(record.Body)
+	}
+}
+
+// idempotencyRecorder копит ответ, чтобы сохранить его по ключу
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (w *idempotencyRecorder) WriteHeader(status int) {
+	if !w.wrote {
+		w.status = status
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotencyRecorder) Write(data []byte) (int, error) {
+	if !w.wrote {
+		w.status = http.StatusOK
+		w.wrote = true
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish сохраняет ответ; ошибки сервера и паники (ответа ещё нет) ключ освобождают, чтобы запрос можно было повторить
+func (w *idempotencyRecorder) finish(store IdempotencyStore, key string) {
+	if !w.wrote || w.status >= http.StatusInternalServerError {
+		store.Release(key)
+		return
+	}
+	store.Complete(key, w.status, w.body.Bytes())
+}
+`))
+)
@@ -0,0 +1,284 @@
+package main
+
+import "text/template"
+
+// jsonRPCRuntime - общая для всех API-структур часть JSON-RPC 2.0: разбор запросов,
+// батчи и уведомления. Сами методы диспатчит сгенерированный callJSONRPC
+var (
+	jsonRPCRuntime = template.Must(template.New("jsonRPCRuntime").Parse(`
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCServerError    = -32000
+	jsonRPCUnauthorized   = -32001
+	jsonRPCRateLimited    = -32002
+)
+
+type jsonRPCRequest struct {
+	JSONRPC string          ` + "`json:\"jsonrpc\"`" + `
+	Method  string          ` + "`json:\"method\"`" + `
+	Params  json.RawMessage ` + "`json:\"params\"`" + `
+	ID      json.RawMessage ` + "`json:\"id\"`" + `
+}
+
+type jsonRPCError struct {
+	Code    int         ` + "`json:\"code\"`" + `
+	Message string      ` + "`json:\"message\"`" + `
+	Data    interface{} ` + "`json:\"data,omitempty\"`" + `
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          ` + "`json:\"jsonrpc\"`" + `
+	Result  json.RawMessage ` + "`json:\"result,omitempty\"`" + `
+	Error   *jsonRPCError   ` + "`json:\"error,omitempty\"`" + `
+	ID      json.RawMessage ` + "`json:\"id\"`" + `
+}
+
+type jsonRPCCall func(w http.ResponseWriter, r *http.Request, method string, rawParams json.RawMessage) (interface{}, *jsonRPCError)
+
+// jsonRPCStatus - http-статус, которым ошибка закончилась бы в обычном обработчике
+func jsonRPCStatus(status int) interface{} {
+	return map[string]int{"status": status}
+}
+
+func serveJSONRPC(w http.ResponseWriter, r *http.Request, call jsonRPCCall) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeJSONRPC(w, jsonRPCFailure(nil, &jsonRPCError{jsonRPCInvalidRequest, "only POST is allowed", nil}))
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if !json.Valid(body) {
+		writeJSONRPC(w, jsonRPCFailure(nil, &jsonRPCError{jsonRPCParseError, "parse error", nil}))
+		return
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		resp := handleJSONRPC(w, r, body, call)
+		if resp == nil {
+			// На уведомления не отвечаем
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSONRPC(w, resp)
+		return
+	}
+
+	batch := []json.RawMessage{}
+	json.Unmarshal(body, &batch)
+	if len(batch) == 0 {
+		writeJSONRPC(w, jsonRPCFailure(nil, &jsonRPCError{jsonRPCInvalidRequest, "empty batch", nil}))
+		return
+	}
+
+	responses := []*jsonRPCResponse{}
+	for _, raw := range batch {
+		if resp := handleJSONRPC(w, r, raw, call); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSONRPC(w, responses)
+}
+
+// handleJSONRPC выполняет один вызов; для уведомлений (без id) возвращает nil
+func handleJSONRPC(w http.ResponseWriter, r *http.Request, raw json.RawMessage, call jsonRPCCall) *jsonRPCResponse {
+	req := &jsonRPCRequest{}
+	if err := json.Unmarshal(raw, req); err != nil {
+		return jsonRPCFailure(nil, &jsonRPCError{jsonRPCInvalidRequest, "invalid request", nil})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return jsonRPCFailure(req.ID, &jsonRPCError{jsonRPCInvalidRequest, "invalid request", nil})
+	}
+
+	result, rpcErr := call(w, r, req.Method, req.Params)
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if rpcErr != nil {
+		return jsonRPCFailure(req.ID, rpcErr)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return jsonRPCFailure(req.ID, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(http.StatusInternalServerError)})
+	}
+	return &jsonRPCResponse{JSONRPC: "2.0", Result: data, ID: req.ID}
+}
+
+// jsonRPCMiddlewareWriter - ответ для middleware при вызове по JSON-RPC: заголовки попадают в общий ответ,
+// а статус и тело, если middleware ответил сам, не пишутся поверх JSON-RPC
+type jsonRPCMiddlewareWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *jsonRPCMiddlewareWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *jsonRPCMiddlewareWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// callThroughMiddleware выполняет вызов внутри цепочки middleware эндпоинта. Если middleware
+// не пустил запрос дальше, его ответ становится ошибкой вызова
+func callThroughMiddleware(w http.ResponseWriter, r *http.Request, chain func(http.Handler) http.Handler,
+	call func(w http.ResponseWriter, r *http.Request) (interface{}, *jsonRPCError)) (interface{}, *jsonRPCError) {
+	var result interface{}
+	var rpcErr *jsonRPCError
+	called := false
+	mw := &jsonRPCMiddlewareWriter{ResponseWriter: w}
+	chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		result, rpcErr = call(w, r)
+	})).ServeHTTP(mw, r)
+	if called {
+		return result, rpcErr
+	}
+
+	status := mw.status
+	if status < http.StatusBadRequest {
+		status = http.StatusInternalServerError
+	}
+	message := http.StatusText(status)
+	resp := HTTPResponse{}
+	if json.Unmarshal(mw.body.Bytes(), &resp) == nil && resp.Error != "" {
+		message = resp.Error
+	}
+	return nil, &jsonRPCError{jsonRPCServerError, message, jsonRPCStatus(status)}
+}
+
+// jsonRPCFingerprint - отпечаток уже разобранных params: порядок ключей в json на него не влияет
+func jsonRPCFingerprint(params map[string]string) string {
+	data, _ := json.Marshal(params)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// replayJSONRPCIdempotent - ответ на повторный Idempotency-Key: сохранённый результат, 409 или 422
+func replayJSONRPCIdempotent(w http.ResponseWriter, record IdempotencyRecord, fingerprint string) (interface{}, *jsonRPCError) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		return nil, &jsonRPCError{jsonRPCInvalidParams, "idempotency key is already used with a different payload", jsonRPCStatus(http.StatusUnprocessableEntity)}
+	case record.Status == 0:
+		return nil, &jsonRPCError{jsonRPCServerError, "request with this idempotency key is in progress", jsonRPCStatus(http.StatusConflict)}
+	}
+	saved := jsonRPCResponse{}
+	if err := json.Unmarshal(record.Body, &saved); err != nil {
+		return nil, &jsonRPCError{jsonRPCServerError, err.Error(), jsonRPCStatus(http.StatusInternalServerError)}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	if saved.Error != nil {
+		return nil, saved.Error
+	}
+	return saved.Result, nil
+}
+
+// finishJSONRPCIdempotent сохраняет результат вызова; ошибки сервера ключ освобождают, как и в HTTP
+func finishJSONRPCIdempotent(store IdempotencyStore, key string, result interface{}, rpcErr *jsonRPCError) {
+	status := http.StatusOK
+	if rpcErr != nil {
+		status = http.StatusInternalServerError
+		if data, ok := rpcErr.Data.(map[string]int); ok && data["status"] != 0 {
+			status = data["status"]
+		}
+	}
+	if status >= http.StatusInternalServerError {
+		store.Release(key)
+		return
+	}
+	saved := jsonRPCResponse{Error: rpcErr}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			store.Release(key)
+			return
+		}
+		saved.Result = data
+	}
+	body, _ := json.Marshal(saved)
+	store.Complete(key, status, body)
+}
+
+func jsonRPCFailure(id json.RawMessage, rpcErr *jsonRPCError) *jsonRPCResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &jsonRPCResponse{JSONRPC: "2.0", Error: rpcErr, ID: id}
+}
+
+func writeJSONRPC(w http.ResponseWriter, resp interface{}) {
+	bytes, _ := json.Marshal(resp)
+	w.Write(bytes)
+}
+
+// jsonRPCParamsToMap приводит params (объект по именам или массив по порядку полей)
+// к тому же виду, что queryParamsToMap, чтобы дальше работали те же валидаторы
+func jsonRPCParamsToMap(rawParams json.RawMessage, names []string, strict bool) (map[string]string, error) {
+	values := map[string]string{}
+	raw := map[string]json.RawMessage{}
+
+	trimmed := strings.TrimSpace(string(rawParams))
+	switch {
+	case trimmed == "" || trimmed == "null":
+		return values, nil
+	case strings.HasPrefix(trimmed, "["):
+		list := []json.RawMessage{}
+		if err := json.Unmarshal(rawParams, &list); err != nil {
+			return nil, errors.New("invalid params")
+		}
+		if len(list) > len(names) {
+			return nil, errors.New("too many params, expected at most " + strconv.Itoa(len(names)))
+		}
+		for i, v := range list {
+			raw[names[i]] = v
+		}
+	default:
+		if err := json.Unmarshal(rawParams, &raw); err != nil {
+			return nil, errors.New("params must be an object or an array")
+		}
+	}
+
+	unknown := []string{}
+	for k, v := range raw {
+		if !contains(names, k) {
+			unknown = append(unknown, k)
+			continue
+		}
+
+		var value interface{}
+		json.Unmarshal(v, &value)
+		switch typed := value.(type) {
+		case nil:
+			continue
+		case string:
+			values[k] = typed
+		case float64, bool:
+			values[k] = string(v)
+		default:
+			return nil, errors.New(k + " must be a string or a number")
+		}
+	}
+	if strict && len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.New("unknown params: " + strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+`))
+)
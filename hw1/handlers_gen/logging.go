@@ -0,0 +1,172 @@
+package main
+
+import "text/template"
+
+// requestLogRuntime - общая часть логирования: запоминаем статус ответа и то, что обработчик
+// узнал о запросе (параметры, ошибку валидации), и пишем одну строку slog на запрос.
+// Здесь же разбор паники: лог со стеком и ответ 500
+var (
+	requestLogRuntime = template.Must(template.New("requestLogRuntime").Parse(`
+// apigenRequestInfo заполняет обработчик, а читает обвязка apigenServe
+type apigenRequestInfo struct {
+	Params        map[string]string
+	ValidationErr error
+	Err           error
+}
+
+type apigenRequestInfoKey struct{}
+
+// apigenRequestInfoFrom никогда не возвращает nil, чтобы обработчик работал и без обвязки
+func apigenRequestInfoFrom(ctx context.Context) *apigenRequestInfo {
+	if info, ok := ctx.Value(apigenRequestInfoKey{}).(*apigenRequestInfo); ok {
+		return info
+	}
+	return &apigenRequestInfo{}
+}
+
+// apigenResponseWriter запоминает отданный статус и начат ли уже ответ.
+// Если задан requestID, response добавляет его в конверт с ошибкой
+type apigenResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	requestID   string
+}
+
+func (w *apigenResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status = status
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *apigenResponseWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext - X-Request-ID текущего запроса, пустая строка вне сгенерированного ServeHTTP
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID берёт X-Request-ID клиента или придумывает свой, кладёт его в контекст и в ответ.
+// Чужой id принимаем, только если он не сломает логи: до 128 символов без пробелов и спецсимволов
+func withRequestID(w http.ResponseWriter, r *http.Request) (*http.Request, string) {
+	id := r.Header.Get("X-Request-ID")
+	if !isValidRequestID(id) {
+		id = newTraceID(16)
+	}
+	w.Header().Set("X-Request-ID", id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)), id
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// apigenWriterOf ищет apigenResponseWriter под обёртками, которые умеют Unwrap
+func apigenWriterOf(w http.ResponseWriter) *apigenResponseWriter {
+	for {
+		switch typed := w.(type) {
+		case *apigenResponseWriter:
+			return typed
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = typed.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+const redactedParam = "[REDACTED]"
+
+// redactParams - копия параметров, в которой значения sensitive-полей заменены
+func redactParams(params map[string]string, sensitive []string) map[string]string {
+	redacted := make(map[string]string, len(params))
+	for k, v := range params {
+		if contains(sensitive, k) {
+			v = redactedParam
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+func logApigenRequest(logger *slog.Logger, r *http.Request, endpoint string, status int, latency time.Duration, info *apigenRequestInfo) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{
+		slog.String("request_id", RequestIDFromContext(r.Context())),
+		slog.String("endpoint", endpoint),
+		slog.String("method", r.Method),
+		slog.Int("status", status),
+		slog.Duration("latency", latency),
+	}
+	if info.Params != nil {
+		attrs = append(attrs, slog.Any("params", info.Params))
+	}
+	if info.ValidationErr != nil {
+		attrs = append(attrs, slog.String("validation_error", info.ValidationErr.Error()))
+	}
+	if info.Err != nil {
+		attrs = append(attrs, slog.String("error", info.Err.Error()))
+	}
+	logger.LogAttrs(r.Context(), level, "apigen request", attrs...)
+}
+
+// PanicHook получает панику из обработчика вместе со стеком - например, чтобы отправить её в трекер ошибок
+type PanicHook func(r *http.Request, recovered interface{}, stack []byte)
+
+// handleApigenPanic логирует панику со стеком, зовёт hook и отвечает 500, если ответ ещё не начат.
+// Детали паники клиенту не отдаём
+func handleApigenPanic(w *apigenResponseWriter, r *http.Request, endpoint string, recovered interface{}, logger *slog.Logger, hook PanicHook) error {
+	if recovered == http.ErrAbortHandler {
+		// net/http использует эту панику, чтобы оборвать ответ - не мешаем
+		panic(recovered)
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	stack := debug.Stack()
+	logger.LogAttrs(r.Context(), slog.LevelError, "apigen panic",
+		slog.String("request_id", RequestIDFromContext(r.Context())),
+		slog.String("endpoint", endpoint),
+		slog.String("panic", fmt.Sprint(recovered)),
+		slog.String("stack", string(stack)),
+	)
+	if hook != nil {
+		hook(r, recovered, stack)
+	}
+
+	if !w.wroteHeader {
+		response(w, &ApiError{http.StatusInternalServerError, errors.New("internal error")}, nil)
+	}
+	return fmt.Errorf("panic: %v", recovered)
+}
+`))
+)
@@ -0,0 +1,170 @@
+package main
+
+import "text/template"
+
+// metricsRuntime - счётчики для /_metrics в текстовом формате Prometheus, без внешних зависимостей.
+// На каждую API-структуру заводится свой реестр, общая только реализация
+var (
+	metricsRuntime = template.Must(template.New("metricsRuntime").Parse(`
+// Границы корзин гистограммы задержек, в секундах - как у клиента Prometheus по умолчанию
+var apigenLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type apigenRequestKey struct {
+	Endpoint string
+	Method   string
+	Status   int
+}
+
+type apigenValidationKey struct {
+	Endpoint string
+	Param    string
+}
+
+type apigenHistogram struct {
+	Buckets []uint64 // не накопительные, суммируем при выводе
+	Sum     float64
+	Count   uint64
+}
+
+type apigenMetrics struct {
+	mu         sync.Mutex
+	api        string
+	endpoints  []string
+	requests   map[apigenRequestKey]uint64
+	latency    map[string]*apigenHistogram
+	inFlight   map[string]int64
+	validation map[apigenValidationKey]uint64
+}
+
+func newApigenMetrics(api string, endpoints []string) *apigenMetrics {
+	m := &apigenMetrics{
+		api:        api,
+		endpoints:  endpoints,
+		requests:   map[apigenRequestKey]uint64{},
+		latency:    map[string]*apigenHistogram{},
+		inFlight:   map[string]int64{},
+		validation: map[apigenValidationKey]uint64{},
+	}
+	// Гистограммы и in-flight видны сразу, ещё до первого запроса
+	for _, endpoint := range endpoints {
+		m.latency[endpoint] = &apigenHistogram{Buckets: make([]uint64, len(apigenLatencyBuckets))}
+		m.inFlight[endpoint] = 0
+	}
+	return m
+}
+
+func (m *apigenMetrics) begin(endpoint string) {
+	m.mu.Lock()
+	m.inFlight[endpoint]++
+	m.mu.Unlock()
+}
+
+func (m *apigenMetrics) end(endpoint string, method string, status int, latency time.Duration, info *apigenRequestInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[endpoint]--
+	m.requests[apigenRequestKey{endpoint, metricsMethod(method), status}]++
+
+	hist, ok := m.latency[endpoint]
+	if !ok {
+		// Эндпоинт, которого не было при генерации - например, обработчик вызвали напрямую
+		hist = &apigenHistogram{Buckets: make([]uint64, len(apigenLatencyBuckets))}
+		m.latency[endpoint] = hist
+		m.endpoints = append(m.endpoints, endpoint)
+	}
+	seconds := latency.Seconds()
+	for i, bound := range apigenLatencyBuckets {
+		if seconds <= bound {
+			hist.Buckets[i]++
+			break
+		}
+	}
+	hist.Sum += seconds
+	hist.Count++
+
+	if info.ValidationErr != nil {
+		// Ошибки строгого режима не привязаны к одному параметру
+		param := "_strict"
+		var paramErr *apigenParamError
+		if errors.As(info.ValidationErr, &paramErr) {
+			param = paramErr.Param
+		}
+		m.validation[apigenValidationKey{endpoint, param}]++
+	}
+}
+
+// metricsMethod не даёт клиенту наплодить серий произвольными методами
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *apigenMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(m.String()))
+}
+
+// String - все метрики реестра в текстовом формате Prometheus
+func (m *apigenMetrics) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	api := "api=" + strconv.Quote(m.api)
+	lines := []string{}
+
+	lines = append(lines,
+		"# HELP apigen_requests_total Requests handled by apigen endpoints.",
+		"# TYPE apigen_requests_total counter")
+	series := []string{}
+	for key, value := range m.requests {
+		series = append(series, fmt.Sprintf("apigen_requests_total{%s,endpoint=%q,method=%q,status=\"%d\"} %d",
+			api, key.Endpoint, key.Method, key.Status, value))
+	}
+	sort.Strings(series)
+	lines = append(lines, series...)
+
+	lines = append(lines,
+		"# HELP apigen_request_duration_seconds Latency of apigen endpoints.",
+		"# TYPE apigen_request_duration_seconds histogram")
+	for _, endpoint := range m.endpoints {
+		hist := m.latency[endpoint]
+		labels := fmt.Sprintf("%s,endpoint=%q", api, endpoint)
+		var cumulative uint64
+		for i, bound := range apigenLatencyBuckets {
+			cumulative += hist.Buckets[i]
+			lines = append(lines, fmt.Sprintf("apigen_request_duration_seconds_bucket{%s,le=%q} %d",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative))
+		}
+		lines = append(lines,
+			fmt.Sprintf("apigen_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d", labels, hist.Count),
+			fmt.Sprintf("apigen_request_duration_seconds_sum{%s} %s", labels, strconv.FormatFloat(hist.Sum, 'g', -1, 64)),
+			fmt.Sprintf("apigen_request_duration_seconds_count{%s} %d", labels, hist.Count))
+	}
+
+	lines = append(lines,
+		"# HELP apigen_requests_in_flight Requests currently being handled.",
+		"# TYPE apigen_requests_in_flight gauge")
+	for _, endpoint := range m.endpoints {
+		lines = append(lines, fmt.Sprintf("apigen_requests_in_flight{%s,endpoint=%q} %d", api, endpoint, m.inFlight[endpoint]))
+	}
+
+	lines = append(lines,
+		"# HELP apigen_validation_failures_total Requests rejected by parameter validation.",
+		"# TYPE apigen_validation_failures_total counter")
+	series = []string{}
+	for key, value := range m.validation {
+		series = append(series, fmt.Sprintf("apigen_validation_failures_total{%s,endpoint=%q,param=%q} %d",
+			api, key.Endpoint, key.Param, value))
+	}
+	sort.Strings(series)
+	lines = append(lines, series...)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+`))
+)
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Описание OpenAPI 3 документа - только то, что нам нужно
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]*openAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type openAPISecurityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in"`
+	Name string `json:"name"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+	// Роли, которым можно звать эндпоинт - расширение, в OpenAPI такого поля нет
+	Roles []string `json:"x-roles,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref,omitempty"`
+	Type       string                    `json:"type,omitempty"`
+	Format     string                    `json:"format,omitempty"`
+	Enum       []interface{}             `json:"enum,omitempty"`
+	Default    interface{}               `json:"default,omitempty"`
+	Minimum    *int                      `json:"minimum,omitempty"`
+	Maximum    *int                      `json:"maximum,omitempty"`
+	MinLength  *int                      `json:"minLength,omitempty"`
+	MaxLength  *int                      `json:"maxLength,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+	Properties map[string]*openAPISchema `json:"properties,omitempty"`
+	Items      *openAPISchema            `json:"items,omitempty"`
+}
+
+const (
+	openAPISecuritySchemeName = "XAuth"
+	openAPIErrorSchemaName    = "ErrorResponse"
+)
+
+// writeOpenAPI пишет в dir по документу на каждую API-структуру:
+// урлы у разных структур могут совпадать, поэтому в один документ их не сложить
+func writeOpenAPI(dir string, format string, src *ApiSource) error {
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("unknown openapi format %q", format)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, apiName := range src.Handlers.StructNames() {
+		doc := buildOpenAPI(apiName, src.Handlers[apiName], src)
+
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		if format == "yaml" {
+			data, err = jsonToYAML(data)
+			if err != nil {
+				return err
+			}
+		}
+
+		path := filepath.Join(dir, apiName+".openapi."+format)
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildOpenAPI(apiName string, handlers []*HttpHandlerData, src *ApiSource) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: apiName, Version: "1.0.0"},
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{
+				openAPIErrorSchemaName: {
+					Type:     "object",
+					Required: []string{"error"},
+					Properties: map[string]*openAPISchema{
+						"error": {Type: "string"},
+						// есть только при apigen:struct {"request_id_in_errors": true}
+						"request_id": {Type: "string"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, handler := range handlers {
+		if handler.Params.Auth {
+			doc.Components.SecuritySchemes = map[string]*openAPISecurityScheme{
+				openAPISecuritySchemeName: {Type: "apiKey", In: "header", Name: "X-Auth"},
+			}
+		}
+
+		// Без ограничения по методу обработчик принимает и GET, и POST
+		methods := []string{handler.Params.Method}
+		if handler.Params.Method == "" {
+			methods = []string{http.MethodGet, http.MethodPost}
+		}
+
+		if doc.Paths[handler.Params.Url] == nil {
+			doc.Paths[handler.Params.Url] = map[string]*openAPIOperation{}
+		}
+		for _, method := range methods {
+			op := buildOpenAPIOperation(handler, method, len(methods) > 1, doc, src)
+			doc.Paths[handler.Params.Url][strings.ToLower(method)] = op
+		}
+	}
+
+	return doc
+}
+
+func buildOpenAPIOperation(handler *HttpHandlerData, method string, suffixID bool, doc *openAPIDocument, src *ApiSource) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: handler.Name,
+		Responses:   map[string]*openAPIResponse{},
+	}
+	if suffixID {
+		op.OperationID += method[:1] + strings.ToLower(method[1:])
+	}
+
+	if method == http.MethodPost {
+		body := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+		for _, field := range handler.ParamFields {
+			rules := field.Rules()
+			body.Properties[rules.ParamName] = paramSchema(field)
+			// Обязателен всякий параметр, без которого сервер ответит 400, а не только required
+			if !field.Omittable() {
+				body.Required = append(body.Required, rules.ParamName)
+			}
+		}
+		op.RequestBody = &openAPIRequestBody{
+			Required: len(body.Required) > 0,
+			Content: map[string]*openAPIMediaType{
+				"application/x-www-form-urlencoded": {Schema: body},
+			},
+		}
+	} else {
+		for _, field := range handler.ParamFields {
+			rules := field.Rules()
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name:     rules.ParamName,
+				In:       "query",
+				Required: !field.Omittable(),
+				Schema:   paramSchema(field),
+			})
+		}
+	}
+
+	if handler.Params.Auth {
+		op.Security = []map[string][]string{{openAPISecuritySchemeName: {}}}
+		op.Roles = handler.AllowedRoles
+	}
+
+	okSchema := &openAPISchema{
+		Type:       "object",
+		Properties: map[string]*openAPISchema{"error": {Type: "string"}},
+	}
+	if handler.ResultTypeName != "" {
+		okSchema.Properties["response"] = typeSchema(&ast.Ident{Name: handler.ResultTypeName}, doc, src)
+	}
+	op.Responses["200"] = &openAPIResponse{
+		Description: http.StatusText(http.StatusOK),
+		Content:     map[string]*openAPIMediaType{"application/json": {Schema: okSchema}},
+	}
+
+	// Статусы, которые может вернуть сгенерированный код, плюс те, что метод возвращает сам через ApiError
+	statuses := []int{http.StatusInternalServerError}
+	if len(handler.ParamFields) > 0 || handler.Params.Strict {
+		statuses = append(statuses, http.StatusBadRequest)
+	}
+	if handler.Params.Auth {
+		statuses = append(statuses, http.StatusForbidden)
+	}
+	if handler.Params.Method != "" {
+		statuses = append(statuses, http.StatusNotAcceptable)
+	}
+	if handler.Params.RateLimit != nil {
+		statuses = append(statuses, http.StatusTooManyRequests)
+	}
+	statuses = append(statuses, handler.ErrorStatuses...)
+	if handler.Params.Idempotent && method == http.MethodPost {
+		op.Parameters = append(op.Parameters, &openAPIParameter{
+			Name:   "Idempotency-Key",
+			In:     "header",
+			Schema: &openAPISchema{Type: "string"},
+		})
+		statuses = append(statuses, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+	if handler.Params.Cache != nil {
+		// Ответ на If-None-Match без тела
+		op.Responses[strconv.Itoa(http.StatusNotModified)] = &openAPIResponse{Description: http.StatusText(http.StatusNotModified)}
+	}
+
+	for _, status := range statuses {
+		op.Responses[strconv.Itoa(status)] = &openAPIResponse{
+			Description: http.StatusText(status),
+			Content: map[string]*openAPIMediaType{
+				"application/json": {Schema: &openAPISchema{Ref: "#/components/schemas/" + openAPIErrorSchemaName}},
+			},
+		}
+	}
+
+	return op
+}
+
+// paramSchema переводит правила apivalidator в ключевые слова JSON Schema
+func paramSchema(field ParamField) *openAPISchema {
+	rules := field.Rules()
+	schema := &openAPISchema{Type: "string"}
+	isInt := field.Type == "int"
+	if isInt {
+		schema.Type = "integer"
+	}
+
+	if isInt {
+		schema.Minimum = rules.Min
+		schema.Maximum = rules.Max
+	} else {
+		schema.MinLength = rules.Min
+		schema.MaxLength = rules.Max
+		// Секреты клиенты и UI показывают скрытыми
+		if rules.Sensitive {
+			schema.Format = "password"
+		}
+	}
+
+	for _, v := range rules.Enum {
+		schema.Enum = append(schema.Enum, v)
+	}
+
+	if rules.HasDefault {
+		schema.Default = rules.Default
+		if isInt {
+			if num, err := strconv.Atoi(rules.Default); err == nil {
+				schema.Default = num
+			}
+		}
+	}
+
+	return schema
+}
+
+// typeSchema описывает go-тип; структуры из разбираемого файла попадают в components
+func typeSchema(expr ast.Expr, doc *openAPIDocument, src *ApiSource) *openAPISchema {
+	switch xv := expr.(type) {
+	case *ast.StarExpr:
+		return typeSchema(xv.X, doc, src)
+	case *ast.ArrayType:
+		return &openAPISchema{Type: "array", Items: typeSchema(xv.Elt, doc, src)}
+	case *ast.MapType:
+		return &openAPISchema{Type: "object"}
+	case *ast.SelectorExpr:
+		if pkg, ok := xv.X.(*ast.Ident); ok && pkg.Name == "time" && xv.Sel.Name == "Time" {
+			return &openAPISchema{Type: "string", Format: "date-time"}
+		}
+		return &openAPISchema{Type: "object"}
+	case *ast.Ident:
+		switch xv.Name {
+		case "string":
+			return &openAPISchema{Type: "string"}
+		case "bool":
+			return &openAPISchema{Type: "boolean"}
+		case "float32":
+			return &openAPISchema{Type: "number", Format: "float"}
+		case "float64":
+			return &openAPISchema{Type: "number", Format: "double"}
+		case "int8", "int16", "int32", "uint8", "uint16", "uint32":
+			return &openAPISchema{Type: "integer", Format: "int32"}
+		case "int", "int64", "uint", "uint64":
+			return &openAPISchema{Type: "integer", Format: "int64"}
+		}
+
+		structType, ok := src.Structs[xv.Name]
+		if !ok {
+			return &openAPISchema{Type: "object"}
+		}
+		if _, ok := doc.Components.Schemas[xv.Name]; !ok {
+			// Сначала занимаем имя, чтобы не зациклиться на рекурсивных типах
+			schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+			doc.Components.Schemas[xv.Name] = schema
+			for _, field := range structType.Fields.List {
+				for _, name := range field.Names {
+					jsonName, omitEmpty, skip := jsonFieldName(name.Name, field)
+					if skip {
+						continue
+					}
+					schema.Properties[jsonName] = typeSchema(field.Type, doc, src)
+					if !omitEmpty {
+						schema.Required = append(schema.Required, jsonName)
+					}
+				}
+			}
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + xv.Name}
+	}
+
+	return &openAPISchema{Type: "object"}
+}
+
+// jsonFieldName повторяет то, как encoding/json назовёт поле
+func jsonFieldName(fieldName string, field *ast.Field) (name string, omitEmpty bool, skip bool) {
+	if !ast.IsExported(fieldName) {
+		return "", false, true
+	}
+	name = fieldName
+	if field.Tag == nil {
+		return
+	}
+
+	tags, _ := strconv.Unquote(field.Tag.Value)
+	jsonTag, ok := reflect.StructTag(tags).Lookup("json")
+	if !ok {
+		return
+	}
+	if jsonTag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(jsonTag, ",")
+	if parts[0] != "" {
+		name = parts[0]
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return
+}
+
+// jsonToYAML перекладывает json в yaml, сохраняя порядок ключей.
+// Строки остаются в json-кавычках - это валидные yaml-скаляры
+func jsonToYAML(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	value, err := readOrderedJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.Join(yamlLines(value), "\n")), nil
+}
+
+type orderedKV struct {
+	Key   string
+	Value interface{}
+}
+
+type orderedObject []orderedKV
+
+func readOrderedJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+
+	switch delim {
+	case '{':
+		obj := orderedObject{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, orderedKV{keyTok.(string), value})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case '[':
+		list := []interface{}{}
+		for dec.More() {
+			value, err := readOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	}
+
+	return nil, io.ErrUnexpectedEOF
+}
+
+var yamlPlainKey = regexp.MustCompile(`^[A-Za-z_$/][A-Za-z0-9_$/.\-]*$`)
+
+func yamlLines(value interface{}) []string {
+	lines := []string{}
+
+	switch xv := value.(type) {
+	case orderedObject:
+		for _, kv := range xv {
+			key := kv.Key
+			if !yamlPlainKey.MatchString(key) {
+				key = yamlScalar(key)
+			}
+			if isYAMLScalar(kv.Value) {
+				lines = append(lines, key+": "+yamlScalar(kv.Value))
+				continue
+			}
+			lines = append(lines, key+":")
+			for _, line := range yamlLines(kv.Value) {
+				lines = append(lines, "  "+line)
+			}
+		}
+	case []interface{}:
+		for _, item := range xv {
+			if isYAMLScalar(item) {
+				lines = append(lines, "- "+yamlScalar(item))
+				continue
+			}
+			for i, line := range yamlLines(item) {
+				if i == 0 {
+					lines = append(lines, "- "+line)
+				} else {
+					lines = append(lines, "  "+line)
+				}
+			}
+		}
+	}
+
+	return lines
+}
+
+// isYAMLScalar - пустые объекты и списки тоже пишем в одну строку
+func isYAMLScalar(value interface{}) bool {
+	switch xv := value.(type) {
+	case orderedObject:
+		return len(xv) == 0
+	case []interface{}:
+		return len(xv) == 0
+	}
+	return true
+}
+
+func yamlScalar(value interface{}) string {
+	switch xv := value.(type) {
+	case orderedObject:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	case nil:
+		return "null"
+	case string:
+		quoted, _ := json.Marshal(xv)
+		return string(quoted)
+	}
+	return fmt.Sprint(value)
+}
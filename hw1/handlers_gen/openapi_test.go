@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// go test -update переписывает эталоны в testdata по текущему выводу генератора
+var updateGolden = flag.Bool("update", false, "переписать эталонные файлы в testdata")
+
+func parseTestApi(t *testing.T) *ApiSource {
+	t.Helper()
+	src, err := parseApiFile("testdata/shop_api.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func checkGolden(t *testing.T, golden string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", golden)
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("%s differs from generated output, rerun with -update if the change is intended:\n%s", path, got)
+	}
+}
+
+func TestOpenAPIGolden(t *testing.T) {
+	src := parseTestApi(t)
+	dir := t.TempDir()
+	for _, format := range []string{"json", "yaml"} {
+		if err := writeOpenAPI(dir, format, src); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, "ShopApi.openapi."+format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "ShopApi.openapi."+format, got)
+	}
+
+	if err := writeOpenAPI(dir, "xml", src); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestOpenAPIParams(t *testing.T) {
+	src := parseTestApi(t)
+	doc := buildOpenAPI("ShopApi", src.Handlers["ShopApi"], src)
+
+	// Сгенерированный сервер читает тело только у POST - у остальных методов параметры в query
+	del := doc.Paths["/item/delete"]["delete"]
+	if del == nil || del.RequestBody != nil || len(del.Parameters) != 1 || del.Parameters[0].In != "query" {
+		t.Fatalf("expected DELETE params in query, got %+v", del)
+	}
+	if len(del.Security) == 0 {
+		t.Errorf("expected security on auth endpoint")
+	}
+
+	create := doc.Paths["/item/create"]["post"]
+	if create == nil || create.RequestBody == nil || len(create.Parameters) != 0 {
+		t.Fatalf("expected POST params in body, got %+v", create)
+	}
+	body := create.RequestBody.Content["application/x-www-form-urlencoded"].Schema
+	// обязательны и required, и всё, без чего сервер ответит 400: строка с min, int без default
+	if !reflect.DeepEqual(body.Required, []string{"name", "secret", "price"}) {
+		t.Errorf("expected name, secret and price required, got %v", body.Required)
+	}
+	if body.Properties["secret"].Format != "password" {
+		t.Errorf("expected sensitive param with format password, got %+v", body.Properties["secret"])
+	}
+	if body.Properties["item_count"].Default != 1 {
+		t.Errorf("expected int default 1, got %#v", body.Properties["item_count"].Default)
+	}
+}
+
+// jsonToYAML и parseYAML - пара: yaml-документ читается обратно в то же, что и json
+func TestOpenAPIYAMLRoundTrip(t *testing.T) {
+	src := parseTestApi(t)
+	data, err := json.MarshalIndent(buildOpenAPI("ShopApi", src.Handlers["ShopApi"], src), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	yaml, err := jsonToYAML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected interface{}
+	json.Unmarshal(data, &expected)
+	got, err := parseYAML(yaml)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, yaml)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("yaml round trip differs:\n%s", yaml)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// RateLimitParams - "ratelimit" в apigen:api: {"rps": 5, "burst": 10, "key": "ip"}
+type RateLimitParams struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+	// ip, auth или header:X-Client - по чему делить клиентов
+	Key string `json:"key"`
+}
+
+// check проверяет настройки и подставляет значения по умолчанию.
+// Ключ auth считается по проверенному токену, поэтому нужен "auth": true
+func (rl *RateLimitParams) check(auth bool) error {
+	if rl.RPS <= 0 {
+		return fmt.Errorf("ratelimit: rps must be > 0")
+	}
+	if rl.Burst < 0 {
+		return fmt.Errorf("ratelimit: burst must be >= 0")
+	}
+	if rl.Burst == 0 {
+		rl.Burst = int(rl.RPS)
+		if rl.Burst < 1 {
+			rl.Burst = 1
+		}
+	}
+	switch {
+	case rl.Key == "":
+		rl.Key = "ip"
+	case rl.Key == "auth" && !auth:
+		return fmt.Errorf("ratelimit: key auth requires \"auth\": true")
+	case rl.Key == "ip" || rl.Key == "auth":
+	case strings.HasPrefix(rl.Key, "header:") && len(rl.Key) > len("header:"):
+	default:
+		return fmt.Errorf("ratelimit: unknown key %q, expected ip, auth or header:Name", rl.Key)
+	}
+	return nil
+}
+
+// rateLimitRuntime - token bucket и хранилище вёдер. Хранилище подменяется полем
+// RateLimiter в API-структуре, например на общее для нескольких инстансов
+var (
+	rateLimitRuntime = template.Must(template.New("rateLimitRuntime").Parse(`
+// RateLimiterStore хранит token bucket'ы клиентов
+type RateLimiterStore interface {
+	// Allow забирает токен из ведра key. Если токенов нет - возвращает, через сколько появится следующий
+	Allow(key string, rps float64, burst int) (bool, time.Duration)
+}
+
+// tokenBucket помнит свои rps и burst: в одном хранилище лежат вёдра эндпоинтов с разными лимитами
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rps    float64
+	burst  int
+}
+
+// InMemoryRateLimiterStore - вёдра в памяти процесса, нулевое значение готово к работе
+type InMemoryRateLimiterStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func (s *InMemoryRateLimiterStore) Allow(key string, rps float64, burst int) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.buckets == nil {
+		s.buckets = map[string]*tokenBucket{}
+	}
+	// Полные вёдра ничем не отличаются от новых - выкидываем, чтобы не копить клиентов
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, b := range s.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*b.rps >= float64(b.burst) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	b.rps, b.burst = rps, burst
+	b.tokens += now.Sub(b.last).Seconds() * rps
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rps * float64(time.Second))
+}
+
+var defaultRateLimiterStore = &InMemoryRateLimiterStore{}
+
+// rateLimitKey - клиент, по которому считается лимит. Без заголовка считаем по ip.
+// auth зовётся только после успешной проверки X-Auth: иначе лимит обходится случайными токенами
+func rateLimitKey(r *http.Request, kind string) string {
+	switch {
+	case kind == "auth" && PrincipalFromContext(r.Context()) != "":
+		return "principal:" + PrincipalFromContext(r.Context())
+	case kind == "auth" && r.Header.Get("X-Auth") != "":
+		// Сам токен - секрет, в памяти храним только его хэш
+		sum := sha256.Sum256([]byte(r.Header.Get("X-Auth")))
+		return "auth:" + hex.EncodeToString(sum[:])
+	case strings.HasPrefix(kind, "header:") && r.Header.Get(strings.TrimPrefix(kind, "header:")) != "":
+		return kind + ":" + r.Header.Get(strings.TrimPrefix(kind, "header:"))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimited отвечает 429, если лимит исчерпан
+func rateLimited(w http.ResponseWriter, store RateLimiterStore, endpoint string, key string, rps float64, burst int) bool {
+	err, retryAfter := checkRateLimit(store, endpoint, key, rps, burst)
+	if err == nil {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	response(w, &ApiError{http.StatusTooManyRequests, err}, nil)
+	return true
+}
+
+// checkRateLimit возвращает ошибку для ответа 429 и сколько секунд подождать
+func checkRateLimit(store RateLimiterStore, endpoint string, key string, rps float64, burst int) (error, int) {
+	if store == nil {
+		store = defaultRateLimiterStore
+	}
+	ok, wait := store.Allow(endpoint+"|"+key, rps, burst)
+	if ok {
+		return nil, 0
+	}
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	return errors.New("rate limit exceeded, retry after " + strconv.Itoa(retryAfter) + "s"), retryAfter
+}
+`))
+)
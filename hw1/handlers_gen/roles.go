@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// roleNames - роли из apigen:struct {"roles": {...}} по возрастанию уровня
+func roleNames(levels map[string]int) []string {
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if levels[names[i]] != levels[names[j]] {
+			return levels[names[i]] < levels[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// resolveRoles раскрывает "role" (минимальный уровень) и "roles" (перечень) в список ролей,
+// которым можно звать эндпоинт. nil - роль не проверяется
+func resolveRoles(structParams *StructGenParams, handler *HttpHandlerData) ([]string, error) {
+	role, roles := handler.Params.Role, handler.Params.Roles
+	if role == "" && roles == nil {
+		return nil, nil
+	}
+	if role != "" && roles != nil {
+		return nil, fmt.Errorf("role and roles cannot be used together")
+	}
+	if !handler.Params.Auth {
+		return nil, fmt.Errorf("role check requires \"auth\": true")
+	}
+	var levels map[string]int
+	if structParams != nil {
+		levels = structParams.Roles
+	}
+	if len(levels) == 0 {
+		return nil, fmt.Errorf("role check requires roles in apigen:struct, e.g. {\"roles\": {\"user\": 0, \"admin\": 20}}")
+	}
+
+	if role != "" {
+		min, ok := levels[role]
+		if !ok {
+			return nil, fmt.Errorf("unknown role %q, expected one of: %s", role, strings.Join(roleNames(levels), ", "))
+		}
+		allowed := []string{}
+		for _, name := range roleNames(levels) {
+			if levels[name] >= min {
+				allowed = append(allowed, name)
+			}
+		}
+		return allowed, nil
+	}
+
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("roles must not be empty")
+	}
+	for _, name := range roles {
+		if _, ok := levels[name]; !ok {
+			return nil, fmt.Errorf("unknown role %q, expected one of: %s", name, strings.Join(roleNames(levels), ", "))
+		}
+	}
+	// В порядке уровней, а не как написано в аннотации - так стабильнее и в коде, и в матрице
+	allowed := []string{}
+	for _, name := range roleNames(levels) {
+		if contains(roles, name) {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed, nil
+}
+
+// writePermissions пишет матрицу прав в markdown: кто может звать каждый эндпоинт
+func writePermissions(path string, src *ApiSource) error {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "<!-- Code generated by codegen; DO NOT EDIT. -->")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "# Permissions")
+
+	for _, apiName := range src.Handlers.StructNames() {
+		var levels map[string]int
+		if params := src.StructParams[apiName]; params != nil {
+			levels = params.Roles
+		}
+		roles := roleNames(levels)
+		// Без ролей в apigen:struct различаем только, нужен ли токен
+		if len(roles) == 0 {
+			roles = []string{"authenticated"}
+		}
+
+		fmt.Fprintln(buf)
+		fmt.Fprintln(buf, "## "+apiName)
+		fmt.Fprintln(buf)
+		fmt.Fprintln(buf, "| Endpoint | Method | Handler | anonymous | "+strings.Join(roles, " | ")+" |")
+		fmt.Fprintln(buf, "|---|---|---|---|"+strings.Repeat("---|", len(roles)))
+
+		for _, handler := range src.Handlers[apiName] {
+			method := handler.Params.Method
+			if method == "" {
+				method = "ANY"
+			}
+			cells := []string{handler.Params.Url, method, handler.Name, permissionMark(!handler.Params.Auth)}
+			for _, role := range roles {
+				cells = append(cells, permissionMark(handler.AllowedRoles == nil || contains(handler.AllowedRoles, role)))
+			}
+			fmt.Fprintln(buf, "| "+strings.Join(cells, " | ")+" |")
+		}
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func permissionMark(allowed bool) string {
+	if allowed {
+		return "yes"
+	}
+	return "-"
+}
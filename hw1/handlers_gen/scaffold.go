@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var (
+	scaffoldTmp = template.Must(template.New("scaffoldTmp").Parse(`// Каркас API сгенерирован codegen из {{.Spec}}: осталось написать тела методов.
+// Обработчики к нему генерируются как обычно: codegen api.go api_handlers.go
+
+package {{.Package}}
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// вы можете использовать ApiError в коде, который получается в результате генерации
+// считаем что это какая-то общеизвестная структура
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type {{.ApiName}} struct {
+}
+
+func New{{.ApiName}}() *{{.ApiName}} {
+	return &{{.ApiName}}{}
+}
+{{range .Structs}}
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`{{.Tag}}`" + `
+{{- end}}
+}
+{{end}}
+{{- range .Methods}}
+{{- if .Summary}}
+// {{.Summary}}
+{{- end}}
+// apigen:api {{.Annotation}}
+func (srv *{{$.ApiName}}) {{.Name}}(ctx context.Context, in {{.ParamsType}}) (*{{.ResultType}}, error) {
+	return nil, ApiError{http.StatusNotImplemented, fmt.Errorf("not implemented")}
+}
+{{end}}`))
+)
+
+type scaffoldField struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+type scaffoldStruct struct {
+	Name   string
+	Fields []scaffoldField
+}
+
+type scaffoldMethod struct {
+	Name       string
+	Summary    string
+	Annotation string
+	ParamsType string
+	ResultType string
+}
+
+type scaffold struct {
+	doc     map[string]interface{}
+	structs map[string]*scaffoldStruct
+	order   []string
+}
+
+// Методы, которые мы умеем отдавать в apigen - в порядке предпочтения, если на урле их несколько
+var scaffoldMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// writeApiFromOpenAPI - обратный режим: по OpenAPI 3 документу пишет api.go с API-структурой,
+// структурами параметров и ответов и заглушками методов с аннотациями apigen:api
+func writeApiFromOpenAPI(specPath string, outPath string, pkg string) error {
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		return err
+	}
+
+	var spec interface{}
+	switch strings.ToLower(filepath.Ext(specPath)) {
+	case ".yaml", ".yml":
+		spec, err = parseYAML(data)
+	default:
+		err = json.Unmarshal(data, &spec)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", specPath, err)
+	}
+
+	doc, ok := spec.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: not an openapi document", specPath)
+	}
+
+	sc := &scaffold{doc: doc, structs: map[string]*scaffoldStruct{}}
+
+	apiName := goIdent(stringAt(doc, "info", "title"))
+	if apiName == "" {
+		apiName = "Api"
+	}
+	globalAuth := len(listAt(doc, "security")) > 0
+
+	paths := mapAt(doc, "paths")
+	urls := make([]string, 0, len(paths))
+	for url := range paths {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	methods := []scaffoldMethod{}
+	usedNames := map[string]bool{}
+	for _, url := range urls {
+		pathItem := sc.resolve(paths[url])
+
+		available := []string{}
+		for _, m := range scaffoldMethods {
+			if _, ok := pathItem[m]; ok {
+				available = append(available, m)
+			}
+		}
+		if len(available) == 0 {
+			continue
+		}
+
+		// Сгенерированный ServeHTTP разводит запросы только по урлу,
+		// поэтому на урл приходится один метод. Пара get+post - это метод без ограничения
+		method := strings.ToUpper(available[0])
+		merged := len(available) == 2 && available[0] == "get" && available[1] == "post"
+		if merged {
+			method = ""
+		} else if len(available) > 1 {
+			log.Printf("%s: only %s is scaffolded, skipping %s", url, available[0], strings.Join(available[1:], ", "))
+		}
+		if method != "" && method != "GET" && method != "POST" {
+			log.Printf("%s: generated handlers read params from body only for POST, %s gets them from query", url, method)
+		}
+
+		op := sc.resolve(pathItem[available[0]])
+		name := goIdent(stringAt(op, "operationId"))
+		if merged {
+			name = strings.TrimSuffix(name, "Get")
+		}
+		if name == "" {
+			name = goIdent(url)
+		}
+		for base, i := name, 2; usedNames[name]; i++ {
+			name = base + strconv.Itoa(i)
+		}
+		usedNames[name] = true
+
+		auth := globalAuth
+		if security, ok := op["security"]; ok {
+			list, _ := security.([]interface{})
+			auth = len(list) > 0
+		}
+
+		annotation := fmt.Sprintf(`{"url": %q, "auth": %v`, url, auth)
+		if method != "" {
+			annotation += fmt.Sprintf(`, "method": %q`, method)
+		}
+		annotation += "}"
+
+		paramsType := name + "Params"
+		sc.addStruct(&scaffoldStruct{Name: paramsType, Fields: sc.paramFields(pathItem, op)})
+
+		methods = append(methods, scaffoldMethod{
+			Name:       name,
+			Summary:    stringAt(op, "summary"),
+			Annotation: annotation,
+			ParamsType: paramsType,
+			ResultType: sc.resultType(op, name+"Result"),
+		})
+	}
+
+	structs := []*scaffoldStruct{}
+	for _, name := range sc.order {
+		structs = append(structs, sc.structs[name])
+	}
+
+	buf := &bytes.Buffer{}
+	err = scaffoldTmp.Execute(buf, struct {
+		Spec    string
+		Package string
+		ApiName string
+		Structs []*scaffoldStruct
+		Methods []scaffoldMethod
+	}{filepath.Base(specPath), pkg, apiName, structs, methods})
+	if err != nil {
+		return err
+	}
+
+	return writeGoFile(outPath, buf.Bytes())
+}
+
+// paramFields собирает параметры из query и из тела запроса и переводит ограничения схемы в теги apivalidator
+func (sc *scaffold) paramFields(pathItem map[string]interface{}, op map[string]interface{}) []scaffoldField {
+	fields := []scaffoldField{}
+	seen := map[string]bool{}
+	add := func(name string, schema map[string]interface{}, required bool) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		fields = append(fields, scaffoldParamField(name, schema, required))
+	}
+
+	params := append(listAt(pathItem, "parameters"), listAt(op, "parameters")...)
+	for _, raw := range params {
+		param := sc.resolve(raw)
+		if stringAt(param, "in") != "query" {
+			continue
+		}
+		required, _ := param["required"].(bool)
+		add(stringAt(param, "name"), sc.resolve(param["schema"]), required)
+	}
+
+	content := mapAt(sc.resolve(op["requestBody"]), "content")
+	for _, mediaType := range []string{"application/x-www-form-urlencoded", "application/json", "multipart/form-data"} {
+		media, ok := content[mediaType]
+		if !ok {
+			continue
+		}
+		schema := sc.resolve(mapAt(media.(map[string]interface{}), "schema"))
+		required := map[string]bool{}
+		for _, name := range listAt(schema, "required") {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+		props := mapAt(schema, "properties")
+		for _, name := range sortedKeys(props) {
+			add(name, sc.resolve(props[name]), required[name])
+		}
+		break
+	}
+
+	return fields
+}
+
+func scaffoldParamField(name string, schema map[string]interface{}, required bool) scaffoldField {
+	field := scaffoldField{Name: goIdent(name), Type: "string"}
+	isInt := stringAt(schema, "type") == "integer"
+	if isInt {
+		field.Type = "int"
+	} else if t := stringAt(schema, "type"); t != "" && t != "string" {
+		log.Printf("param %s: type %s is not supported by apivalidator, using string", name, t)
+	}
+
+	rules := []string{}
+	if required {
+		rules = append(rules, "required")
+	}
+	if strings.ToLower(field.Name) != name {
+		rules = append(rules, "paramname="+name)
+	}
+	if enum := listAt(schema, "enum"); len(enum) > 0 {
+		values := []string{}
+		for _, v := range enum {
+			values = append(values, scalarString(v))
+		}
+		rules = append(rules, "enum="+strings.Join(values, "|"))
+	}
+	if def, ok := schema["default"]; ok {
+		rules = append(rules, "default="+scalarString(def))
+	}
+
+	minKey, maxKey := "minLength", "maxLength"
+	if isInt {
+		minKey, maxKey = "minimum", "maximum"
+	}
+	if min, ok := schema[minKey].(float64); ok {
+		rules = append(rules, "min="+strconv.Itoa(int(min)))
+		// Необязательная в схеме строка с minLength: пустое значение должно проходить
+		if !isInt && !required && min > 0 {
+			rules = append(rules, "omitempty")
+		}
+	}
+	if max, ok := schema[maxKey].(float64); ok {
+		rules = append(rules, "max="+strconv.Itoa(int(max)))
+	}
+
+	// Пустой тег тоже нужен: без apivalidator поле не разберёт генератор обработчиков
+	field.Tag = `apivalidator:"` + strings.Join(rules, ",") + `"`
+	return field
+}
+
+// resultType достаёт схему успешного ответа; конверт {error, response} разворачиваем
+func (sc *scaffold) resultType(op map[string]interface{}, hint string) string {
+	responses := mapAt(op, "responses")
+	for _, status := range sortedKeys(responses) {
+		if !strings.HasPrefix(status, "2") {
+			continue
+		}
+		content := mapAt(sc.resolve(responses[status]), "content")
+		media, ok := content["application/json"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		schemaRaw := media["schema"]
+		schema := sc.resolve(schemaRaw)
+		props := mapAt(schema, "properties")
+		if _, hasError := props["error"]; hasError {
+			if response, ok := props["response"]; ok {
+				schemaRaw = response
+			}
+		}
+		return sc.goType(schemaRaw, hint)
+	}
+
+	sc.addStruct(&scaffoldStruct{Name: hint})
+	return hint
+}
+
+// goType - go-тип для схемы; объекты становятся структурами с json тегами
+func (sc *scaffold) goType(raw interface{}, hint string) string {
+	schema, _ := raw.(map[string]interface{})
+
+	if ref := stringAt(schema, "$ref"); ref != "" {
+		name := ref[strings.LastIndex(ref, "/")+1:]
+		target := sc.resolve(schema)
+		if stringAt(target, "type") == "object" || target["properties"] != nil {
+			return sc.objectType(goIdent(name), target)
+		}
+		return sc.goType(target, goIdent(name))
+	}
+
+	switch stringAt(schema, "type") {
+	case "integer":
+		if stringAt(schema, "format") == "int64" {
+			return "int64"
+		}
+		return "int"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "string":
+		return "string"
+	case "array":
+		return "[]" + sc.goType(schema["items"], hint+"Item")
+	}
+
+	if schema["properties"] != nil {
+		return sc.objectType(hint, schema)
+	}
+	return "map[string]interface{}"
+}
+
+func (sc *scaffold) objectType(name string, schema map[string]interface{}) string {
+	if _, ok := sc.structs[name]; ok {
+		return name
+	}
+	if strings.Contains(name, "Params") {
+		log.Printf("struct %s: names with Params are treated as params by the handlers generator", name)
+	}
+
+	st := &scaffoldStruct{Name: name}
+	sc.addStruct(st)
+
+	required := map[string]bool{}
+	for _, field := range listAt(schema, "required") {
+		if s, ok := field.(string); ok {
+			required[s] = true
+		}
+	}
+	props := mapAt(schema, "properties")
+	for _, prop := range sortedKeys(props) {
+		tag := `json:"` + prop
+		if !required[prop] {
+			tag += ",omitempty"
+		}
+		st.Fields = append(st.Fields, scaffoldField{
+			Name: goIdent(prop),
+			Type: sc.goType(props[prop], name+goIdent(prop)),
+			Tag:  tag + `"`,
+		})
+	}
+	return name
+}
+
+func (sc *scaffold) addStruct(st *scaffoldStruct) {
+	if _, ok := sc.structs[st.Name]; ok {
+		return
+	}
+	sc.structs[st.Name] = st
+	sc.order = append(sc.order, st.Name)
+}
+
+// resolve разворачивает локальные $ref вида #/components/schemas/User
+func (sc *scaffold) resolve(raw interface{}) map[string]interface{} {
+	node, _ := raw.(map[string]interface{})
+	for depth := 0; depth < 32; depth++ {
+		ref := stringAt(node, "$ref")
+		if !strings.HasPrefix(ref, "#/") {
+			return node
+		}
+		var cur interface{} = sc.doc
+		for _, part := range strings.Split(ref[2:], "/") {
+			part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+			m, _ := cur.(map[string]interface{})
+			cur = m[part]
+		}
+		node, _ = cur.(map[string]interface{})
+	}
+	return node
+}
+
+func mapAt(node map[string]interface{}, keys ...string) map[string]interface{} {
+	cur := node
+	for _, k := range keys {
+		cur, _ = cur[k].(map[string]interface{})
+	}
+	return cur
+}
+
+func listAt(node map[string]interface{}, key string) []interface{} {
+	list, _ := node[key].([]interface{})
+	return list
+}
+
+func stringAt(node map[string]interface{}, keys ...string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	s, _ := mapAt(node, keys[:len(keys)-1]...)[keys[len(keys)-1]].(string)
+	return s
+}
+
+func scalarString(v interface{}) string {
+	if num, ok := v.(float64); ok {
+		return strconv.FormatFloat(num, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Аббревиатуры, которые в go принято писать заглавными
+var goInitialisms = map[string]string{
+	"id": "ID", "url": "URL", "uri": "URI", "api": "API", "http": "HTTP", "json": "JSON", "ip": "IP",
+}
+
+// goIdent делает экспортируемое go-имя: full_name -> FullName, /user/profile -> UserProfile
+func goIdent(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	name := ""
+	for _, word := range words {
+		if upper, ok := goInitialisms[strings.ToLower(word)]; ok {
+			name += upper
+			continue
+		}
+		name += strings.ToUpper(word[:1]) + word[1:]
+	}
+	if name != "" && unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Правила, которые переживают путь api.go -> OpenAPI -> api.go. sensitive и classes в схеме не выражаются
+type scaffoldRules struct {
+	Omittable  bool
+	Min        *int
+	Max        *int
+	Enum       []string
+	Default    string
+	HasDefault bool
+}
+
+func scaffoldSummary(src *ApiSource, apiName string) map[string]map[string]scaffoldRules {
+	summary := map[string]map[string]scaffoldRules{}
+	for _, handler := range src.Handlers[apiName] {
+		key := handler.Params.Method + " " + handler.Params.Url + " " + handler.Name
+		if handler.Params.Auth {
+			key += " auth"
+		}
+		params := map[string]scaffoldRules{}
+		for _, field := range handler.ParamFields {
+			rules := field.Rules()
+			params[field.Type+" "+rules.ParamName] = scaffoldRules{field.Omittable(), rules.Min, rules.Max, rules.Enum, rules.Default, rules.HasDefault}
+		}
+		summary[key] = params
+	}
+	return summary
+}
+
+func TestScaffoldRoundTrip(t *testing.T) {
+	src := parseTestApi(t)
+	dir := t.TempDir()
+	for _, format := range []string{"json", "yaml"} {
+		if err := writeOpenAPI(dir, format, src); err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "api_"+format+".go")
+		if err := writeApiFromOpenAPI(filepath.Join(dir, "ShopApi.openapi."+format), out, "shop"); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		scaffolded, err := parseApiFile(out)
+		if err != nil {
+			data, _ := os.ReadFile(out)
+			t.Fatalf("%s: %v\n%s", format, err, data)
+		}
+		expected, got := scaffoldSummary(src, "ShopApi"), scaffoldSummary(scaffolded, "ShopApi")
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: scaffold differs from the source api:\nexpected %+v\ngot      %+v", format, expected, got)
+		}
+	}
+}
+
+func TestScaffoldGolden(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "api.go")
+	if err := writeApiFromOpenAPI("testdata/ShopApi.openapi.yaml", out, "shop"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "shop_api_scaffold.go.golden", got)
+}
@@ -0,0 +1,50 @@
+// Небольшой API для тестов генератора: GET, POST и DELETE, правила apivalidator всех видов
+
+package main
+
+import "context"
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type ShopApi struct {
+}
+
+type ItemParams struct {
+	ID int `apivalidator:"required,min=1,max=1000"`
+}
+
+type CreateItemParams struct {
+	Name   string `apivalidator:"required,min=2,max=20"`
+	Kind   string `apivalidator:"enum=book|toy,default=book"`
+	Count  int    `apivalidator:"paramname=item_count,min=0,default=1"`
+	Secret string `apivalidator:"min=8,sensitive"`
+	Price  int    `apivalidator:"min=0"`
+	Note   string `apivalidator:"min=3,omitempty"`
+}
+
+type Item struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// apigen:api {"url": "/item", "auth": false, "method": "GET"}
+func (srv *ShopApi) Get(ctx context.Context, in ItemParams) (*Item, error) {
+	return nil, nil
+}
+
+// apigen:api {"url": "/item/create", "auth": true, "method": "POST"}
+func (srv *ShopApi) Create(ctx context.Context, in CreateItemParams) (*Item, error) {
+	return nil, nil
+}
+
+// apigen:api {"url": "/item/delete", "auth": true, "method": "DELETE"}
+func (srv *ShopApi) Delete(ctx context.Context, in ItemParams) (*Item, error) {
+	return nil, nil
+}
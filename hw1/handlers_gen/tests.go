@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	testsHelpersTmp = template.Must(template.New("testsHelpersTmp").Parse(`// Code generated by codegen; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const apigenAuthToken = "{{.AuthToken}}"
+
+// apigenCase - один запрос к ServeHTTP и ожидаемый ответ.
+// ExpectValid означает, что запрос должен пройти валидацию: статус любой, кроме 400
+type apigenCase struct {
+	Name        string
+	Method      string
+	Path        string
+	Query       string
+	Auth        bool
+	Status      int
+	Error       string
+	ExpectValid bool
+}
+
+func runApigenCases(t *testing.T, newHandler func() http.Handler, cases []apigenCase) {
+	for _, item := range cases {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			var req *http.Request
+			if item.Method == http.MethodPost {
+				req = httptest.NewRequest(item.Method, item.Path, strings.NewReader(item.Query))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(item.Method, item.Path+"?"+item.Query, nil)
+			}
+			if item.Auth {
+				req.Header.Set("X-Auth", apigenAuthToken)
+			}
+
+			w := httptest.NewRecorder()
+			newHandler().ServeHTTP(w, req)
+
+			result := struct {
+				Error string ` + "`json:\"error\"`" + `
+			}{}
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("cant unpack json: %v, body: %s", err, w.Body.String())
+			}
+
+			if item.ExpectValid {
+				if w.Code == http.StatusBadRequest {
+					t.Errorf("expected request to pass validation, got %v: %s", w.Code, result.Error)
+				}
+				return
+			}
+			if w.Code != item.Status {
+				t.Errorf("expected http status %v, got %v", item.Status, w.Code)
+			}
+			if result.Error != item.Error {
+				t.Errorf("expected error %q, got %q", item.Error, result.Error)
+			}
+		})
+	}
+}
+`))
+
+	testsApiTmp = template.Must(template.New("testsApiTmp").Parse(`// Code generated by codegen; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test{{.ApiName}}Generated(t *testing.T) {
+	cases := []apigenCase{
+	{{- range .Cases}}
+		{
+			Name:   {{printf "%q" .Name}},
+			Method: {{printf "%q" .Method}},
+			Path:   {{printf "%q" .Path}},
+			Query:  {{printf "%q" .Query}},
+			Auth:   {{.Auth}},
+			{{- if .ExpectValid}}
+			ExpectValid: true,
+			{{- else}}
+			Status: {{.Status}},
+			Error:  {{printf "%q" .Error}},
+			{{- end}}
+		},
+	{{- end}}
+	}
+
+	runApigenCases(t, func() http.Handler { return {{.Constructor}} }, cases)
+}
+{{if .Defaults}}
+// Default из apivalidator должен дойти до метода: проверяем структуру, которую он получит
+func Test{{.ApiName}}GeneratedDefaults(t *testing.T) {
+	srv := {{.Constructor}}
+	{{- range .Defaults}}
+	t.Run({{printf "%q" .Name}}, func(t *testing.T) {
+		in, err, _ := srv.{{.Parse}}({{printf "%#v" .Params}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if in.{{.Field}} != {{.Expected}} {
+			t.Errorf("expected {{.Field}} = %v, got %v", {{.Expected}}, in.{{.Field}})
+		}
+	})
+	{{- end}}
+}
+{{end}}`))
+)
+
+// defaultCase - параметр опущен, и в структуру параметров должен попасть его default
+type defaultCase struct {
+	Name     string
+	Parse    string // метод, который собирает структуру параметров
+	Params   map[string]string
+	Field    string
+	Expected string // go-литерал
+}
+
+type testCase struct {
+	Name        string
+	Method      string
+	Path        string
+	Query       string
+	Auth        bool
+	Status      int
+	Error       string
+	ExpectValid bool
+}
+
+// writeTests пишет в dir общий хелпер и по _test.go файлу на каждую API-структуру
+func writeTests(dir string, src *ApiSource) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	helpers := &bytes.Buffer{}
+	err := testsHelpersTmp.Execute(helpers, struct {
+		Package   string
+		AuthToken string
+	}{src.PackageName, legacyAuthToken})
+	if err != nil {
+		return err
+	}
+	if err := writeGoFile(filepath.Join(dir, "apigen_gen_test.go"), helpers.Bytes()); err != nil {
+		return err
+	}
+
+	for _, apiName := range src.Handlers.StructNames() {
+		constructor := "&" + apiName + "{}"
+		if name, ok := src.Constructors[apiName]; ok {
+			constructor = name + "()"
+		}
+
+		cases := []testCase{}
+		defaults := []defaultCase{}
+		for _, handler := range src.Handlers[apiName] {
+			cases = append(cases, buildTestCases(handler)...)
+			defaults = append(defaults, buildDefaultCases(handler)...)
+		}
+
+		buf := &bytes.Buffer{}
+		err := testsApiTmp.Execute(buf, struct {
+			Package     string
+			ApiName     string
+			Constructor string
+			Cases       []testCase
+			Defaults    []defaultCase
+		}{src.PackageName, apiName, constructor, cases, defaults})
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, strings.ToLower(apiName)+"_gen_test.go")
+		if err := writeGoFile(path, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGoFile(path string, code []byte) error {
+	formatted, err := format.Source(code)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return os.WriteFile(path, formatted, 0644)
+}
+
+// buildTestCases выводит граничные случаи из правил apivalidator.
+// Все остальные параметры в запросе валидны, так что ошибка относится ровно к проверяемому
+func buildTestCases(handler *HttpHandlerData) []testCase {
+	method := handler.Params.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	valid := map[string]string{}
+	for _, field := range handler.ParamFields {
+		valid[field.ParamName()] = validTestValue(field)
+	}
+
+	newCase := func(name string, override map[string]string, omit string) testCase {
+		params := url.Values{}
+		for k, v := range valid {
+			if k != omit {
+				params.Set(k, v)
+			}
+		}
+		for k, v := range override {
+			params.Set(k, v)
+		}
+		return testCase{
+			Name:   handler.Name + ": " + name,
+			Method: method,
+			Path:   handler.Params.Url,
+			Query:  params.Encode(),
+			Auth:   handler.Params.Auth,
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	cases := []testCase{}
+
+	if handler.Params.Method != "" {
+		c := newCase("wrong method", nil, "")
+		c.Method = http.MethodPost
+		if handler.Params.Method == http.MethodPost {
+			c.Method = http.MethodGet
+		}
+		c.Status = http.StatusNotAcceptable
+		c.Error = "bad method"
+		cases = append(cases, c)
+	}
+
+	if handler.Params.Auth {
+		c := newCase("missing auth", nil, "")
+		c.Auth = false
+		c.Status = http.StatusForbidden
+		c.Error = "unauthorized"
+		cases = append(cases, c)
+	}
+
+	for _, field := range handler.ParamFields {
+		rules := field.Rules()
+		name := rules.ParamName
+		isInt := field.Type == "int"
+
+		if rules.Required {
+			c := newCase(name+" missing", nil, name)
+			c.Error = name + " must me not empty"
+			cases = append(cases, c)
+		}
+
+		if isInt {
+			c := newCase(name+" wrong type", map[string]string{name: "abc"}, "")
+			c.Error = name + " must be int"
+			cases = append(cases, c)
+
+			if rules.Max != nil {
+				c := newCase(name+" above max", map[string]string{name: strconv.Itoa(*rules.Max + 1)}, "")
+				c.Error = name + " must be <= " + strconv.Itoa(*rules.Max)
+				cases = append(cases, c)
+			}
+			if rules.Min != nil {
+				c := newCase(name+" below min", map[string]string{name: strconv.Itoa(*rules.Min - 1)}, "")
+				c.Error = name + " must be >= " + strconv.Itoa(*rules.Min)
+				cases = append(cases, c)
+			}
+			continue
+		}
+
+		if rules.Max != nil {
+			c := newCase(name+" above max", map[string]string{name: strings.Repeat("a", *rules.Max+1)}, "")
+			c.Error = name + " len must be <= " + strconv.Itoa(*rules.Max)
+			cases = append(cases, c)
+		}
+		// Пустую строку при required уже проверяет случай "missing", а при omitempty она проходит
+		if rules.Min != nil && *rules.Min > 0 && !((rules.Required || rules.OmitEmpty) && *rules.Min == 1) {
+			c := newCase(name+" below min", map[string]string{name: strings.Repeat("a", *rules.Min-1)}, "")
+			c.Error = name + " len must be >= " + strconv.Itoa(*rules.Min)
+			cases = append(cases, c)
+		}
+		for _, class := range rules.Classes {
+			c := newCase(name+" missing "+class, map[string]string{name: charClassValue(rules, class)}, "")
+			c.Error = name + " must contain " + charClassNames[class]
+			cases = append(cases, c)
+		}
+		if len(rules.Enum) > 0 {
+			c := newCase(name+" out of enum", map[string]string{name: outOfEnumValue(rules)}, "")
+			c.Error = name + " must be one of [" + strings.Join(rules.Enum, ", ") + "]"
+			cases = append(cases, c)
+		}
+		if rules.HasDefault && !rules.Required {
+			c := newCase(name+" default applied", nil, name)
+			c.ExpectValid = true
+			cases = append(cases, c)
+		}
+	}
+
+	return cases
+}
+
+// buildDefaultCases - по случаю на каждый необязательный параметр с default
+func buildDefaultCases(handler *HttpHandlerData) []defaultCase {
+	cases := []defaultCase{}
+	for _, field := range handler.ParamFields {
+		rules := field.Rules()
+		if !rules.HasDefault || rules.Required {
+			continue
+		}
+		params := map[string]string{}
+		for _, other := range handler.ParamFields {
+			if other.ParamName() != rules.ParamName {
+				params[other.ParamName()] = validTestValue(other)
+			}
+		}
+		expected := strconv.Quote(rules.Default)
+		if field.Type == "int" {
+			expected = rules.Default
+		}
+		cases = append(cases, defaultCase{
+			Name:     handler.Name + ": " + rules.ParamName + " default",
+			Parse:    "parse" + handler.Name + "Params",
+			Params:   params,
+			Field:    field.Name,
+			Expected: expected,
+		})
+	}
+	return cases
+}
+
+// validTestValue подбирает значение, которое проходит все правила поля
+func validTestValue(field ParamField) string {
+	rules := field.Rules()
+
+	if field.Type == "int" {
+		switch {
+		case rules.Min != nil:
+			return strconv.Itoa(*rules.Min)
+		case rules.Max != nil && *rules.Max < 1:
+			return strconv.Itoa(*rules.Max)
+		}
+		return "1"
+	}
+
+	if len(rules.Enum) > 0 {
+		if rules.HasDefault {
+			return rules.Default
+		}
+		return rules.Enum[0]
+	}
+
+	return charClassValue(rules, "")
+}
+
+// Символ, которым в тестах представлен каждый класс из classes=...
+var charClassSamples = map[string]string{
+	"lower":   "a",
+	"upper":   "A",
+	"digit":   "1",
+	"special": "!",
+}
+
+// charClassValue - строка допустимой длины с символами всех классов поля, кроме skip
+func charClassValue(rules ParamRules, skip string) string {
+	value := ""
+	for _, class := range rules.Classes {
+		if class != skip {
+			value += charClassSamples[class]
+		}
+	}
+	pad := "a"
+	if skip == "lower" {
+		pad = "A"
+	}
+	length := 1
+	if rules.Min != nil && *rules.Min > length {
+		length = *rules.Min
+	}
+	if len(value) < length {
+		value += strings.Repeat(pad, length-len(value))
+	}
+	return value
+}
+
+// outOfEnumValue - строка допустимой длины, которой нет в enum
+func outOfEnumValue(rules ParamRules) string {
+	length := 1
+	if rules.Min != nil && *rules.Min > length {
+		length = *rules.Min
+	}
+	for _, letter := range []string{"z", "y", "x"} {
+		value := strings.Repeat(letter, length)
+		if !contains(rules.Enum, value) {
+			return value
+		}
+	}
+	return strings.Repeat("z", length+1)
+}
+
+func contains(s []string, str string) bool {
+	for _, v := range s {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package main
+
+import "text/template"
+
+// tracingRuntime - спаны с W3C traceparent без внешних зависимостей. Экспортер подключается
+// полем SpanExporter в API-структуре; без него спаны живут только в контексте запроса
+var (
+	tracingRuntime = template.Must(template.New("tracingRuntime").Parse(`
+// Span - отрезок обработки запроса: маршрутизация, авторизация, валидация, вызов метода
+type Span struct {
+	TraceID  string            ` + "`json:\"trace_id\"`" + `
+	SpanID   string            ` + "`json:\"span_id\"`" + `
+	ParentID string            ` + "`json:\"parent_id,omitempty\"`" + `
+	Name     string            ` + "`json:\"name\"`" + `
+	Start    time.Time         ` + "`json:\"start\"`" + `
+	End      time.Time         ` + "`json:\"end\"`" + `
+	Attrs    map[string]string ` + "`json:\"attrs,omitempty\"`" + `
+	Error    string            ` + "`json:\"error,omitempty\"`" + `
+	Sampled  bool              ` + "`json:\"sampled\"`" + `
+
+	exporter SpanExporter
+	ended    bool
+}
+
+// SpanExporter получает каждый завершённый спан
+type SpanExporter interface {
+	ExportSpan(span Span)
+}
+
+type spanContextKey struct{}
+
+// SpanFromContext - текущий спан; nil, если запрос пришёл не через сгенерированный ServeHTTP
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// StartSpan начинает дочерний спан текущего. Его можно вызывать и из методов API
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		SpanID:  newTraceID(8),
+		Name:    name,
+		Start:   time.Now(),
+		Sampled: true,
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.Sampled = parent.Sampled
+		span.exporter = parent.exporter
+	} else {
+		span.TraceID = newTraceID(16)
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// startTrace начинает корневой спан запроса, продолжая trace из заголовка traceparent
+func startTrace(r *http.Request, exporter SpanExporter, name string) (context.Context, *Span) {
+	ctx, span := StartSpan(r.Context(), name)
+	span.exporter = exporter
+	if traceID, parentID, sampled, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+		span.TraceID = traceID
+		span.ParentID = parentID
+		span.Sampled = sampled
+	}
+	return ctx, span
+}
+
+func (s *Span) SetAttr(key string, value string) {
+	if s.Attrs == nil {
+		s.Attrs = map[string]string{}
+	}
+	s.Attrs[key] = value
+}
+
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Finish завершает спан и отдаёт его экспортеру; повторные вызовы ничего не делают
+func (s *Span) Finish() {
+	if s.ended {
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	if s.exporter != nil && s.Sampled {
+		s.exporter.ExportSpan(*s)
+	}
+}
+
+// TraceParent - заголовок traceparent, в котором родителем указан этот спан
+func (s *Span) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// parseTraceParent разбирает "00-<trace-id>-<parent-id>-<flags>" по W3C Trace Context
+func parseTraceParent(header string) (traceID string, parentID string, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", "", false, false
+	}
+	if !isTraceHex(parts[0]) || !isTraceHex(parts[1]) || !isTraceHex(parts[2]) || !isTraceHex(parts[3]) {
+		return "", "", false, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false, false
+	}
+	flags, _ := strconv.ParseUint(parts[3], 16, 8)
+	return parts[1], parts[2], flags&1 == 1, true
+}
+
+// isTraceHex - только строчные hex-символы, как требует спецификация
+func isTraceHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func newTraceID(size int) string {
+	buf := make([]byte, size)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// InMemoryExporter копит спаны в памяти - для тестов
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (e *InMemoryExporter) ExportSpan(span Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans - копия накопленных спанов в порядке завершения
+func (e *InMemoryExporter) Spans() []Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Span{}, e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// JSONLinesExporter дописывает спаны в файл, по одному json-объекту на строку
+type JSONLinesExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJSONLinesExporter(path string) (*JSONLinesExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesExporter{file: file}, nil
+}
+
+func (e *JSONLinesExporter) ExportSpan(span Span) {
+	line, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	e.file.Write(append(line, '\n'))
+	e.mu.Unlock()
+}
+
+func (e *JSONLinesExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+`))
+)
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	typescriptTmp = template.Must(template.New("typescriptTmp").Parse(`// Code generated by codegen; DO NOT EDIT.
+
+export class ApiError extends Error {
+  constructor(public readonly httpStatus: number, message: string) {
+    super(message);
+    this.name = "ApiError";
+  }
+}
+
+interface HTTPResponse<T> {
+  error: string;
+  response?: T;
+}
+{{range .Interfaces}}
+export interface {{.Name}} {
+{{- range .Fields}}
+  {{.Name}}{{if .Optional}}?{{end}}: {{.Type}};
+{{- end}}
+}
+{{end}}
+async function doRequest<T>(
+  method: string,
+  endpoint: string,
+  params: Record<string, string | number | undefined>,
+  authToken: string | undefined,
+): Promise<T> {
+  const query = new URLSearchParams();
+  for (const [key, value] of Object.entries(params)) {
+    if (value !== undefined) {
+      query.set(key, String(value));
+    }
+  }
+
+  const headers: Record<string, string> = {};
+  if (authToken !== undefined) {
+    headers["X-Auth"] = authToken;
+  }
+
+  // Как и сервер, тело с параметрами читается только у POST
+  let resp: Response;
+  if (method === "POST") {
+    headers["Content-Type"] = "application/x-www-form-urlencoded";
+    resp = await fetch(endpoint, { method, headers, body: query.toString() });
+  } else {
+    resp = await fetch(endpoint + "?" + query.toString(), { method, headers });
+  }
+
+  const envelope = (await resp.json()) as HTTPResponse<T>;
+  if (!resp.ok || envelope.error !== "") {
+    throw new ApiError(resp.status, envelope.error);
+  }
+  return envelope.response as T;
+}
+{{range $api := .Apis}}
+export class {{$api.Name}}Client {
+  constructor(
+    private readonly baseUrl: string,
+    private readonly authToken?: string,
+  ) {}
+{{range $api.Methods}}
+  {{.Name}}(params: {{.ParamsType}}): Promise<{{.ResultType}}> {
+    return doRequest<{{.ResultType}}>("{{.Method}}", this.baseUrl + "{{.Url}}", { ...params }, {{if .Auth}}this.authToken{{else}}undefined{{end}});
+  }
+{{end -}}
+}
+{{end}}`))
+)
+
+type tsField struct {
+	Name     string
+	Type     string
+	Optional bool
+}
+
+type tsInterface struct {
+	Name   string
+	Fields []tsField
+}
+
+type tsMethod struct {
+	Name       string
+	Method     string
+	Url        string
+	Auth       bool
+	ParamsType string
+	ResultType string
+}
+
+type tsApi struct {
+	Name    string
+	Methods []tsMethod
+}
+
+// writeTypeScript пишет один .ts файл: интерфейсы ответов, типы параметров и fetch-клиенты
+func writeTypeScript(path string, src *ApiSource) error {
+	data := &struct {
+		Interfaces []tsInterface
+		Apis       []tsApi
+	}{}
+
+	paramsTypes := map[string][]ParamField{}
+	resultTypes := map[string]bool{}
+	for _, apiName := range src.Handlers.StructNames() {
+		api := tsApi{Name: apiName}
+		for _, handler := range src.Handlers[apiName] {
+			method := handler.Params.Method
+			if method == "" {
+				method = "GET"
+			}
+			api.Methods = append(api.Methods, tsMethod{
+				Name:       strings.ToLower(handler.Name[:1]) + handler.Name[1:],
+				Method:     method,
+				Url:        handler.Params.Url,
+				Auth:       handler.Params.Auth,
+				ParamsType: handler.ParamsStructName,
+				ResultType: handler.ResultTypeName,
+			})
+			paramsTypes[handler.ParamsStructName] = handler.ParamFields
+			collectTypeNames(handler.ResultTypeName, src, resultTypes)
+		}
+		data.Apis = append(data.Apis, api)
+	}
+
+	// Типы параметров описывают то, что уходит в запрос, поэтому ключи - это paramname
+	for name, fields := range paramsTypes {
+		iface := tsInterface{Name: name}
+		for _, field := range fields {
+			rules := field.Rules()
+			fieldType := "string"
+			if field.Type == "int" {
+				fieldType = "number"
+			}
+			if len(rules.Enum) > 0 {
+				quoted := make([]string, 0, len(rules.Enum))
+				for _, v := range rules.Enum {
+					quoted = append(quoted, strconv.Quote(v))
+				}
+				fieldType = strings.Join(quoted, " | ")
+			}
+			iface.Fields = append(iface.Fields, tsField{
+				Name:     rules.ParamName,
+				Type:     fieldType,
+				Optional: field.Omittable(),
+			})
+		}
+		data.Interfaces = append(data.Interfaces, iface)
+	}
+
+	for name := range resultTypes {
+		iface := tsInterface{Name: name}
+		for _, field := range src.Structs[name].Fields.List {
+			for _, fieldName := range field.Names {
+				jsonName, omitEmpty, skip := jsonFieldName(fieldName.Name, field)
+				if skip {
+					continue
+				}
+				iface.Fields = append(iface.Fields, tsField{
+					Name:     jsonName,
+					Type:     tsType(field.Type, src),
+					Optional: omitEmpty,
+				})
+			}
+		}
+		data.Interfaces = append(data.Interfaces, iface)
+	}
+
+	sort.Slice(data.Interfaces, func(i, j int) bool {
+		return data.Interfaces[i].Name < data.Interfaces[j].Name
+	})
+
+	buf := &bytes.Buffer{}
+	if err := typescriptTmp.Execute(buf, data); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func tsType(expr ast.Expr, src *ApiSource) string {
+	switch xv := expr.(type) {
+	case *ast.StarExpr:
+		return tsType(xv.X, src) + " | null"
+	case *ast.ArrayType:
+		return "Array<" + tsType(xv.Elt, src) + ">"
+	case *ast.MapType:
+		return "Record<string, " + tsType(xv.Value, src) + ">"
+	case *ast.SelectorExpr:
+		if pkg, ok := xv.X.(*ast.Ident); ok && pkg.Name == "time" && xv.Sel.Name == "Time" {
+			return "string"
+		}
+	case *ast.Ident:
+		switch xv.Name {
+		case "string":
+			return "string"
+		case "bool":
+			return "boolean"
+		case "int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64",
+			"float32", "float64":
+			return "number"
+		}
+		if _, ok := src.Structs[xv.Name]; ok {
+			return xv.Name
+		}
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Минимальный разбор yaml: блочные словари и списки, скаляры в одну строку,
+// простые flow-коллекции вроде [a, b] и {type: string}. Якоря, теги и многострочные
+// скаляры не поддерживаются - для OpenAPI документов этого хватает.
+// Результат такой же, как у json.Unmarshal в interface{}
+
+type yamlLine struct {
+	num     int
+	indent  int
+	content string
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	lines := []yamlLine{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		content := strings.TrimLeft(raw, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("yaml line %d: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{i + 1, len(raw) - len(content), content})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	value, next, err := parseYAMLNode(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("yaml line %d: unexpected indentation", lines[next].num)
+	}
+	return value, nil
+}
+
+func parseYAMLNode(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	if isYAMLSeqItem(lines[i].content) {
+		return parseYAMLSeq(lines, i, indent)
+	}
+	return parseYAMLMap(lines, i, indent)
+}
+
+func parseYAMLSeq(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	list := []interface{}{}
+	for i < len(lines) && lines[i].indent == indent && isYAMLSeqItem(lines[i].content) {
+		rest := strings.TrimLeft(strings.TrimPrefix(lines[i].content, "-"), " ")
+		if rest == "" {
+			// Значение элемента - блоком на следующих строках
+			if i+1 >= len(lines) || lines[i+1].indent <= indent {
+				list = append(list, nil)
+				i++
+				continue
+			}
+			value, next, err := parseYAMLNode(lines, i+1, lines[i+1].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, value)
+			i = next
+			continue
+		}
+
+		if _, _, isKey := splitYAMLKey(rest); isKey || isYAMLSeqItem(rest) {
+			// "- key: value" - словарь, остальные ключи которого идут с отступом после "- "
+			itemIndent := indent + len(lines[i].content) - len(rest)
+			lines[i] = yamlLine{lines[i].num, itemIndent, rest}
+			value, next, err := parseYAMLNode(lines, i, itemIndent)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, value)
+			i = next
+			continue
+		}
+
+		value, err := parseYAMLScalar(rest)
+		if err != nil {
+			return nil, 0, fmt.Errorf("yaml line %d: %v", lines[i].num, err)
+		}
+		list = append(list, value)
+		i++
+	}
+	return list, i, nil
+}
+
+func parseYAMLMap(lines []yamlLine, i int, indent int) (interface{}, int, error) {
+	obj := map[string]interface{}{}
+	for i < len(lines) && lines[i].indent == indent {
+		if isYAMLSeqItem(lines[i].content) {
+			return nil, 0, fmt.Errorf("yaml line %d: unexpected list item", lines[i].num)
+		}
+		key, rest, ok := splitYAMLKey(lines[i].content)
+		if !ok {
+			return nil, 0, fmt.Errorf("yaml line %d: expected key: value", lines[i].num)
+		}
+
+		if rest != "" {
+			value, err := parseYAMLScalar(rest)
+			if err != nil {
+				return nil, 0, fmt.Errorf("yaml line %d: %v", lines[i].num, err)
+			}
+			obj[key] = value
+			i++
+			continue
+		}
+
+		i++
+		switch {
+		case i < len(lines) && lines[i].indent > indent:
+			value, next, err := parseYAMLNode(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			obj[key] = value
+			i = next
+		case i < len(lines) && lines[i].indent == indent && isYAMLSeqItem(lines[i].content):
+			// Список может идти с тем же отступом, что и ключ
+			value, next, err := parseYAMLSeq(lines, i, indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			obj[key] = value
+			i = next
+		default:
+			obj[key] = nil
+		}
+	}
+	return obj, i, nil
+}
+
+func isYAMLSeqItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// splitYAMLKey делит "key: value" с учётом кавычек в ключе
+func splitYAMLKey(content string) (key string, rest string, ok bool) {
+	if strings.HasPrefix(content, `"`) || strings.HasPrefix(content, "'") {
+		end := closingQuote(content)
+		if end < 0 || end+1 >= len(content) || content[end+1] != ':' {
+			return "", "", false
+		}
+		unquoted, err := parseYAMLScalar(content[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		return unquoted.(string), strings.TrimSpace(content[end+2:]), true
+	}
+
+	for idx := 0; idx < len(content); idx++ {
+		if content[idx] == ':' && (idx+1 == len(content) || content[idx+1] == ' ') {
+			return content[:idx], strings.TrimSpace(content[idx+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func closingQuote(s string) int {
+	quote := s[0]
+	for idx := 1; idx < len(s); idx++ {
+		switch {
+		case quote == '"' && s[idx] == '\\':
+			idx++
+		case quote == '\'' && s[idx] == '\'' && idx+1 < len(s) && s[idx+1] == '\'':
+			idx++
+		case s[idx] == quote:
+			return idx
+		}
+	}
+	return -1
+}
+
+func stripYAMLComment(line string) string {
+	var quote byte
+	for idx := 0; idx < len(line); idx++ {
+		c := line[idx]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				idx++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (idx == 0 || line[idx-1] == ' '):
+			return line[:idx]
+		}
+	}
+	return line
+}
+
+func parseYAMLScalar(s string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">"):
+		return nil, fmt.Errorf("block scalars are not supported")
+	case strings.HasPrefix(s, "&") || strings.HasPrefix(s, "*") || strings.HasPrefix(s, "!"):
+		return nil, fmt.Errorf("anchors and tags are not supported")
+	case strings.HasPrefix(s, `"`):
+		var str string
+		if err := json.Unmarshal([]byte(s), &str); err != nil {
+			return nil, fmt.Errorf("bad quoted string %s", s)
+		}
+		return str, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("bad quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("bad flow sequence %s", s)
+		}
+		list := []interface{}{}
+		for _, item := range splitYAMLFlow(s[1 : len(s)-1]) {
+			value, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("bad flow mapping %s", s)
+		}
+		obj := map[string]interface{}{}
+		for _, item := range splitYAMLFlow(s[1 : len(s)-1]) {
+			key, rest, ok := splitYAMLKey(item)
+			if !ok {
+				return nil, fmt.Errorf("bad flow mapping %s", s)
+			}
+			value, err := parseYAMLScalar(rest)
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = value
+		}
+		return obj, nil
+	case s == "" || s == "null" || s == "~":
+		return nil, nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	}
+
+	if num, err := strconv.ParseFloat(s, 64); err == nil {
+		return num, nil
+	}
+	return s, nil
+}
+
+// splitYAMLFlow делит содержимое [...] или {...} по запятым верхнего уровня
+func splitYAMLFlow(inner string) []string {
+	items := []string{}
+	depth := 0
+	var quote byte
+	start := 0
+	for idx := 0; idx < len(inner); idx++ {
+		c := inner[idx]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				idx++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(inner[start:idx]))
+			start = idx + 1
+		}
+	}
+	if last := strings.TrimSpace(inner[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	data := `
+# комментарий
+openapi: "3.0.3"
+info:
+  title: Shop # до конца строки
+  version: 1.0.0
+tags: [a, "b, c"]
+paths:
+  /item:
+    get:
+      parameters:
+        - name: id
+          in: query
+          required: true
+          schema: {type: integer, minimum: 1}
+        - name: note
+          schema:
+            type: string
+            nullable: null
+`
+	expected := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": "Shop", "version": "1.0.0"},
+		"tags":    []interface{}{"a", "b, c"},
+		"paths": map[string]interface{}{
+			"/item": map[string]interface{}{
+				"get": map[string]interface{}{
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":     "id",
+							"in":       "query",
+							"required": true,
+							"schema":   map[string]interface{}{"type": "integer", "minimum": float64(1)},
+						},
+						map[string]interface{}{
+							"name":   "note",
+							"schema": map[string]interface{}{"type": "string", "nullable": nil},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	got, err := parseYAML([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	cases := map[string]string{
+		"a:\n\tb: 1\n":   "tabs are not allowed",
+		"a: 1\n  b: 2\n": "yaml line 2",
+	}
+	for data, expected := range cases {
+		_, err := parseYAML([]byte(data))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error %q, got %v", data, expected, err)
+		}
+	}
+}
@@ -6,14 +6,72 @@ package main
 // этот код закомментирован чтобы он не светился в тестовом покрытии
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 )
 
 func main() {
+	usersLog := flag.String("users", "", "файл журнала пользователей, по умолчанию пользователи живут только в памяти")
+	tokenSecret := flag.String("token-secret", "", "ключ подписи токенов сессий, по умолчанию случайный - сессии не переживут рестарт")
+	serviceToken := flag.String("service-token", "", "статический X-Auth с правами админа для сервисных вызовов, по умолчанию выключен")
+	auditLog := flag.String("audit", "", "файл журнала аудита, по умолчанию журнал живёт только в памяти")
+	adminPassword := flag.String("admin-password", os.Getenv("ADMIN_PASSWORD"), "пароль rvasily, если он ещё не задан, по умолчанию из ADMIN_PASSWORD; без него вход под rvasily закрыт")
+	flag.Parse()
+
 	// будет вызван метод ServeHTTP у структуры MyApi
-	http.Handle("/user/", NewMyApi())
+	api := NewMyApi()
+	if *usersLog != "" {
+		store, err := OpenFileUserStore(*usersLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		// в новом журнале заводим тех же пользователей, что и в памяти
+		for _, user := range defaultUsers() {
+			if _, err := store.GetByLogin(user.Login); errors.Is(err, ErrUserNotFound) {
+				if err := store.Create(user); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		api = NewMyApiWithStore(store)
+	}
+	if *adminPassword != "" {
+		if err := setInitialPassword(api.users, "rvasily", *adminPassword); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *tokenSecret != "" {
+		api.Sessions = NewSessionTokens([]byte(*tokenSecret), sessionTTL)
+	}
+	api.ServiceToken = *serviceToken
+	if *auditLog != "" {
+		audit, err := OpenFileAuditLog(*auditLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer audit.Close()
+		api.AuditSink = audit
+	}
+	registerRoutes(http.DefaultServeMux, api)
 
 	fmt.Println("starting server at :8080")
 	http.ListenAndServe(":8080", nil)
 }
+
+// registerRoutes вешает на mux все пути, которые обслуживает сгенерированный ServeHTTP
+func registerRoutes(mux *http.ServeMux, api *MyApi) {
+	mux.Handle("/user/", api)
+	mux.Handle("/audit", api)
+	// те же методы по JSON-RPC 2.0: {"jsonrpc": "2.0", "method": "MyApi.Profile", ...}
+	mux.Handle("/rpc", api)
+	// таблица маршрутов и страница с формами для ручных запросов (codegen -docs)
+	mux.Handle("/_routes", api)
+	mux.Handle("/_docs", api)
+	// метрики в формате Prometheus (codegen -metrics)
+	mux.Handle("/_metrics", api)
+}
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	// клиент генерируется рядом: codegen -client ../client
+	apiclient "./client"
 )
 
 func CheckoutDummy(w http.ResponseWriter, r *http.Request) {
@@ -280,11 +289,1370 @@ func TestOtherApi(t *testing.T) {
 				},
 			},
 		},
+		Case{ // strict - неизвестный параметр
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "username=I3apBap&level=1&class=warrior&account_name=Vasily&any_params=123",
+			Status: http.StatusBadRequest,
+			Auth:   true,
+			Result: CR{
+				"error": "unknown params: any_params",
+			},
+		},
+		Case{ // strict - повторяющиеся и неизвестные параметры
+			Path:   ApiUserCreate,
+			Method: http.MethodPost,
+			Query:  "username=I3apBap&level=1&level=2&zzz=1&class=warrior&aaa=2",
+			Status: http.StatusBadRequest,
+			Auth:   true,
+			Result: CR{
+				"error": "unknown params: aaa, zzz; duplicated params: level",
+			},
+		},
+		Case{ // strict - у POST query тоже проверяется: лишний ключ и ключ, который есть и в теле
+			Path:   ApiUserCreate + "?evil=1&username=zz",
+			Method: http.MethodPost,
+			Query:  "username=I3apBap&level=1&class=warrior&account_name=Vasily",
+			Status: http.StatusBadRequest,
+			Auth:   true,
+			Result: CR{
+				"error": "unknown params: evil; duplicated params: username",
+			},
+		},
+		Case{ // таблица маршрутов, собранная при генерации
+			Path:   "/_routes",
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": []CR{
+					CR{
+						"url":     ApiUserCreate,
+						"method":  http.MethodPost,
+						"auth":    true,
+						"handler": "Create",
+						"params": []CR{
+							CR{"name": "username", "field": "Username", "type": "string", "rules": "required,min=3", "required": true, "min": 3},
+							CR{"name": "account_name", "field": "Name", "type": "string", "rules": "paramname=account_name", "required": false},
+							CR{"name": "class", "field": "Class", "type": "string", "rules": "enum=warrior|sorcerer|rouge,default=warrior", "required": false, "enum": []string{"warrior", "sorcerer", "rouge"}, "default": "warrior"},
+							CR{"name": "level", "field": "Level", "type": "int", "rules": "min=1,max=50", "required": false, "min": 1, "max": 50},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	runTests(t, ts, cases)
 }
 
+func TestDocsPage(t *testing.T) {
+	// через те же пути, что вешает main
+	mux := http.NewServeMux()
+	registerRoutes(mux, NewMyApi())
+	ts := httptest.NewServer(mux)
+
+	resp, err := client.Get(ts.URL + "/_docs")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected http status %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("expected html content type, got %q", ct)
+	}
+	for _, url := range []string{ApiUserProfile, ApiUserCreate} {
+		if !strings.Contains(string(body), `data-url="`+url+`"`) {
+			t.Errorf("no try-it form for %s", url)
+		}
+	}
+	// параметры DELETE, как и GET, уходят в query - тело сервер читает только у POST
+	if !strings.Contains(string(body), `if (method === "POST")`) {
+		t.Errorf("try-it form sends non-POST params in the body")
+	}
+
+	resp, err = client.Get(ts.URL + "/_routes")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/_routes: expected http status %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ts := httptest.NewServer(NewOtherApi())
+
+	// middleware из apigen:struct оборачивает и успешные, и ошибочные ответы
+	for _, method := range []string{http.MethodPost, http.MethodGet} {
+		req, _ := http.NewRequest(method, ts.URL+ApiUserCreate, strings.NewReader("username=moderator&level=1"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("X-Auth", "100500")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		resp.Body.Close()
+
+		if server := resp.Header.Get("Server"); server != "OtherApi" {
+			t.Errorf("[%s] expected Server header from middleware, got %q", method, server)
+		}
+	}
+
+	// по JSON-RPC вызов идёт через ту же цепочку
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/rpc", strings.NewReader(`{"jsonrpc": "2.0", "method": "OtherApi.Create", "params": {"username": "moderator", "level": 1}, "id": 1}`))
+	req.Header.Add("X-Auth", "100500")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if server := resp.Header.Get("Server"); server != "OtherApi" {
+		t.Errorf("[rpc] expected Server header from middleware, got %q", server)
+	}
+
+	// middleware, ответивший сам, останавливает вызов, а его ответ становится ошибкой
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response(w, &ApiError{http.StatusUnauthorized, fmt.Errorf("denied by middleware")}, nil)
+		})
+	}
+	called := false
+	_, rpcErr := callThroughMiddleware(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/rpc", nil), deny,
+		func(w http.ResponseWriter, r *http.Request) (interface{}, *jsonRPCError) {
+			called = true
+			return nil, nil
+		})
+	if called || rpcErr == nil || rpcErr.Message != "denied by middleware" || rpcErr.Data.(map[string]int)["status"] != http.StatusUnauthorized {
+		t.Errorf("expected call denied by middleware, got called=%v %+v", called, rpcErr)
+	}
+}
+
+// Ответы без тела fuzz-харнесс принимает: 304 на условный GET и 204 на уведомление JSON-RPC
+func TestFuzzServeEmptyResponses(t *testing.T) {
+	apigenFuzzServe(t, NewMyApi(), ApiUserProfile, http.MethodGet, "login=rvasily", "", "", "If-None-Match", "*")
+	apigenFuzzServe(t, NewMyApi(), "/rpc", http.MethodPost, "", `{"jsonrpc": "2.0", "method": "MyApi.Profile", "params": {"login": "rvasily"}}`, "", "", "")
+}
+
+func TestRequestLog(t *testing.T) {
+	logs := &bytes.Buffer{}
+	api := NewMyApi()
+	api.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+
+	req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader("login=new_moderator&age=-1"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("X-Auth", "100500")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("expected one json log line, got %q: %v", logs.String(), err)
+	}
+	expected := map[string]interface{}{
+		"endpoint":         ApiUserCreate,
+		"method":           http.MethodPost,
+		"status":           float64(http.StatusBadRequest),
+		"validation_error": "age must be >= 0",
+		"level":            "WARN",
+	}
+	for k, v := range expected {
+		if line[k] != v {
+			t.Errorf("log field %s: expected %v, got %v", k, v, line[k])
+		}
+	}
+	if _, ok := line["latency"]; !ok {
+		t.Errorf("no latency in log line %q", logs.String())
+	}
+
+	redacted := redactParams(map[string]string{"login": "rvasily", "password": "secret"}, []string{"password"})
+	if redacted["login"] != "rvasily" || redacted["password"] != redactedParam {
+		t.Errorf("sensitive params not redacted: %v", redacted)
+	}
+}
+
+// main вешает API на http.DefaultServeMux, а повторная регистрация пути там паникует
+var registerDefaultRoutes sync.Once
+
+func TestMetricsRoute(t *testing.T) {
+	registerDefaultRoutes.Do(func() { registerRoutes(http.DefaultServeMux, NewMyApi()) })
+
+	http.DefaultServeMux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, ApiUserProfile+"?login=rvasily", nil))
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/_metrics: expected http status %v, got %v", http.StatusOK, w.Code)
+	}
+	if series := `apigen_requests_total{api="MyApi",endpoint="/user/profile",method="GET",status="200"}`; !strings.Contains(w.Body.String(), series) {
+		t.Errorf("no %s in metrics:\n%s", series, w.Body.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	api := NewOtherApi()
+	scrape := func() map[string]string {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_metrics", nil))
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Fatalf("expected text/plain metrics, got %q", ct)
+		}
+		series := map[string]string{}
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if idx := strings.LastIndex(line, " "); idx > 0 && !strings.HasPrefix(line, "#") {
+				series[line[:idx]] = line[idx+1:]
+			}
+		}
+		return series
+	}
+
+	// реестр общий на все OtherApi в пакете, поэтому смотрим на прирост
+	before := scrape()
+	req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader("username=moderator&level=100"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("X-Auth", "100500")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+	after := scrape()
+
+	increments := []string{
+		`apigen_requests_total{api="OtherApi",endpoint="/user/create",method="POST",status="400"}`,
+		`apigen_validation_failures_total{api="OtherApi",endpoint="/user/create",param="level"}`,
+		`apigen_request_duration_seconds_count{api="OtherApi",endpoint="/user/create"}`,
+		`apigen_request_duration_seconds_bucket{api="OtherApi",endpoint="/user/create",le="+Inf"}`,
+	}
+	for _, name := range increments {
+		prev, _ := strconv.Atoi(before[name])
+		curr, err := strconv.Atoi(after[name])
+		if err != nil || curr != prev+1 {
+			t.Errorf("%s: expected %d, got %q", name, prev+1, after[name])
+		}
+	}
+	if inFlight := after[`apigen_requests_in_flight{api="OtherApi",endpoint="/user/create"}`]; inFlight != "0" {
+		t.Errorf("expected no requests in flight, got %q", inFlight)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	api := NewMyApi()
+	api.SpanExporter = exporter
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+	req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader("login=new_moderator2&age=32"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("X-Auth", "100500")
+	req.Header.Add("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	spans := map[string]Span{}
+	for _, span := range exporter.Spans() {
+		if span.TraceID != traceID {
+			t.Errorf("span %s: trace id %s is not propagated", span.Name, span.TraceID)
+		}
+		spans[span.Name] = span
+	}
+
+	root, ok := spans["apigen.request"]
+	if !ok {
+		t.Fatalf("no request span, got %+v", exporter.Spans())
+	}
+	if root.ParentID != parentID {
+		t.Errorf("request span parent: expected %s, got %s", parentID, root.ParentID)
+	}
+	if root.Attrs["http.status_code"] != "200" || root.Attrs["apigen.endpoint"] != ApiUserCreate {
+		t.Errorf("unexpected request span attrs: %v", root.Attrs)
+	}
+	for _, name := range []string{"apigen.route", "apigen.auth", "apigen.validate", "MyApi.Create"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.ParentID != root.SpanID {
+			t.Errorf("span %s: expected parent %s, got %s", name, root.SpanID, span.ParentID)
+		}
+	}
+
+	expectedHeader := "00-" + traceID + "-" + root.SpanID + "-01"
+	if got := w.Header().Get("traceparent"); got != expectedHeader {
+		t.Errorf("traceparent: expected %s, got %s", expectedHeader, got)
+	}
+
+	// битый traceparent игнорируем и начинаем новый trace
+	exporter.Reset()
+	req = httptest.NewRequest(http.MethodGet, ApiUserProfile+"?login=rvasily", nil)
+	req.Header.Add("traceparent", "00-"+strings.Repeat("0", 32)+"-"+parentID+"-01")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+	for _, span := range exporter.Spans() {
+		if span.Name == "apigen.request" && (span.ParentID != "" || len(span.TraceID) != 32) {
+			t.Errorf("invalid traceparent must start a new trace, got %+v", span)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	api := NewMyApi()
+	send := func() *httptest.ResponseRecorder {
+		// невалидный логин - пользователей не создаём, но токены тратим
+		req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader("login=short"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("X-Auth", "100500")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	// burst из apigen:api - 20 запросов проходят сразу
+	for i := 0; i < 20; i++ {
+		if w := send(); w.Code != http.StatusBadRequest {
+			t.Fatalf("request %d: expected http status %v, got %v", i, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	w := send()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected http status %v, got %v", http.StatusTooManyRequests, w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("expected Retry-After 1, got %q", retryAfter)
+	}
+	expected := `{"error":"rate limit exceeded, retry after 1s"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+
+	// уборка полных вёдер считает каждое ведро по его лимитам, а не по лимитам вызывающего
+	store := &InMemoryRateLimiterStore{}
+	store.Allow("slow", 0.01, 1)
+	store.lastSweep = time.Time{}
+	store.Allow("fast", 1e9, 1)
+	if ok, _ := store.Allow("slow", 0.01, 1); ok {
+		t.Errorf("sweep refilled a bucket with another endpoint's limits")
+	}
+
+	// key auth: с неверными токенами лимит считается по ip, случайный токен его не обходит
+	changePassword := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/user/password", strings.NewReader("old_password=x&new_password=Other-Pass2"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("X-Auth", token)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w.Code
+	}
+	for i := 0; i < 5; i++ {
+		if code := changePassword(fmt.Sprintf("random-token-%d", i)); code != http.StatusForbidden {
+			t.Fatalf("invalid token %d: expected http status %v, got %v", i, http.StatusForbidden, code)
+		}
+	}
+	if code := changePassword("random-token-5"); code != http.StatusTooManyRequests {
+		t.Errorf("invalid tokens after burst: expected http status %v, got %v", http.StatusTooManyRequests, code)
+	}
+	// у проверенного токена своё ведро
+	if code := changePassword(defaultServiceToken); code != http.StatusBadRequest {
+		t.Errorf("valid token: expected http status %v, got %v", http.StatusBadRequest, code)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	logs := &bytes.Buffer{}
+	api := NewMyApi()
+	api.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+	var hookPanic interface{}
+	var hookStack []byte
+	api.PanicHook = func(r *http.Request, recovered interface{}, stack []byte) {
+		hookPanic, hookStack = recovered, stack
+	}
+
+	w := httptest.NewRecorder()
+	api.apigenServe(w, httptest.NewRequest(http.MethodGet, "/user/panic", nil), "/user/panic",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var restr *Restrictions
+			_ = *restr.Min
+		}))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected http status %v, got %v", http.StatusInternalServerError, w.Code)
+	}
+	expected := `{"error":"internal error"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+	if hookPanic == nil || !strings.Contains(string(hookStack), "TestPanicRecovery") {
+		t.Errorf("panic hook not called with stack: %v", hookPanic)
+	}
+	if !strings.Contains(logs.String(), `"msg":"apigen panic"`) || !strings.Contains(logs.String(), `"stack":`) {
+		t.Errorf("panic is not logged with stack: %s", logs.String())
+	}
+
+	// обработчик успел ответить - статус не переписываем
+	w = httptest.NewRecorder()
+	api.apigenServe(w, httptest.NewRequest(http.MethodGet, "/user/panic", nil), "/user/panic",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("after header")
+		}))
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Errorf("expected untouched %v response, got %v %q", http.StatusAccepted, w.Code, w.Body.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	logs := &bytes.Buffer{}
+	api := NewMyApi()
+	api.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+
+	logged := func() string {
+		line := map[string]interface{}{}
+		json.Unmarshal(logs.Bytes(), &line)
+		logs.Reset()
+		id, _ := line["request_id"].(string)
+		return id
+	}
+
+	// id клиента возвращаем как есть
+	req := httptest.NewRequest(http.MethodGet, ApiUserProfile+"?login=rvasily", nil)
+	req.Header.Set("X-Request-ID", "client-42")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-ID"); got != "client-42" {
+		t.Errorf("expected X-Request-ID client-42, got %q", got)
+	}
+	if got := logged(); got != "client-42" {
+		t.Errorf("expected request_id client-42 in log, got %q", got)
+	}
+
+	// без заголовка или с мусором в нём - генерируем свой
+	for _, incoming := range []string{"", "bad id\n"} {
+		req = httptest.NewRequest(http.MethodGet, ApiUserProfile+"?login=rvasily", nil)
+		req.Header.Set("X-Request-ID", incoming)
+		w = httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		id := w.Header().Get("X-Request-ID")
+		if len(id) != 32 {
+			t.Errorf("incoming %q: expected generated request id, got %q", incoming, id)
+		}
+		if got := logged(); got != id {
+			t.Errorf("incoming %q: expected request_id %s in log, got %q", incoming, id, got)
+		}
+	}
+
+	// request_id в конверте с ошибкой, если он включён
+	w = httptest.NewRecorder()
+	response(&apigenResponseWriter{ResponseWriter: w, requestID: "client-42"}, &ApiError{http.StatusNotFound, fmt.Errorf("user not exist")}, nil)
+	expected := `{"error":"user not exist","request_id":"client-42"}`
+	if w.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, w.Body.String())
+	}
+}
+
+func TestCORS(t *testing.T) {
+	api := NewMyApi()
+	preflight := func(path string, origin string, method string, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	// настройки структуры
+	w := preflight(ApiUserCreate, "http://localhost:3000", http.MethodPost, "content-type, x-auth, idempotency-key")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected http status %v, got %v: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "http://localhost:3000",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "POST",
+		"Access-Control-Max-Age":           "600",
+	}
+	for k, v := range expected {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s: expected %q, got %q", k, v, got)
+		}
+	}
+
+	// чужой origin, неразрешённые метод и заголовок
+	rejected := []struct{ origin, method, headers string }{
+		{"http://evil.example", http.MethodPost, ""},
+		{"http://localhost:3000", http.MethodGet, ""},
+		{"http://localhost:3000", http.MethodPost, "X-Custom"},
+	}
+	for _, item := range rejected {
+		if w := preflight(ApiUserCreate, item.origin, item.method, item.headers); w.Code != http.StatusForbidden {
+			t.Errorf("%+v: expected http status %v, got %v", item, http.StatusForbidden, w.Code)
+		}
+	}
+
+	// переопределение в apigen:api - профиль открыт всем и без credentials
+	req := httptest.NewRequest(http.MethodGet, ApiUserProfile+"?login=rvasily", nil)
+	req.Header.Set("Origin", "http://evil.example")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected http status %v, got %v", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected Access-Control-Allow-Origin *, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("expected no credentials, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(got, "X-Request-ID") {
+		t.Errorf("expected X-Request-ID to be exposed, got %q", got)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	api := NewMyApi()
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, ApiUserProfile+"?login=rvasily", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("expected 200 MISS with ETag, got %v %q %q", first.Code, first.Header().Get("X-Cache"), etag)
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != "public, max-age=10" {
+		t.Errorf("expected Cache-Control from apigen:api, got %q", cc)
+	}
+
+	second := get("")
+	if second.Header().Get("X-Cache") != "HIT" || second.Header().Get("ETag") != etag || second.Body.String() != first.Body.String() {
+		t.Errorf("expected the same response from cache, got %q %q", second.Header().Get("X-Cache"), second.Body.String())
+	}
+
+	notModified := get(etag)
+	if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+		t.Errorf("expected 304 without body, got %v %q", notModified.Code, notModified.Body.String())
+	}
+
+	// успешный create сбрасывает кэш профилей
+	req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader("login=cache_invalidator&age=20"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("X-Auth", "100500")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+	if afterCreate := get(""); afterCreate.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("expected cache to be invalidated by create, got %q", afterCreate.Header().Get("X-Cache"))
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	api := NewMyApi()
+	create := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader(body))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("X-Auth", "100500")
+		if key != "" {
+			req.Header.Add("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	first := create("key-1", "login=idempotent_user&age=20")
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected http status %v, got %v: %s", http.StatusOK, first.Code, first.Body.String())
+	}
+
+	// ретрай получает тот же ответ, а не 409 "user exist"
+	retry := create("key-1", "login=idempotent_user&age=20")
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected replayed response %s, got %v %s", first.Body.String(), retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected Idempotent-Replayed header on retry")
+	}
+
+	// тот же ключ с другим телом
+	if w := create("key-1", "login=another_user&age=20"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected http status %v for reused key, got %v", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	// первый запрос с этим ключом ещё не закончился
+	inProgress := httptest.NewRequest(http.MethodPost, ApiUserCreate, nil)
+	fingerprint := idempotencyFingerprint(inProgress, []byte("login=another_user&age=20"))
+	api.IdempotencyStore.Reserve(ApiUserCreate+"|principal:service|key-2", fingerprint, time.Hour)
+	if w := create("key-2", "login=another_user&age=20"); w.Code != http.StatusConflict {
+		t.Errorf("expected http status %v for key in progress, got %v", http.StatusConflict, w.Code)
+	}
+
+	// без ключа всё как раньше
+	if w := create("", "login=idempotent_user&age=20"); w.Code != http.StatusConflict {
+		t.Errorf("expected http status %v without key, got %v", http.StatusConflict, w.Code)
+	}
+
+	// ключи привязаны к пользователю, а не к токену: ретрай с новой сессией получает тот же ответ
+	if w := create("", "login=idempotent_owner&age=20&status=moderator&password=Owner-Pass1"); w.Code != http.StatusOK {
+		t.Fatalf("create owner: %v %s", w.Code, w.Body.String())
+	}
+	session := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/user/login", strings.NewReader("login=idempotent_owner&password=Owner-Pass1"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		result := CR{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return result["response"].(map[string]interface{})["token"].(string)
+	}
+	createAs := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader("login=owned_user&age=20"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("X-Auth", token)
+		req.Header.Add("Idempotency-Key", "key-3")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	firstToken, secondToken := session(), session()
+	if firstToken == secondToken {
+		t.Fatalf("expected different session tokens")
+	}
+	owned := createAs(firstToken)
+	if owned.Code != http.StatusOK {
+		t.Fatalf("expected http status %v, got %v: %s", http.StatusOK, owned.Code, owned.Body.String())
+	}
+	if retry := createAs(secondToken); retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != owned.Body.String() {
+		t.Errorf("expected replay with a new session, got %v %s", retry.Code, retry.Body.String())
+	}
+
+	// по JSON-RPC ключ работает так же: повтор получает первый результат, а не 409
+	rpc := func(params string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(`{"jsonrpc": "2.0", "method": "MyApi.Create", "params": `+params+`, "id": 1}`))
+		req.Header.Add("X-Auth", "100500")
+		req.Header.Add("Idempotency-Key", "rpc-key-1")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	rpcFirst := rpc(`{"login": "rpc_idempotent", "age": 20}`)
+	if !strings.Contains(rpcFirst.Body.String(), `"result":{"id":`) {
+		t.Fatalf("rpc create: got %s", rpcFirst.Body.String())
+	}
+	rpcRetry := rpc(`{"age": 20, "login": "rpc_idempotent"}`)
+	if rpcRetry.Body.String() != rpcFirst.Body.String() || rpcRetry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("rpc retry: expected replayed %s, got %s", rpcFirst.Body.String(), rpcRetry.Body.String())
+	}
+	if w := rpc(`{"login": "rpc_other_user", "age": 20}`); !strings.Contains(w.Body.String(), `"status":422`) {
+		t.Errorf("rpc reused key: expected status 422, got %s", w.Body.String())
+	}
+}
+
+func testUserStore(t *testing.T, name string, store UserStore) {
+	first := &User{Login: "first", FullName: "First", Status: statusUser}
+	if err := store.Create(first); err != nil || first.ID == 0 {
+		t.Fatalf("[%s] create: id %v, err %v", name, first.ID, err)
+	}
+	second := &User{Login: "second", Status: statusModerator}
+	if err := store.Create(second); err != nil || second.ID != first.ID+1 {
+		t.Fatalf("[%s] create: expected id %v, got %v, err %v", name, first.ID+1, second.ID, err)
+	}
+	if err := store.Create(&User{Login: "first"}); err != ErrUserExists {
+		t.Errorf("[%s] expected ErrUserExists for duplicate login, got %v", name, err)
+	}
+
+	got, err := store.GetByLogin("first")
+	if err != nil || !reflect.DeepEqual(got, first) {
+		t.Errorf("[%s] get by login: expected %#v, got %#v, err %v", name, first, got, err)
+	}
+	// наружу отдаются копии
+	got.FullName = "changed outside"
+	if got, _ := store.GetByID(first.ID); got.FullName != "First" {
+		t.Errorf("[%s] store changed through returned user", name)
+	}
+
+	renamed := *second
+	renamed.Login = "first"
+	if err := store.Update(&renamed); err != ErrUserExists {
+		t.Errorf("[%s] expected ErrUserExists when login is taken, got %v", name, err)
+	}
+	renamed.Login = "renamed"
+	if err := store.Update(&renamed); err != nil {
+		t.Errorf("[%s] update: %v", name, err)
+	}
+	if _, err := store.GetByLogin("second"); err != ErrUserNotFound {
+		t.Errorf("[%s] old login still resolves after rename: %v", name, err)
+	}
+	if err := store.Update(&User{ID: 100500, Login: "ghost"}); err != ErrUserNotFound {
+		t.Errorf("[%s] expected ErrUserNotFound on update, got %v", name, err)
+	}
+
+	third := &User{Login: "third"}
+	store.Create(third)
+	page, _ := store.List(UserFilter{AfterID: first.ID, Limit: 1})
+	if len(page) != 1 || page[0].Login != "renamed" {
+		t.Errorf("[%s] list: expected [renamed], got %#v", name, page)
+	}
+
+	if err := store.Delete(first.ID); err != nil {
+		t.Errorf("[%s] delete: %v", name, err)
+	}
+	if err := store.Delete(first.ID); err != ErrUserNotFound {
+		t.Errorf("[%s] expected ErrUserNotFound on second delete, got %v", name, err)
+	}
+	if _, err := store.GetByID(first.ID); err != ErrUserNotFound {
+		t.Errorf("[%s] deleted user still found: %v", name, err)
+	}
+}
+
+func TestUserStore(t *testing.T) {
+	testUserStore(t, "memory", NewInMemoryUserStore())
+
+	path := t.TempDir() + "/users.log"
+	store, err := OpenFileUserStore(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	testUserStore(t, "file", store)
+	before, _ := store.List(UserFilter{})
+	store.Close()
+
+	// рестарт: всё восстанавливается из журнала, недописанная строка отбрасывается
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"op":"put","user":{"id":99,"lo`)
+	f.Close()
+	store, err = OpenFileUserStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	after, _ := store.List(UserFilter{})
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("after restart expected %#v, got %#v", before, after)
+	}
+
+	// после сжатия в журнале только живые пользователи, а ID удалённых не выдаются снова
+	if err := store.Compact(); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != len(before)+1 {
+		t.Errorf("expected %d lines after compaction, got %d:\n%s", len(before)+1, lines, data)
+	}
+	store.Close()
+	store, err = OpenFileUserStore(path)
+	if err != nil {
+		t.Fatalf("reopen after compaction: %v", err)
+	}
+	defer store.Close()
+	if after, _ := store.List(UserFilter{}); !reflect.DeepEqual(after, before) {
+		t.Errorf("after compaction expected %#v, got %#v", before, after)
+	}
+	next := &User{Login: "next"}
+	store.Create(next)
+	if last := before[len(before)-1]; next.ID != last.ID+1 {
+		t.Errorf("expected id %v after compaction, got %v", last.ID+1, next.ID)
+	}
+}
+
+func TestMyApiFileStore(t *testing.T) {
+	path := t.TempDir() + "/users.log"
+	store, _ := OpenFileUserStore(path)
+	api := NewMyApiWithStore(store)
+
+	req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader("login=durable_user&age=20"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("X-Auth", "100500")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: expected http status %v, got %v: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	store.Close()
+
+	// новый процесс видит созданного пользователя
+	store, _ = OpenFileUserStore(path)
+	defer store.Close()
+	w = httptest.NewRecorder()
+	NewMyApiWithStore(store).ServeHTTP(w, httptest.NewRequest(http.MethodGet, ApiUserProfile+"?login=durable_user", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"login":"durable_user"`) {
+		t.Errorf("profile after restart: got %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUserCRUD(t *testing.T) {
+	api := NewMyApi()
+	do := func(method string, path string, query string) (int, CR) {
+		var req *http.Request
+		if method == http.MethodPost {
+			req = httptest.NewRequest(method, path, strings.NewReader(query))
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, path+"?"+query, nil)
+		}
+		req.Header.Add("X-Auth", "100500")
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		result := CR{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+
+	for _, login := range []string{"crud_user_1", "crud_user_2", "crud_moder_1"} {
+		status := "user"
+		if strings.Contains(login, "moder") {
+			status = "moderator"
+		}
+		if code, res := do(http.MethodPost, ApiUserCreate, "age=20&login="+login+"&status="+status); code != http.StatusOK {
+			t.Fatalf("create %s: %v %v", login, code, res)
+		}
+	}
+
+	// 42 - rvasily, дальше созданные по порядку
+	code, res := do(http.MethodGet, "/user/list", "limit=2")
+	if code != http.StatusOK {
+		t.Fatalf("list: %v %v", code, res)
+	}
+	page := res["response"].(map[string]interface{})
+	if users := page["users"].([]interface{}); len(users) != 2 || page["next_cursor"] == nil {
+		t.Fatalf("list: expected 2 users and next cursor, got %v", page)
+	}
+	code, res = do(http.MethodGet, "/user/list", "limit=2&cursor="+page["next_cursor"].(string))
+	page = res["response"].(map[string]interface{})
+	if users := page["users"].([]interface{}); code != http.StatusOK || len(users) != 2 || page["next_cursor"] != nil {
+		t.Errorf("list second page: expected 2 users and no cursor, got %v %v", code, page)
+	}
+
+	code, res = do(http.MethodGet, "/user/list", "status=user&login_prefix=crud_")
+	page = res["response"].(map[string]interface{})
+	if users := page["users"].([]interface{}); code != http.StatusOK || len(users) != 2 {
+		t.Errorf("list with filters: expected 2 users, got %v %v", code, page)
+	}
+	if code, res := do(http.MethodGet, "/user/list", "cursor=!!!"); code != http.StatusBadRequest || res["error"] != "bad cursor" {
+		t.Errorf("list with bad cursor: got %v %v", code, res)
+	}
+
+	// частичное обновление и сброс кэша профиля
+	do(http.MethodGet, ApiUserProfile, "login=crud_user_1")
+	code, res = do(http.MethodPost, "/user/update", "id=43&full_name=Updated")
+	if code != http.StatusOK || res["response"].(map[string]interface{})["full_name"] != "Updated" {
+		t.Errorf("update: got %v %v", code, res)
+	}
+	if _, res := do(http.MethodGet, ApiUserProfile, "login=crud_user_1"); res["response"].(map[string]interface{})["full_name"] != "Updated" {
+		t.Errorf("profile after update is stale: %v", res)
+	}
+	if code, res := do(http.MethodPost, "/user/update", "id=43"); code != http.StatusBadRequest {
+		t.Errorf("empty update: got %v %v", code, res)
+	}
+
+	if code, res := do(http.MethodDelete, "/user/delete", "id=44"); code != http.StatusOK {
+		t.Errorf("delete: got %v %v", code, res)
+	}
+	if code, _ := do(http.MethodGet, ApiUserProfile, "login=crud_user_2"); code != http.StatusNotFound {
+		t.Errorf("deleted user is still visible: %v", code)
+	}
+	if code, _ := do(http.MethodDelete, "/user/delete", "id=44"); code != http.StatusNotFound {
+		t.Errorf("second delete: expected %v, got %v", http.StatusNotFound, code)
+	}
+
+	// права внутри Update: пользователь правит только себя, модератор - всех, кроме админов.
+	// Кому можно звать List и Delete, проверяет обработчик, см. TestRoles
+	user, _ := api.users.GetByLogin("crud_user_1")
+	moder, _ := api.users.GetByLogin("crud_moder_1")
+	admin, _ := api.users.GetByLogin("rvasily")
+	rights := []struct {
+		caller *User
+		params interface{}
+		status int
+	}{
+		{user, UpdateParams{ID: int(user.ID), FullName: "Me"}, http.StatusOK},
+		{user, UpdateParams{ID: int(moder.ID), FullName: "x"}, http.StatusForbidden},
+		{user, UpdateParams{ID: int(user.ID), Status: "moderator"}, http.StatusForbidden},
+		{moder, UpdateParams{ID: int(user.ID), Status: "moderator"}, http.StatusOK},
+		{moder, UpdateParams{ID: int(user.ID), Status: "admin"}, http.StatusForbidden},
+		{moder, UpdateParams{ID: int(admin.ID), FullName: "x"}, http.StatusForbidden},
+		{moder, ListParams{Limit: 20}, http.StatusOK},
+		{admin, DeleteParams{ID: int(admin.ID)}, http.StatusBadRequest},
+		{admin, UpdateParams{ID: int(moder.ID), Status: "admin"}, http.StatusOK},
+	}
+	for idx, item := range rights {
+		ctx := ContextWithUser(context.Background(), item.caller)
+		var err error
+		switch params := item.params.(type) {
+		case UpdateParams:
+			_, err = api.Update(ctx, params)
+		case DeleteParams:
+			_, err = api.Delete(ctx, params)
+		case ListParams:
+			_, err = api.List(ctx, params)
+		}
+		status := http.StatusOK
+		if err != nil {
+			status = apiErrorStatus(err)
+		}
+		if status != item.status {
+			t.Errorf("[%d] %s %#v: expected status %v, got %v", idx, item.caller.Login, item.params, item.status, status)
+		}
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	// векторы из RFC 7914, раздел 11
+	cases := []struct {
+		password   string
+		salt       string
+		iterations int
+		keyLen     int
+		expected   string
+	}{
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, item := range cases {
+		key := pbkdf2SHA256([]byte(item.password), []byte(item.salt), item.iterations, item.keyLen)
+		if fmt.Sprintf("%x", key) != item.expected {
+			t.Errorf("pbkdf2(%q, %q, %d): expected %s, got %x", item.password, item.salt, item.iterations, item.expected, key)
+		}
+	}
+
+	hash := hashPassword("secret")
+	if !checkPassword(hash, "secret") || checkPassword(hash, "Secret") || checkPassword("", "") {
+		t.Errorf("checkPassword does not match hashPassword")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	api := NewMyApi()
+	do := func(method string, path string, query string, token string) (int, CR) {
+		var req *http.Request
+		if method == http.MethodPost {
+			req = httptest.NewRequest(method, path, strings.NewReader(query))
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, path+"?"+query, nil)
+		}
+		if token != "" {
+			req.Header.Add("X-Auth", token)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		result := CR{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+	login := func(login string, password string) string {
+		code, res := do(http.MethodPost, "/user/login", "login="+login+"&password="+password, "")
+		if code != http.StatusOK {
+			t.Fatalf("login %s: %v %v", login, code, res)
+		}
+		return res["response"].(map[string]interface{})["token"].(string)
+	}
+
+	if code, res := do(http.MethodPost, "/user/login", "login=rvasily&password=wrong", ""); code != http.StatusUnauthorized || res["error"] != "bad login or password" {
+		t.Errorf("wrong password: got %v %v", code, res)
+	}
+	if code, _ := do(http.MethodPost, "/user/login", "login=nobody&password=wrong", ""); code != http.StatusUnauthorized {
+		t.Errorf("unknown login: expected %v, got %v", http.StatusUnauthorized, code)
+	}
+
+	// пока пароль не задан, под rvasily не войти ни с каким паролем
+	if code, _ := do(http.MethodPost, "/user/login", "login=rvasily&password=anything", ""); code != http.StatusUnauthorized {
+		t.Errorf("admin without password: expected %v, got %v", http.StatusUnauthorized, code)
+	}
+	if err := setInitialPassword(api.users, "rvasily", "Admin-Pass1"); err != nil {
+		t.Fatalf("set admin password: %v", err)
+	}
+	// уже заданный пароль не перезаписывается
+	if err := setInitialPassword(api.users, "rvasily", "Other-Pass1"); err != nil {
+		t.Fatalf("set admin password again: %v", err)
+	}
+	if code, _ := do(http.MethodPost, "/user/login", "login=rvasily&password=Other-Pass1", ""); code != http.StatusUnauthorized {
+		t.Errorf("second initial password: expected %v, got %v", http.StatusUnauthorized, code)
+	}
+
+	admin := login("rvasily", "Admin-Pass1")
+	if code, res := do(http.MethodGet, "/user/list", "", admin); code != http.StatusOK {
+		t.Errorf("list with admin session: got %v %v", code, res)
+	}
+	if code, res := do(http.MethodGet, "/user/list", "", admin+"x"); code != http.StatusForbidden || res["error"] != "invalid token" {
+		t.Errorf("tampered token: got %v %v", code, res)
+	}
+
+	// права берутся из пользователя сессии
+	api.users.Create(&User{Login: "session_user", PasswordHash: hashPassword("user-password")})
+	user := login("session_user", "user-password")
+	if code, _ := do(http.MethodGet, "/user/list", "", user); code != http.StatusForbidden {
+		t.Errorf("list with user session: expected %v, got %v", http.StatusForbidden, code)
+	}
+	if code, res := do(http.MethodPost, "/user/update", "id=43&full_name=Myself", user); code != http.StatusOK {
+		t.Errorf("update self: got %v %v", code, res)
+	}
+
+	// refresh выдаёт новый токен и отзывает старый
+	code, res := do(http.MethodPost, "/user/refresh", "", user)
+	if code != http.StatusOK {
+		t.Fatalf("refresh: got %v %v", code, res)
+	}
+	refreshed := res["response"].(map[string]interface{})["token"].(string)
+	if code, res := do(http.MethodPost, "/user/refresh", "", user); code != http.StatusForbidden || res["error"] != "token revoked" {
+		t.Errorf("old token after refresh: got %v %v", code, res)
+	}
+
+	if code, res := do(http.MethodPost, "/user/logout", "", refreshed); code != http.StatusOK {
+		t.Errorf("logout: got %v %v", code, res)
+	}
+	if code, _ := do(http.MethodPost, "/user/update", "id=43&full_name=Again", refreshed); code != http.StatusForbidden {
+		t.Errorf("token after logout: expected %v, got %v", http.StatusForbidden, code)
+	}
+	if code, _ := do(http.MethodPost, "/user/logout", "", defaultServiceToken); code != http.StatusBadRequest {
+		t.Errorf("logout with service token: expected %v, got %v", http.StatusBadRequest, code)
+	}
+
+	// удалённый пользователь теряет доступ сразу
+	user = login("session_user", "user-password")
+	do(http.MethodDelete, "/user/delete", "id=43", admin)
+	if code, res := do(http.MethodPost, "/user/refresh", "", user); code != http.StatusForbidden || res["error"] != "invalid token" {
+		t.Errorf("token of deleted user: got %v %v", code, res)
+	}
+
+	api.Sessions = NewSessionTokens([]byte("secret"), -time.Minute)
+	expired := login("rvasily", "Admin-Pass1")
+	if code, res := do(http.MethodGet, "/user/list", "", expired); code != http.StatusForbidden || res["error"] != "token expired" {
+		t.Errorf("expired token: got %v %v", code, res)
+	}
+
+	api.ServiceToken = ""
+	if code, _ := do(http.MethodGet, "/user/list", "", defaultServiceToken); code != http.StatusForbidden {
+		t.Errorf("disabled service token: expected %v, got %v", http.StatusForbidden, code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	api := NewMyApi()
+	api.users.Create(&User{Login: "role_user", Status: statusUser})
+	api.users.Create(&User{Login: "role_moder", Status: statusModerator})
+	user, _ := api.Sessions.Issue(mustGetUser(t, api, "role_user").ID)
+	moder, _ := api.Sessions.Issue(mustGetUser(t, api, "role_moder").ID)
+
+	// заводить пользователей могут модераторы, и не выше своего статуса
+	create := func(token string, query string) (int, interface{}) {
+		req := httptest.NewRequest(http.MethodPost, ApiUserCreate, strings.NewReader(query))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("X-Auth", token)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		result := CR{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result["error"]
+	}
+	if code, err := create(user, "login=role_new_admin&age=20&status=admin&password=Admin-Pass1"); code != http.StatusForbidden {
+		t.Errorf("create admin with user session: expected %v, got %v %v", http.StatusForbidden, code, err)
+	}
+	if code, err := create(moder, "login=role_new_admin&age=20&status=admin"); code != http.StatusForbidden || err != "not enough rights to set status admin" {
+		t.Errorf("create admin with moderator session: expected %v, got %v %v", http.StatusForbidden, code, err)
+	}
+	if code, err := create(moder, "login=role_new_moder&age=20&status=moderator"); code != http.StatusOK {
+		t.Errorf("create moderator with moderator session: expected %v, got %v %v", http.StatusOK, code, err)
+	}
+
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		status int
+		error  string
+	}{
+		{http.MethodGet, "/user/list", user, http.StatusForbidden, "forbidden: role user is not allowed, required one of: moderator, admin"},
+		{http.MethodGet, "/user/list", moder, http.StatusOK, ""},
+		{http.MethodDelete, "/user/delete?id=42", moder, http.StatusForbidden, "forbidden: role moderator is not allowed, required one of: admin"},
+		{http.MethodDelete, "/user/delete?id=44", defaultServiceToken, http.StatusOK, ""},
+	}
+	for idx, item := range cases {
+		req := httptest.NewRequest(item.method, item.path, nil)
+		req.Header.Add("X-Auth", item.token)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		result := CR{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		if w.Code != item.status || result["error"] != item.error {
+			t.Errorf("[%d] %s %s: expected %v %q, got %v %v", idx, item.method, item.path, item.status, item.error, w.Code, result["error"])
+		}
+	}
+
+	// по JSON-RPC роль проверяется так же
+	req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(`{"jsonrpc": "2.0", "method": "MyApi.List", "params": {}, "id": 1}`))
+	req.Header.Add("X-Auth", user)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"message":"forbidden: role user is not allowed`) {
+		t.Errorf("rpc List with user role: got %s", w.Body.String())
+	}
+}
+
+// Пустое значение без omitempty по-прежнему проверяется min и classes, с omitempty - пропускается
+func TestValidParamStrEmpty(t *testing.T) {
+	cases := []struct {
+		Restrictions string
+		Value        string
+		Error        string
+	}{
+		{"min=3", "", "nick len must be >= 3"},
+		{"classes=digit", "", "nick must contain a digit"},
+		{"min=3,omitempty", "", ""},
+		{"classes=digit,omitempty", "", ""},
+		{"min=3,omitempty", "ab", "nick len must be >= 3"},
+		{"classes=digit,omitempty", "ab", "nick must contain a digit"},
+		{"required,min=3,omitempty", "", "nick must me not empty"},
+	}
+	for _, item := range cases {
+		_, err, _ := validParamStr("Nick", item.Restrictions, map[string]string{"nick": item.Value})
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != item.Error {
+			t.Errorf("%s, %q: expected error %q, got %q", item.Restrictions, item.Value, item.Error, got)
+		}
+	}
+}
+
+func TestPasswords(t *testing.T) {
+	logs := &bytes.Buffer{}
+	api := NewMyApi()
+	api.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+	bodies := &bytes.Buffer{}
+	do := func(path string, query string, token string) (int, CR) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(query))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Add("X-Auth", token)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		bodies.Write(w.Body.Bytes())
+		result := CR{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+
+	weak := []struct {
+		password string
+		error    string
+	}{
+		{"Sh0rt", "password len must be >= 8"},
+		{"alllower1", "password must contain an uppercase letter"},
+		{"ALLUPPER1", "password must contain a lowercase letter"},
+		{"NoDigitsHere", "password must contain a digit"},
+	}
+	for _, item := range weak {
+		code, res := do(ApiUserCreate, "login=password_user&age=30&password="+item.password, defaultServiceToken)
+		if code != http.StatusBadRequest || res["error"] != item.error {
+			t.Errorf("weak password %q: got %v %v", item.password, code, res)
+		}
+	}
+
+	if code, res := do(ApiUserCreate, "login=password_user&age=30&password=Secret-Pass1", defaultServiceToken); code != http.StatusOK {
+		t.Fatalf("create with password: got %v %v", code, res)
+	}
+	user := mustGetUser(t, api, "password_user")
+	if !strings.HasPrefix(user.PasswordHash, passwordScheme+"$") || strings.Contains(user.PasswordHash, "Secret-Pass1") {
+		t.Errorf("password stored as %q, expected pbkdf2 hash", user.PasswordHash)
+	}
+	code, res := do("/user/login", "login=password_user&password=Secret-Pass1", "")
+	if code != http.StatusOK {
+		t.Fatalf("login: got %v %v", code, res)
+	}
+	token := res["response"].(map[string]interface{})["token"].(string)
+
+	if code, res := do("/user/password", "old_password=Wrong-Pass1&new_password=Other-Pass2", token); code != http.StatusForbidden || res["error"] != "bad old_password" {
+		t.Errorf("change with wrong old password: got %v %v", code, res)
+	}
+	if code, res := do("/user/password", "old_password=Secret-Pass1&new_password=weak", token); code != http.StatusBadRequest || res["error"] != "new_password len must be >= 8" {
+		t.Errorf("change to weak password: got %v %v", code, res)
+	}
+	if code, res := do("/user/password", "old_password=Secret-Pass1&new_password=Other-Pass2", token); code != http.StatusOK {
+		t.Errorf("change password: got %v %v", code, res)
+	}
+	// сессии, выданные до смены пароля, больше не действуют
+	if code, res := do("/user/password", "old_password=Other-Pass2&new_password=Other-Pass3", token); code != http.StatusForbidden || res["error"] != "token revoked" {
+		t.Errorf("old session after password change: got %v %v", code, res)
+	}
+	if code, _ := do("/user/login", "login=password_user&password=Secret-Pass1", ""); code != http.StatusUnauthorized {
+		t.Errorf("login with old password: expected %v, got %v", http.StatusUnauthorized, code)
+	}
+	code, res = do("/user/login", "login=password_user&password=Other-Pass2", "")
+	if code != http.StatusOK {
+		t.Fatalf("login with new password: got %v %v", code, res)
+	}
+	fresh := res["response"].(map[string]interface{})["token"].(string)
+	if code, res := do("/user/password", "old_password=Wrong-Pass1&new_password=Other-Pass3", fresh); code != http.StatusForbidden || res["error"] != "bad old_password" {
+		t.Errorf("new session after password change: got %v %v", code, res)
+	}
+	if code, res := do("/user/password", "old_password=x&new_password=Other-Pass3", defaultServiceToken); code != http.StatusBadRequest || res["error"] != "service account has no password" {
+		t.Errorf("change password of service account: got %v %v", code, res)
+	}
+
+	for _, secret := range []string{"Secret-Pass1", "Other-Pass2", "Wrong-Pass1", "alllower1"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("password %q leaked to log", secret)
+		}
+		if strings.Contains(bodies.String(), secret) {
+			t.Errorf("password %q leaked to response", secret)
+		}
+	}
+	if strings.Contains(bodies.String(), "pbkdf2") {
+		t.Errorf("password hash leaked to response")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	api := NewMyApi()
+	api.users.Create(&User{Login: "audit_user", Status: statusUser})
+	user, _ := api.Sessions.Issue(mustGetUser(t, api, "audit_user").ID)
+	do := func(method string, path string, query string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(query))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("X-Auth", token)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+	trail := func(query string) []interface{} {
+		w := do(http.MethodGet, "/audit?"+query, "", defaultServiceToken)
+		res := CR{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if w.Code != http.StatusOK {
+			t.Fatalf("audit %q: got %v %v", query, w.Code, res)
+		}
+		return res["response"].(map[string]interface{})["records"].([]interface{})
+	}
+
+	created := do(http.MethodPost, ApiUserCreate, "login=audited_user&age=20&password=Secret-Pass1", defaultServiceToken)
+	do(http.MethodPost, "/user/update", "id=44&full_name=Audited", defaultServiceToken)
+	do(http.MethodPost, "/user/update", "id=43&full_name=Self", user)
+	// неудачные и читающие вызовы в журнал не попадают
+	do(http.MethodPost, ApiUserCreate, "login=audited_user&age=20", defaultServiceToken)
+	do(http.MethodGet, ApiUserProfile+"?login=audited_user", "", "")
+	do(http.MethodPost, "/rpc", `{"jsonrpc": "2.0", "method": "MyApi.Delete", "params": {"id": 44}, "id": 1}`, defaultServiceToken)
+
+	records := trail("")
+	if len(records) != 4 {
+		t.Fatalf("expected 4 audit records, got %v", records)
+	}
+	first := records[0].(map[string]interface{})
+	expected := map[string]interface{}{
+		"user":       "service",
+		"endpoint":   ApiUserCreate,
+		"method":     http.MethodPost,
+		"result_id":  "44",
+		"request_id": created.Header().Get("X-Request-ID"),
+	}
+	for k, v := range expected {
+		if first[k] != v {
+			t.Errorf("audit field %s: expected %v, got %v", k, v, first[k])
+		}
+	}
+	params := first["params"].(map[string]interface{})
+	if params["login"] != "audited_user" || params["password"] != redactedParam {
+		t.Errorf("audit params not sanitised: %v", params)
+	}
+	if last := records[3].(map[string]interface{}); last["endpoint"] != "/user/delete" || last["result_id"] != "44" {
+		t.Errorf("rpc delete not audited: %v", last)
+	}
+
+	if records := trail("user=audit_user"); len(records) != 1 || records[0].(map[string]interface{})["endpoint"] != "/user/update" {
+		t.Errorf("audit by user: got %v", records)
+	}
+	if records := trail("limit=2"); len(records) != 2 {
+		t.Errorf("audit with limit: expected 2 records, got %v", len(records))
+	}
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	if records := trail("from=" + future); len(records) != 0 {
+		t.Errorf("audit from the future: got %v", records)
+	}
+	if records := trail("to=" + future); len(records) != 4 {
+		t.Errorf("audit until the future: expected 4 records, got %v", len(records))
+	}
+	if w := do(http.MethodGet, "/audit?from=200&to=100", "", defaultServiceToken); w.Code != http.StatusBadRequest {
+		t.Errorf("audit with to before from: expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+	if w := do(http.MethodGet, "/audit", "", user); w.Code != http.StatusForbidden {
+		t.Errorf("audit as user: expected %v, got %v", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestInMemoryAuditLogMax(t *testing.T) {
+	audit := &InMemoryAuditLog{Max: 2}
+	for _, principal := range []string{"alice", "bob", "carol"} {
+		audit.WriteAudit(AuditEntry{Principal: principal})
+	}
+	entries, _ := audit.Query(AuditFilter{})
+	if len(entries) != 2 || entries[0].Principal != "bob" || entries[1].Principal != "carol" {
+		t.Errorf("expected the last 2 entries, got %v", entries)
+	}
+}
+
+func TestFileAuditLog(t *testing.T) {
+	path := t.TempDir() + "/audit.log"
+	audit, err := OpenFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, principal := range []string{"alice", "bob", "alice"} {
+		audit.WriteAudit(AuditEntry{Time: start.Add(time.Duration(i) * time.Hour), Principal: principal, Endpoint: "/user/update"})
+	}
+	audit.Close()
+
+	// хвост недописанной записи отбрасывается при открытии
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString(`{"time":"2024-01-01T05:00:00Z","princ`)
+	file.Close()
+	audit, err = OpenFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	audit.WriteAudit(AuditEntry{Time: start.Add(3 * time.Hour), Principal: "bob"})
+
+	cases := []struct {
+		filter   AuditFilter
+		expected []string
+	}{
+		{AuditFilter{}, []string{"alice", "bob", "alice", "bob"}},
+		{AuditFilter{Principal: "alice"}, []string{"alice", "alice"}},
+		{AuditFilter{From: start.Add(time.Hour), To: start.Add(3 * time.Hour)}, []string{"bob", "alice"}},
+		{AuditFilter{Limit: 1}, []string{"alice"}},
+	}
+	for idx, item := range cases {
+		entries, err := audit.Query(item.filter)
+		if err != nil {
+			t.Fatalf("[%d] query: %v", idx, err)
+		}
+		got := []string{}
+		for _, entry := range entries {
+			got = append(got, entry.Principal)
+		}
+		if !reflect.DeepEqual(got, item.expected) {
+			t.Errorf("[%d] expected %v, got %v", idx, item.expected, got)
+		}
+	}
+}
+
+func mustGetUser(t *testing.T, api *MyApi, login string) *User {
+	user, err := api.users.GetByLogin(login)
+	if err != nil {
+		t.Fatalf("get %s: %v", login, err)
+	}
+	return user
+}
+
 func runTests(t *testing.T, ts *httptest.Server, cases []Case) {
 	for idx, item := range cases {
 		var (
@@ -342,3 +1710,151 @@ func runTests(t *testing.T, ts *httptest.Server, cases []Case) {
 		}
 	}
 }
+
+type RPCCase struct {
+	Body   string
+	Auth   bool
+	Status int
+	Result interface{}
+}
+
+func TestMyApiJSONRPC(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+
+	cases := []RPCCase{
+		RPCCase{ // 0 успешный вызов
+			Body:   `{"jsonrpc": "2.0", "method": "MyApi.Profile", "params": {"login": "rvasily"}, "id": 1}`,
+			Status: http.StatusOK,
+			Result: CR{
+				"jsonrpc": "2.0",
+				"id":      1,
+				"result": CR{
+					"id":        42,
+					"login":     "rvasily",
+					"full_name": "Vasily Romanov",
+					"status":    20,
+				},
+			},
+		},
+		RPCCase{ // 1 те же валидаторы, ошибка превращается в invalid params
+			Body:   `{"jsonrpc": "2.0", "method": "MyApi.Create", "params": {"login": "new_m", "age": 32}, "id": "a"}`,
+			Auth:   true,
+			Status: http.StatusOK,
+			Result: CR{
+				"jsonrpc": "2.0",
+				"id":      "a",
+				"error": CR{
+					"code":    -32602,
+					"message": "login len must be >= 10",
+					"data":    CR{"status": http.StatusBadRequest},
+				},
+			},
+		},
+		RPCCase{ // 2 авторизация
+			Body:   `{"jsonrpc": "2.0", "method": "MyApi.Create", "params": {"login": "new_moderator"}, "id": 2}`,
+			Status: http.StatusOK,
+			Result: CR{
+				"jsonrpc": "2.0",
+				"id":      2,
+				"error": CR{
+					"code":    -32001,
+					"message": "unauthorized",
+					"data":    CR{"status": http.StatusForbidden},
+				},
+			},
+		},
+		RPCCase{ // 3 батч: позиционные параметры, ApiError из метода, уведомление без ответа и неизвестный метод
+			Body: `[
+				{"jsonrpc": "2.0", "method": "MyApi.Create", "params": ["rpc_moderator", "Ivan Ivanov", "moderator", 32], "id": 3},
+				{"jsonrpc": "2.0", "method": "MyApi.Profile", "params": {"login": "not_exist_user"}, "id": 4},
+				{"jsonrpc": "2.0", "method": "MyApi.Profile", "params": {"login": "rvasily"}},
+				{"jsonrpc": "2.0", "method": "MyApi.Purge", "id": 5}
+			]`,
+			Auth:   true,
+			Status: http.StatusOK,
+			Result: []CR{
+				CR{"jsonrpc": "2.0", "id": 3, "result": CR{"id": 43}},
+				CR{"jsonrpc": "2.0", "id": 4, "error": CR{"code": -32000, "message": "user not exist", "data": CR{"status": http.StatusNotFound}}},
+				CR{"jsonrpc": "2.0", "id": 5, "error": CR{"code": -32601, "message": "method not found"}},
+			},
+		},
+		RPCCase{ // 4 кривой json
+			Body:   `{"jsonrpc": "2.0", "method"`,
+			Status: http.StatusOK,
+			Result: CR{
+				"jsonrpc": "2.0",
+				"id":      nil,
+				"error":   CR{"code": -32700, "message": "parse error"},
+			},
+		},
+		RPCCase{ // 5 только уведомления - пустой ответ
+			Body:   `[{"jsonrpc": "2.0", "method": "MyApi.Profile", "params": {"login": "rvasily"}}]`,
+			Status: http.StatusNoContent,
+		},
+	}
+
+	for idx, item := range cases {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/rpc", strings.NewReader(item.Body))
+		req.Header.Add("Content-Type", "application/json")
+		if item.Auth {
+			req.Header.Add("X-Auth", "100500")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("[%d] request error: %v", idx, err)
+			continue
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%d] expected http status %v, got %v", idx, item.Status, resp.StatusCode)
+			continue
+		}
+		if item.Result == nil {
+			if len(body) != 0 {
+				t.Errorf("[%d] expected empty body, got %s", idx, body)
+			}
+			continue
+		}
+
+		var result, expected interface{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Errorf("[%d] cant unpack json: %v", idx, err)
+			continue
+		}
+		data, _ := json.Marshal(item.Result)
+		json.Unmarshal(data, &expected)
+
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("[%d] results not match\nGot: %#v\nExpected: %#v", idx, result, item.Result)
+		}
+	}
+}
+
+func TestGeneratedClient(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+	api := apiclient.NewMyApiClient(ts.URL, defaultServiceToken)
+	ctx := context.Background()
+
+	created, err := api.Create(ctx, apiclient.CreateParams{Login: "client_user", Age: 20})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	user, err := api.Profile(ctx, apiclient.ProfileParams{Login: "client_user"})
+	if err != nil || user.ID != created.ID {
+		t.Fatalf("profile: got %+v, %v", user, err)
+	}
+
+	// DELETE шлёт параметры в query, как их читает сервер
+	deleted, err := api.Delete(ctx, apiclient.DeleteParams{ID: int(created.ID)})
+	if err != nil || deleted.Login != "client_user" {
+		t.Fatalf("delete: got %+v, %v", deleted, err)
+	}
+	_, err = api.Profile(ctx, apiclient.ProfileParams{Login: "client_user"})
+	if apiErr, ok := err.(apiclient.ApiError); !ok || apiErr.HTTPStatus != http.StatusNotFound {
+		t.Errorf("profile after delete: expected ApiError 404, got %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+// Code generated by codegen; DO NOT EDIT.
+
+package main
+
+import "testing"
+
+func FuzzMyApiProfileHTTPHandler(f *testing.F) {
+	f.Add("GET", "", "", "", "", "")
+	f.Add("POST", "", "", "", "", "")
+	f.Add("GET", "=", "", "", "", "")
+	f.Add("POST", "", "=", "", "", "")
+	f.Add("GET", "&&", "", "", "", "")
+	f.Add("POST", "", "&&", "", "", "")
+	f.Add("GET", "a=b=c", "", "", "", "")
+	f.Add("POST", "", "a=b=c", "", "", "")
+	f.Add("GET", "%zz=%", "", "", "", "")
+	f.Add("POST", "", "%zz=%", "", "", "")
+	f.Add("GET", "%ff%fe=1", "", "", "", "")
+	f.Add("POST", "", "%ff%fe=1", "", "", "")
+	f.Add("GET", "login=a", "", "", "Idempotency-Key", "retry-1")
+	f.Add("GET", "login=a", "", "", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("GET", "login=a", "", "", "traceparent", "00-zz-zz-zz")
+	f.Add("GET", "login=a", "", "", "Origin", "https://evil.example")
+	f.Add("GET", "login=a", "", "", "X-Request-ID", "bad id\n")
+	f.Add("GET", "login=a", "", "", "If-None-Match", "\"abc\"")
+	f.Add("GET", "login=a", "", "", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/profile", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiCreateHTTPHandler(f *testing.F) {
+	f.Add("GET", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "", "", "")
+	f.Add("POST", "", "age=0&full_name=a&password=aA1aaaaa&status=user", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaa&password=aA1aaaaa&status=user", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=z", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa", "100500", "", "")
+	f.Add("POST", "", "age=abc&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "", "")
+	f.Add("POST", "", "age=129&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "", "")
+	f.Add("POST", "", "age=-1&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&status=user", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aaaaaaa&status=user", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=A1AAAAAA&status=user", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=a1aaaaaa&status=user", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aAaaaaaa&status=user", "100500", "", "")
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "Idempotency-Key", "retry-1")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "Origin", "https://evil.example")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "X-Request-ID", "bad id\n")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "If-None-Match", "\"abc\"")
+	f.Add("POST", "", "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/create", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiUpdateHTTPHandler(f *testing.F) {
+	f.Add("GET", "full_name=a&id=1&status=user", "", "100500", "", "")
+	f.Add("POST", "", "full_name=a&id=1&status=user", "", "", "")
+	f.Add("POST", "", "full_name=a&status=user", "100500", "", "")
+	f.Add("POST", "", "full_name=a&id=abc&status=user", "100500", "", "")
+	f.Add("POST", "", "full_name=a&id=0&status=user", "100500", "", "")
+	f.Add("POST", "", "full_name=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&id=1&status=user", "100500", "", "")
+	f.Add("POST", "", "full_name=a&id=1&status=z", "100500", "", "")
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("POST", "", "full_name=a&id=1&status=user", "100500", "Idempotency-Key", "retry-1")
+	f.Add("POST", "", "full_name=a&id=1&status=user", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("POST", "", "full_name=a&id=1&status=user", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("POST", "", "full_name=a&id=1&status=user", "100500", "Origin", "https://evil.example")
+	f.Add("POST", "", "full_name=a&id=1&status=user", "100500", "X-Request-ID", "bad id\n")
+	f.Add("POST", "", "full_name=a&id=1&status=user", "100500", "If-None-Match", "\"abc\"")
+	f.Add("POST", "", "full_name=a&id=1&status=user", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/update", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiDeleteHTTPHandler(f *testing.F) {
+	f.Add("POST", "", "id=1", "100500", "", "")
+	f.Add("DELETE", "id=1", "", "", "", "")
+	f.Add("DELETE", "", "", "100500", "", "")
+	f.Add("DELETE", "id=abc", "", "100500", "", "")
+	f.Add("DELETE", "id=0", "", "100500", "", "")
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("DELETE", "id=1", "", "100500", "Idempotency-Key", "retry-1")
+	f.Add("DELETE", "id=1", "", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("DELETE", "id=1", "", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("DELETE", "id=1", "", "100500", "Origin", "https://evil.example")
+	f.Add("DELETE", "id=1", "", "100500", "X-Request-ID", "bad id\n")
+	f.Add("DELETE", "id=1", "", "100500", "If-None-Match", "\"abc\"")
+	f.Add("DELETE", "id=1", "", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/delete", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiListHTTPHandler(f *testing.F) {
+	f.Add("POST", "", "cursor=a&limit=1&login_prefix=a&status=user", "100500", "", "")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=user", "", "", "", "")
+	f.Add("GET", "cursor=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&limit=1&login_prefix=a&status=user", "", "100500", "", "")
+	f.Add("GET", "cursor=a&limit=abc&login_prefix=a&status=user", "", "100500", "", "")
+	f.Add("GET", "cursor=a&limit=101&login_prefix=a&status=user", "", "100500", "", "")
+	f.Add("GET", "cursor=a&limit=0&login_prefix=a&status=user", "", "100500", "", "")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=z", "", "100500", "", "")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&status=user", "", "100500", "", "")
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=user", "", "100500", "Idempotency-Key", "retry-1")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=user", "", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=user", "", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=user", "", "100500", "Origin", "https://evil.example")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=user", "", "100500", "X-Request-ID", "bad id\n")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=user", "", "100500", "If-None-Match", "\"abc\"")
+	f.Add("GET", "cursor=a&limit=1&login_prefix=a&status=user", "", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/list", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiLoginHTTPHandler(f *testing.F) {
+	f.Add("GET", "login=a&password=a", "", "", "", "")
+	f.Add("POST", "", "password=a", "", "", "")
+	f.Add("POST", "", "login=a", "", "", "")
+	f.Add("GET", "", "", "", "", "")
+	f.Add("POST", "", "", "", "", "")
+	f.Add("GET", "=", "", "", "", "")
+	f.Add("POST", "", "=", "", "", "")
+	f.Add("GET", "&&", "", "", "", "")
+	f.Add("POST", "", "&&", "", "", "")
+	f.Add("GET", "a=b=c", "", "", "", "")
+	f.Add("POST", "", "a=b=c", "", "", "")
+	f.Add("GET", "%zz=%", "", "", "", "")
+	f.Add("POST", "", "%zz=%", "", "", "")
+	f.Add("GET", "%ff%fe=1", "", "", "", "")
+	f.Add("POST", "", "%ff%fe=1", "", "", "")
+	f.Add("POST", "", "login=a&password=a", "", "Idempotency-Key", "retry-1")
+	f.Add("POST", "", "login=a&password=a", "", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("POST", "", "login=a&password=a", "", "traceparent", "00-zz-zz-zz")
+	f.Add("POST", "", "login=a&password=a", "", "Origin", "https://evil.example")
+	f.Add("POST", "", "login=a&password=a", "", "X-Request-ID", "bad id\n")
+	f.Add("POST", "", "login=a&password=a", "", "If-None-Match", "\"abc\"")
+	f.Add("POST", "", "login=a&password=a", "", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/login", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiLogoutHTTPHandler(f *testing.F) {
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("POST", "", "", "100500", "Idempotency-Key", "retry-1")
+	f.Add("POST", "", "", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("POST", "", "", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("POST", "", "", "100500", "Origin", "https://evil.example")
+	f.Add("POST", "", "", "100500", "X-Request-ID", "bad id\n")
+	f.Add("POST", "", "", "100500", "If-None-Match", "\"abc\"")
+	f.Add("POST", "", "", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/logout", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiRefreshHTTPHandler(f *testing.F) {
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("POST", "", "", "100500", "Idempotency-Key", "retry-1")
+	f.Add("POST", "", "", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("POST", "", "", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("POST", "", "", "100500", "Origin", "https://evil.example")
+	f.Add("POST", "", "", "100500", "X-Request-ID", "bad id\n")
+	f.Add("POST", "", "", "100500", "If-None-Match", "\"abc\"")
+	f.Add("POST", "", "", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/refresh", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiChangePasswordHTTPHandler(f *testing.F) {
+	f.Add("GET", "new_password=aA1aaaaa&old_password=a", "", "100500", "", "")
+	f.Add("POST", "", "new_password=aA1aaaaa&old_password=a", "", "", "")
+	f.Add("POST", "", "new_password=aA1aaaaa", "100500", "", "")
+	f.Add("POST", "", "old_password=a", "100500", "", "")
+	f.Add("POST", "", "new_password=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&old_password=a", "100500", "", "")
+	f.Add("POST", "", "new_password=aaaaaaa&old_password=a", "100500", "", "")
+	f.Add("POST", "", "new_password=A1AAAAAA&old_password=a", "100500", "", "")
+	f.Add("POST", "", "new_password=a1aaaaaa&old_password=a", "100500", "", "")
+	f.Add("POST", "", "new_password=aAaaaaaa&old_password=a", "100500", "", "")
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("POST", "", "new_password=aA1aaaaa&old_password=a", "100500", "Idempotency-Key", "retry-1")
+	f.Add("POST", "", "new_password=aA1aaaaa&old_password=a", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("POST", "", "new_password=aA1aaaaa&old_password=a", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("POST", "", "new_password=aA1aaaaa&old_password=a", "100500", "Origin", "https://evil.example")
+	f.Add("POST", "", "new_password=aA1aaaaa&old_password=a", "100500", "X-Request-ID", "bad id\n")
+	f.Add("POST", "", "new_password=aA1aaaaa&old_password=a", "100500", "If-None-Match", "\"abc\"")
+	f.Add("POST", "", "new_password=aA1aaaaa&old_password=a", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/user/password", method, query, body, auth, headerName, headerValue)
+	})
+}
+
+func FuzzMyApiAuditHTTPHandler(f *testing.F) {
+	f.Add("POST", "", "from=0&limit=1&to=0&user=a", "100500", "", "")
+	f.Add("GET", "from=0&limit=1&to=0&user=a", "", "", "", "")
+	f.Add("GET", "from=0&limit=1&to=0&user=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "", "100500", "", "")
+	f.Add("GET", "from=abc&limit=1&to=0&user=a", "", "100500", "", "")
+	f.Add("GET", "from=-1&limit=1&to=0&user=a", "", "100500", "", "")
+	f.Add("GET", "from=0&limit=1&to=abc&user=a", "", "100500", "", "")
+	f.Add("GET", "from=0&limit=1&to=-1&user=a", "", "100500", "", "")
+	f.Add("GET", "from=0&limit=abc&to=0&user=a", "", "100500", "", "")
+	f.Add("GET", "from=0&limit=1001&to=0&user=a", "", "100500", "", "")
+	f.Add("GET", "from=0&limit=0&to=0&user=a", "", "100500", "", "")
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("GET", "from=0&limit=1&to=0&user=a", "", "100500", "Idempotency-Key", "retry-1")
+	f.Add("GET", "from=0&limit=1&to=0&user=a", "", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("GET", "from=0&limit=1&to=0&user=a", "", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("GET", "from=0&limit=1&to=0&user=a", "", "100500", "Origin", "https://evil.example")
+	f.Add("GET", "from=0&limit=1&to=0&user=a", "", "100500", "X-Request-ID", "bad id\n")
+	f.Add("GET", "from=0&limit=1&to=0&user=a", "", "100500", "If-None-Match", "\"abc\"")
+	f.Add("GET", "from=0&limit=1&to=0&user=a", "", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewMyApi(), "/audit", method, query, body, auth, headerName, headerValue)
+	})
+}
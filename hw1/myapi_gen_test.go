@@ -0,0 +1,613 @@
+// Code generated by codegen; DO NOT EDIT.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMyApiGenerated(t *testing.T) {
+	cases := []apigenCase{
+		{
+			Name:   "Profile: login missing",
+			Method: "GET",
+			Path:   "/user/profile",
+			Query:  "",
+			Auth:   false,
+			Status: 400,
+			Error:  "login must me not empty",
+		},
+		{
+			Name:   "Create: wrong method",
+			Method: "GET",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "Create: missing auth",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "Create: login missing",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&password=aA1aaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "login must me not empty",
+		},
+		{
+			Name:   "Create: login below min",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaa&password=aA1aaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "login len must be >= 10",
+		},
+		{
+			Name:   "Create: status out of enum",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=z",
+			Auth:   true,
+			Status: 400,
+			Error:  "status must be one of [user, moderator, admin]",
+		},
+		{
+			Name:        "Create: status default applied",
+			Method:      "POST",
+			Path:        "/user/create",
+			Query:       "age=0&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa",
+			Auth:        true,
+			ExpectValid: true,
+		},
+		{
+			Name:   "Create: age wrong type",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=abc&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "age must be int",
+		},
+		{
+			Name:   "Create: age above max",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=129&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "age must be <= 128",
+		},
+		{
+			Name:   "Create: age below min",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=-1&full_name=a&login=aaaaaaaaaa&password=aA1aaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "age must be >= 0",
+		},
+		{
+			Name:   "Create: password above max",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaaa&password=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "password len must be <= 128",
+		},
+		{
+			Name:   "Create: password below min",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaaa&password=aaaaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "password len must be >= 8",
+		},
+		{
+			Name:   "Create: password missing lower",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaaa&password=A1AAAAAA&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "password must contain a lowercase letter",
+		},
+		{
+			Name:   "Create: password missing upper",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaaa&password=a1aaaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "password must contain an uppercase letter",
+		},
+		{
+			Name:   "Create: password missing digit",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "age=0&full_name=a&login=aaaaaaaaaa&password=aAaaaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "password must contain a digit",
+		},
+		{
+			Name:   "Update: wrong method",
+			Method: "GET",
+			Path:   "/user/update",
+			Query:  "full_name=a&id=1&status=user",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "Update: missing auth",
+			Method: "POST",
+			Path:   "/user/update",
+			Query:  "full_name=a&id=1&status=user",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "Update: id missing",
+			Method: "POST",
+			Path:   "/user/update",
+			Query:  "full_name=a&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "id must me not empty",
+		},
+		{
+			Name:   "Update: id wrong type",
+			Method: "POST",
+			Path:   "/user/update",
+			Query:  "full_name=a&id=abc&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "id must be int",
+		},
+		{
+			Name:   "Update: id below min",
+			Method: "POST",
+			Path:   "/user/update",
+			Query:  "full_name=a&id=0&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "id must be >= 1",
+		},
+		{
+			Name:   "Update: full_name above max",
+			Method: "POST",
+			Path:   "/user/update",
+			Query:  "full_name=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&id=1&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "full_name len must be <= 128",
+		},
+		{
+			Name:   "Update: status out of enum",
+			Method: "POST",
+			Path:   "/user/update",
+			Query:  "full_name=a&id=1&status=z",
+			Auth:   true,
+			Status: 400,
+			Error:  "status must be one of [user, moderator, admin]",
+		},
+		{
+			Name:   "Delete: wrong method",
+			Method: "POST",
+			Path:   "/user/delete",
+			Query:  "id=1",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "Delete: missing auth",
+			Method: "DELETE",
+			Path:   "/user/delete",
+			Query:  "id=1",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "Delete: id missing",
+			Method: "DELETE",
+			Path:   "/user/delete",
+			Query:  "",
+			Auth:   true,
+			Status: 400,
+			Error:  "id must me not empty",
+		},
+		{
+			Name:   "Delete: id wrong type",
+			Method: "DELETE",
+			Path:   "/user/delete",
+			Query:  "id=abc",
+			Auth:   true,
+			Status: 400,
+			Error:  "id must be int",
+		},
+		{
+			Name:   "Delete: id below min",
+			Method: "DELETE",
+			Path:   "/user/delete",
+			Query:  "id=0",
+			Auth:   true,
+			Status: 400,
+			Error:  "id must be >= 1",
+		},
+		{
+			Name:   "List: wrong method",
+			Method: "POST",
+			Path:   "/user/list",
+			Query:  "cursor=a&limit=1&login_prefix=a&status=user",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "List: missing auth",
+			Method: "GET",
+			Path:   "/user/list",
+			Query:  "cursor=a&limit=1&login_prefix=a&status=user",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "List: cursor above max",
+			Method: "GET",
+			Path:   "/user/list",
+			Query:  "cursor=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&limit=1&login_prefix=a&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "cursor len must be <= 64",
+		},
+		{
+			Name:   "List: limit wrong type",
+			Method: "GET",
+			Path:   "/user/list",
+			Query:  "cursor=a&limit=abc&login_prefix=a&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "limit must be int",
+		},
+		{
+			Name:   "List: limit above max",
+			Method: "GET",
+			Path:   "/user/list",
+			Query:  "cursor=a&limit=101&login_prefix=a&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "limit must be <= 100",
+		},
+		{
+			Name:   "List: limit below min",
+			Method: "GET",
+			Path:   "/user/list",
+			Query:  "cursor=a&limit=0&login_prefix=a&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "limit must be >= 1",
+		},
+		{
+			Name:   "List: status out of enum",
+			Method: "GET",
+			Path:   "/user/list",
+			Query:  "cursor=a&limit=1&login_prefix=a&status=z",
+			Auth:   true,
+			Status: 400,
+			Error:  "status must be one of [user, moderator, admin]",
+		},
+		{
+			Name:   "List: login_prefix above max",
+			Method: "GET",
+			Path:   "/user/list",
+			Query:  "cursor=a&limit=1&login_prefix=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&status=user",
+			Auth:   true,
+			Status: 400,
+			Error:  "login_prefix len must be <= 64",
+		},
+		{
+			Name:   "Login: wrong method",
+			Method: "GET",
+			Path:   "/user/login",
+			Query:  "login=a&password=a",
+			Auth:   false,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "Login: login missing",
+			Method: "POST",
+			Path:   "/user/login",
+			Query:  "password=a",
+			Auth:   false,
+			Status: 400,
+			Error:  "login must me not empty",
+		},
+		{
+			Name:   "Login: password missing",
+			Method: "POST",
+			Path:   "/user/login",
+			Query:  "login=a",
+			Auth:   false,
+			Status: 400,
+			Error:  "password must me not empty",
+		},
+		{
+			Name:   "Logout: wrong method",
+			Method: "GET",
+			Path:   "/user/logout",
+			Query:  "",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "Logout: missing auth",
+			Method: "POST",
+			Path:   "/user/logout",
+			Query:  "",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "Refresh: wrong method",
+			Method: "GET",
+			Path:   "/user/refresh",
+			Query:  "",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "Refresh: missing auth",
+			Method: "POST",
+			Path:   "/user/refresh",
+			Query:  "",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "ChangePassword: wrong method",
+			Method: "GET",
+			Path:   "/user/password",
+			Query:  "new_password=aA1aaaaa&old_password=a",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "ChangePassword: missing auth",
+			Method: "POST",
+			Path:   "/user/password",
+			Query:  "new_password=aA1aaaaa&old_password=a",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "ChangePassword: old_password missing",
+			Method: "POST",
+			Path:   "/user/password",
+			Query:  "new_password=aA1aaaaa",
+			Auth:   true,
+			Status: 400,
+			Error:  "old_password must me not empty",
+		},
+		{
+			Name:   "ChangePassword: new_password missing",
+			Method: "POST",
+			Path:   "/user/password",
+			Query:  "old_password=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "new_password must me not empty",
+		},
+		{
+			Name:   "ChangePassword: new_password above max",
+			Method: "POST",
+			Path:   "/user/password",
+			Query:  "new_password=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa&old_password=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "new_password len must be <= 128",
+		},
+		{
+			Name:   "ChangePassword: new_password below min",
+			Method: "POST",
+			Path:   "/user/password",
+			Query:  "new_password=aaaaaaa&old_password=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "new_password len must be >= 8",
+		},
+		{
+			Name:   "ChangePassword: new_password missing lower",
+			Method: "POST",
+			Path:   "/user/password",
+			Query:  "new_password=A1AAAAAA&old_password=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "new_password must contain a lowercase letter",
+		},
+		{
+			Name:   "ChangePassword: new_password missing upper",
+			Method: "POST",
+			Path:   "/user/password",
+			Query:  "new_password=a1aaaaaa&old_password=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "new_password must contain an uppercase letter",
+		},
+		{
+			Name:   "ChangePassword: new_password missing digit",
+			Method: "POST",
+			Path:   "/user/password",
+			Query:  "new_password=aAaaaaaa&old_password=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "new_password must contain a digit",
+		},
+		{
+			Name:   "Audit: wrong method",
+			Method: "POST",
+			Path:   "/audit",
+			Query:  "from=0&limit=1&to=0&user=a",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "Audit: missing auth",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=0&limit=1&to=0&user=a",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "Audit: user above max",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=0&limit=1&to=0&user=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			Auth:   true,
+			Status: 400,
+			Error:  "user len must be <= 128",
+		},
+		{
+			Name:   "Audit: from wrong type",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=abc&limit=1&to=0&user=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "from must be int",
+		},
+		{
+			Name:   "Audit: from below min",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=-1&limit=1&to=0&user=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "from must be >= 0",
+		},
+		{
+			Name:   "Audit: to wrong type",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=0&limit=1&to=abc&user=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "to must be int",
+		},
+		{
+			Name:   "Audit: to below min",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=0&limit=1&to=-1&user=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "to must be >= 0",
+		},
+		{
+			Name:   "Audit: limit wrong type",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=0&limit=abc&to=0&user=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "limit must be int",
+		},
+		{
+			Name:   "Audit: limit above max",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=0&limit=1001&to=0&user=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "limit must be <= 1000",
+		},
+		{
+			Name:   "Audit: limit below min",
+			Method: "GET",
+			Path:   "/audit",
+			Query:  "from=0&limit=0&to=0&user=a",
+			Auth:   true,
+			Status: 400,
+			Error:  "limit must be >= 1",
+		},
+	}
+
+	runApigenCases(t, func() http.Handler { return NewMyApi() }, cases)
+}
+
+// Default из apivalidator должен дойти до метода: проверяем структуру, которую он получит
+func TestMyApiGeneratedDefaults(t *testing.T) {
+	srv := NewMyApi()
+	t.Run("Create: status default", func(t *testing.T) {
+		in, err, _ := srv.parseCreateParams(map[string]string{"age": "0", "full_name": "a", "login": "aaaaaaaaaa", "password": "aA1aaaaa"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if in.Status != "user" {
+			t.Errorf("expected Status = %v, got %v", "user", in.Status)
+		}
+	})
+	t.Run("List: limit default", func(t *testing.T) {
+		in, err, _ := srv.parseListParams(map[string]string{"cursor": "a", "login_prefix": "a", "status": "user"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if in.Limit != 20 {
+			t.Errorf("expected Limit = %v, got %v", 20, in.Limit)
+		}
+	})
+	t.Run("Audit: from default", func(t *testing.T) {
+		in, err, _ := srv.parseAuditParams(map[string]string{"limit": "1", "to": "0", "user": "a"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if in.From != 0 {
+			t.Errorf("expected From = %v, got %v", 0, in.From)
+		}
+	})
+	t.Run("Audit: to default", func(t *testing.T) {
+		in, err, _ := srv.parseAuditParams(map[string]string{"from": "0", "limit": "1", "user": "a"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if in.To != 0 {
+			t.Errorf("expected To = %v, got %v", 0, in.To)
+		}
+	})
+	t.Run("Audit: limit default", func(t *testing.T) {
+		in, err, _ := srv.parseAuditParams(map[string]string{"from": "0", "to": "0", "user": "a"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if in.Limit != 100 {
+			t.Errorf("expected Limit = %v, got %v", 100, in.Limit)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+// Code generated by codegen; DO NOT EDIT.
+
+package main
+
+import "testing"
+
+func FuzzOtherApiCreateHTTPHandler(f *testing.F) {
+	f.Add("GET", "account_name=a&class=warrior&level=1&username=aaa", "", "100500", "", "")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aaa", "", "", "")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1", "100500", "", "")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aa", "100500", "", "")
+	f.Add("POST", "", "account_name=a&class=z&level=1&username=aaa", "100500", "", "")
+	f.Add("POST", "", "account_name=a&level=1&username=aaa", "100500", "", "")
+	f.Add("POST", "", "account_name=a&class=warrior&level=abc&username=aaa", "100500", "", "")
+	f.Add("POST", "", "account_name=a&class=warrior&level=51&username=aaa", "100500", "", "")
+	f.Add("POST", "", "account_name=a&class=warrior&level=0&username=aaa", "100500", "", "")
+	f.Add("GET", "", "", "100500", "", "")
+	f.Add("POST", "", "", "100500", "", "")
+	f.Add("GET", "=", "", "100500", "", "")
+	f.Add("POST", "", "=", "100500", "", "")
+	f.Add("GET", "&&", "", "100500", "", "")
+	f.Add("POST", "", "&&", "100500", "", "")
+	f.Add("GET", "a=b=c", "", "100500", "", "")
+	f.Add("POST", "", "a=b=c", "100500", "", "")
+	f.Add("GET", "%zz=%", "", "100500", "", "")
+	f.Add("POST", "", "%zz=%", "100500", "", "")
+	f.Add("GET", "%ff%fe=1", "", "100500", "", "")
+	f.Add("POST", "", "%ff%fe=1", "100500", "", "")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aaa", "100500", "Idempotency-Key", "retry-1")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aaa", "100500", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aaa", "100500", "traceparent", "00-zz-zz-zz")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aaa", "100500", "Origin", "https://evil.example")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aaa", "100500", "X-Request-ID", "bad id\n")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aaa", "100500", "If-None-Match", "\"abc\"")
+	f.Add("POST", "", "account_name=a&class=warrior&level=1&username=aaa", "100500", "If-None-Match", "*")
+
+	f.Fuzz(func(t *testing.T, method string, query string, body string, auth string, headerName string, headerValue string) {
+		apigenFuzzServe(t, NewOtherApi(), "/user/create", method, query, body, auth, headerName, headerValue)
+	})
+}
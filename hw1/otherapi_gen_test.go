@@ -0,0 +1,109 @@
+// Code generated by codegen; DO NOT EDIT.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOtherApiGenerated(t *testing.T) {
+	cases := []apigenCase{
+		{
+			Name:   "Create: wrong method",
+			Method: "GET",
+			Path:   "/user/create",
+			Query:  "account_name=a&class=warrior&level=1&username=aaa",
+			Auth:   true,
+			Status: 406,
+			Error:  "bad method",
+		},
+		{
+			Name:   "Create: missing auth",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "account_name=a&class=warrior&level=1&username=aaa",
+			Auth:   false,
+			Status: 403,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "Create: username missing",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "account_name=a&class=warrior&level=1",
+			Auth:   true,
+			Status: 400,
+			Error:  "username must me not empty",
+		},
+		{
+			Name:   "Create: username below min",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "account_name=a&class=warrior&level=1&username=aa",
+			Auth:   true,
+			Status: 400,
+			Error:  "username len must be >= 3",
+		},
+		{
+			Name:   "Create: class out of enum",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "account_name=a&class=z&level=1&username=aaa",
+			Auth:   true,
+			Status: 400,
+			Error:  "class must be one of [warrior, sorcerer, rouge]",
+		},
+		{
+			Name:        "Create: class default applied",
+			Method:      "POST",
+			Path:        "/user/create",
+			Query:       "account_name=a&level=1&username=aaa",
+			Auth:        true,
+			ExpectValid: true,
+		},
+		{
+			Name:   "Create: level wrong type",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "account_name=a&class=warrior&level=abc&username=aaa",
+			Auth:   true,
+			Status: 400,
+			Error:  "level must be int",
+		},
+		{
+			Name:   "Create: level above max",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "account_name=a&class=warrior&level=51&username=aaa",
+			Auth:   true,
+			Status: 400,
+			Error:  "level must be <= 50",
+		},
+		{
+			Name:   "Create: level below min",
+			Method: "POST",
+			Path:   "/user/create",
+			Query:  "account_name=a&class=warrior&level=0&username=aaa",
+			Auth:   true,
+			Status: 400,
+			Error:  "level must be >= 1",
+		},
+	}
+
+	runApigenCases(t, func() http.Handler { return NewOtherApi() }, cases)
+}
+
+// Default из apivalidator должен дойти до метода: проверяем структуру, которую он получит
+func TestOtherApiGeneratedDefaults(t *testing.T) {
+	srv := NewOtherApi()
+	t.Run("Create: class default", func(t *testing.T) {
+		in, err, _ := srv.parseCreateParams(map[string]string{"account_name": "a", "level": "1", "username": "aaa"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if in.Class != "warrior" {
+			t.Errorf("expected Class = %v, got %v", "warrior", in.Class)
+		}
+	})
+}
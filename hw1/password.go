@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Пароли хранятся как pbkdf2-sha256$итерации$соль$ключ
+const (
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 100000
+	passwordSaltLen    = 16
+	passwordKeyLen     = 32
+)
+
+// pbkdf2SHA256 - PBKDF2 из RFC 8018 с HMAC-SHA256
+func pbkdf2SHA256(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (keyLen + prf.Size() - 1) / prf.Size()
+	key := make([]byte, 0, blocks*prf.Size())
+	u := make([]byte, prf.Size())
+	counter := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter, uint32(block))
+		prf.Write(counter)
+		key = prf.Sum(key)
+		t := key[len(key)-prf.Size():]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return key[:keyLen]
+}
+
+func hashPassword(password string) string {
+	salt := make([]byte, passwordSaltLen)
+	rand.Read(salt)
+	key := pbkdf2SHA256([]byte(password), salt, passwordIterations, passwordKeyLen)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// Хэш, с которым сравнивается пароль несуществующего пользователя - чтобы по времени ответа
+// нельзя было понять, есть ли такой логин
+var dummyPasswordHash = sync.OnceValue(func() string { return hashPassword("") })
+
+// checkPassword сравнивает пароль с хэшем; пустой или битый хэш не подходит ни к какому паролю
+func checkPassword(hash string, password string) bool {
+	if hash == "" {
+		checkPassword(dummyPasswordHash(), password)
+		return false
+	}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2SHA256([]byte(password), salt, iterations, len(key)), key) == 1
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not exist")
+	ErrUserExists   = errors.New("user exist")
+)
+
+// UserFilter - выборка для UserStore.List: пользователи с ID больше AfterID по возрастанию ID
+type UserFilter struct {
+	AfterID     uint64
+	Limit       int  // 0 - без ограничения
+	Status      *int // nil - любой статус
+	LoginPrefix string
+}
+
+func (f UserFilter) match(user *User) bool {
+	return user.ID > f.AfterID &&
+		(f.Status == nil || user.Status == *f.Status) &&
+		strings.HasPrefix(user.Login, f.LoginPrefix)
+}
+
+// UserStore - где MyApi хранит пользователей. Методы возвращают копии,
+// менять пользователя можно только через Update
+type UserStore interface {
+	GetByLogin(login string) (*User, error)
+	GetByID(id uint64) (*User, error)
+	// Create сохраняет нового пользователя и проставляет ему ID, если тот не задан
+	Create(user *User) error
+	Update(user *User) error
+	Delete(id uint64) error
+	List(filter UserFilter) ([]*User, error)
+}
+
+// userIndex - пользователи в памяти с индексами по ID и логину. Блокировки - на совести хранилища
+type userIndex struct {
+	byID    map[uint64]*User
+	byLogin map[string]*User
+	nextID  uint64
+}
+
+func newUserIndex() userIndex {
+	return userIndex{
+		byID:    map[uint64]*User{},
+		byLogin: map[string]*User{},
+		nextID:  1,
+	}
+}
+
+func (idx *userIndex) get(id uint64) (*User, error) {
+	user, ok := idx.byID[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (idx *userIndex) getByLogin(login string) (*User, error) {
+	user, ok := idx.byLogin[login]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// checkCreate проверяет нового пользователя и выдаёт ему ID, сам индекс не меняет
+func (idx *userIndex) checkCreate(user *User) error {
+	if _, ok := idx.byLogin[user.Login]; ok {
+		return ErrUserExists
+	}
+	if user.ID == 0 {
+		user.ID = idx.nextID
+	} else if _, ok := idx.byID[user.ID]; ok {
+		return ErrUserExists
+	}
+	return nil
+}
+
+func (idx *userIndex) checkUpdate(user *User) error {
+	if _, ok := idx.byID[user.ID]; !ok {
+		return ErrUserNotFound
+	}
+	if other, ok := idx.byLogin[user.Login]; ok && other.ID != user.ID {
+		return ErrUserExists
+	}
+	return nil
+}
+
+func (idx *userIndex) checkDelete(id uint64) error {
+	if _, ok := idx.byID[id]; !ok {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// put добавляет или заменяет пользователя без проверок - их делают check*
+func (idx *userIndex) put(user *User) {
+	if old, ok := idx.byID[user.ID]; ok {
+		delete(idx.byLogin, old.Login)
+	}
+	copied := *user
+	idx.byID[user.ID] = &copied
+	idx.byLogin[user.Login] = &copied
+	if user.ID >= idx.nextID {
+		idx.nextID = user.ID + 1
+	}
+}
+
+func (idx *userIndex) delete(id uint64) {
+	if user, ok := idx.byID[id]; ok {
+		delete(idx.byLogin, user.Login)
+		delete(idx.byID, id)
+	}
+}
+
+func (idx *userIndex) list(filter UserFilter) []*User {
+	users := []*User{}
+	for _, user := range idx.byID {
+		if filter.match(user) {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+	return users
+}
+
+// InMemoryUserStore - пользователи в памяти процесса, пропадают при рестарте
+type InMemoryUserStore struct {
+	mu  sync.RWMutex
+	idx userIndex
+}
+
+func NewInMemoryUserStore(users ...*User) *InMemoryUserStore {
+	s := &InMemoryUserStore{idx: newUserIndex()}
+	for _, user := range users {
+		s.idx.put(user)
+	}
+	return s
+}
+
+func (s *InMemoryUserStore) GetByLogin(login string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.idx.getByLogin(login)
+}
+
+func (s *InMemoryUserStore) GetByID(id uint64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.idx.get(id)
+}
+
+func (s *InMemoryUserStore) Create(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.idx.checkCreate(user); err != nil {
+		return err
+	}
+	s.idx.put(user)
+	return nil
+}
+
+func (s *InMemoryUserStore) Update(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.idx.checkUpdate(user); err != nil {
+		return err
+	}
+	s.idx.put(user)
+	return nil
+}
+
+func (s *InMemoryUserStore) Delete(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.idx.checkDelete(id); err != nil {
+		return err
+	}
+	s.idx.delete(id)
+	return nil
+}
+
+func (s *InMemoryUserStore) List(filter UserFilter) ([]*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.idx.list(filter), nil
+}
+
+// Записи журнала FileUserStore, по одной json-строке на изменение
+const (
+	userLogPut    = "put"
+	userLogDelete = "delete"
+	userLogNextID = "next_id" // пишется при сжатии, чтобы ID удалённых не выдавались снова
+)
+
+type userLogRecord struct {
+	Op   string      `json:"op"`
+	User *storedUser `json:"user,omitempty"`
+	ID   uint64      `json:"id,omitempty"`
+}
+
+// storedUser - пользователь в журнале: в отличие от ответов API, вместе с хэшем пароля
+type storedUser struct {
+	*User
+	PasswordHash      string `json:"password_hash,omitempty"`
+	PasswordChangedAt int64  `json:"password_changed_at,omitempty"`
+}
+
+func newStoredUser(user *User) *storedUser {
+	return &storedUser{user, user.PasswordHash, user.PasswordChangedAt}
+}
+
+// Журнал сжимается, когда в нём больше userLogCompactMin записей и живых пользователей меньше половины
+const userLogCompactMin = 1024
+
+// FileUserStore - пользователи в памяти плюс журнал изменений на диске, из которого
+// они восстанавливаются при старте. Каждая запись журнала сбрасывается на диск до ответа
+type FileUserStore struct {
+	mu      sync.RWMutex
+	idx     userIndex
+	path    string
+	file    *os.File
+	size    int64 // длина журнала после последней целой записи
+	records int
+}
+
+// OpenFileUserStore читает журнал по пути path, создавая его при необходимости.
+// Недописанная последняя строка (процесс упал посреди записи) отбрасывается
+func OpenFileUserStore(path string) (*FileUserStore, error) {
+	s := &FileUserStore{idx: newUserIndex(), path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	valid := 0
+	for line := 1; valid < len(data); line++ {
+		end := bytes.IndexByte(data[valid:], '\n')
+		if end < 0 {
+			break
+		}
+		record := userLogRecord{}
+		if err := json.Unmarshal(data[valid:valid+end], &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if err := s.apply(record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		s.records++
+		valid += end + 1
+	}
+
+	s.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.file.Truncate(int64(valid)); err != nil {
+		s.file.Close()
+		return nil, err
+	}
+	if _, err := s.file.Seek(int64(valid), io.SeekStart); err != nil {
+		s.file.Close()
+		return nil, err
+	}
+	s.size = int64(valid)
+	return s, nil
+}
+
+func (s *FileUserStore) apply(record userLogRecord) error {
+	switch record.Op {
+	case userLogPut:
+		if record.User == nil || record.User.User == nil {
+			return errors.New("put without user")
+		}
+		user := *record.User.User
+		user.PasswordHash = record.User.PasswordHash
+		user.PasswordChangedAt = record.User.PasswordChangedAt
+		s.idx.put(&user)
+	case userLogDelete:
+		s.idx.delete(record.ID)
+	case userLogNextID:
+		if record.ID > s.idx.nextID {
+			s.idx.nextID = record.ID
+		}
+	default:
+		return fmt.Errorf("unknown op %q", record.Op)
+	}
+	return nil
+}
+
+// append пишет запись в журнал и только потом применяет её к памяти
+func (s *FileUserStore) append(record userLogRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(line, '\n'))
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		// Обрезаем хвост до последней целой записи: иначе следующие записи окажутся после битой строки,
+		// а не применённая к памяти запись всплывёт при следующем открытии
+		s.file.Truncate(s.size)
+		s.file.Seek(s.size, io.SeekStart)
+		return err
+	}
+	s.size += int64(len(line) + 1)
+	s.apply(record)
+	s.records++
+
+	if s.records > userLogCompactMin && s.records > 2*len(s.idx.byID) {
+		// Запись уже на диске, неудачное сжатие повторится на следующей
+		s.compact()
+	}
+	return nil
+}
+
+func (s *FileUserStore) GetByLogin(login string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.idx.getByLogin(login)
+}
+
+func (s *FileUserStore) GetByID(id uint64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.idx.get(id)
+}
+
+func (s *FileUserStore) Create(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.idx.checkCreate(user); err != nil {
+		return err
+	}
+	return s.append(userLogRecord{Op: userLogPut, User: newStoredUser(user)})
+}
+
+func (s *FileUserStore) Update(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.idx.checkUpdate(user); err != nil {
+		return err
+	}
+	return s.append(userLogRecord{Op: userLogPut, User: newStoredUser(user)})
+}
+
+func (s *FileUserStore) Delete(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.idx.checkDelete(id); err != nil {
+		return err
+	}
+	return s.append(userLogRecord{Op: userLogDelete, ID: id})
+}
+
+func (s *FileUserStore) List(filter UserFilter) ([]*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.idx.list(filter), nil
+}
+
+// Compact переписывает журнал так, что в нём остаются только живые пользователи
+func (s *FileUserStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+// compact пишет новый журнал рядом и подменяет им старый через rename,
+// так что при падении на диске остаётся либо старый, либо новый журнал целиком
+func (s *FileUserStore) compact() error {
+	users := s.idx.list(UserFilter{})
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.Encode(userLogRecord{Op: userLogNextID, ID: s.idx.nextID})
+	for _, user := range users {
+		encoder.Encode(userLogRecord{Op: userLogPut, User: newStoredUser(user)})
+	}
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	// rename становится надёжным только после fsync каталога
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	// Дописываем дальше в новый файл, позиция уже в его конце
+	s.file.Close()
+	s.file = tmp
+	s.size = int64(buf.Len())
+	s.records = len(users) + 1
+	return nil
+}
+
+func (s *FileUserStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}